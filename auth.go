@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultAuthMaxFailures = 5
+	defaultAuthLockout     = 15 * time.Minute
+	authCacheTTL           = 5 * time.Minute
+	authMaxTracked         = 10000
+)
+
+// webAuth checks HTTP basic auth credentials for the web interface against WEB_AUTH_USER and
+// WEB_AUTH_PASSWORD, or bcrypt hashes in an htpasswd file.  Users and client addresses with
+// too many failed logins in a row are locked out for a while.
+type webAuth struct {
+	envUser     string
+	envPwHash   [sha256.Size]byte // compared in constant time
+	users       map[string][]byte // bcrypt hashes from WEB_AUTH_FILE
+	dummyHash   []byte            // checked against for unknown users
+	maxFailures int
+	lockout     time.Duration
+	lock        sync.Mutex
+	failures    map[string]*authFailures // by "user:" + name and "ip:" + address
+	verified    map[[sha256.Size]byte]time.Time
+}
+
+// authFailures counts failed logins since the last success
+type authFailures struct {
+	count  int
+	last   time.Time
+	locked time.Time // locked out until
+}
+
+// webIdentity is who a request was made by, and the groups they are in
+type webIdentity struct {
+	user   string
+	groups []string
+}
+
+type webIdentityKey struct{}
+
+func newWebAuthFromEnv(c *config) (*webAuth, error) {
+	file := os.Getenv("WEB_AUTH_FILE")
+	if len(c.authUser) < 1 && len(file) < 1 {
+		return nil, nil
+	}
+	a := &webAuth{
+		users:       map[string][]byte{},
+		maxFailures: defaultAuthMaxFailures,
+		lockout:     defaultAuthLockout,
+		failures:    map[string]*authFailures{},
+		verified:    map[[sha256.Size]byte]time.Time{},
+	}
+	if len(c.authUser) > 0 {
+		if len(c.authPw) < 1 {
+			return nil, fmt.Errorf("Must set WEB_AUTH_PASSWORD with WEB_AUTH_USER.")
+		}
+		a.envUser = c.authUser
+		a.envPwHash = sha256.Sum256([]byte(c.authPw))
+	}
+	if len(file) > 0 {
+		if err := a.loadHtpasswd(file); err != nil {
+			return nil, fmt.Errorf("Error reading WEB_AUTH_FILE: %s", err.Error())
+		}
+		a.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("traildash"), bcrypt.DefaultCost)
+	}
+	if len(os.Getenv("WEB_AUTH_MAX_FAILURES")) > 0 {
+		n, err := strconv.Atoi(os.Getenv("WEB_AUTH_MAX_FAILURES"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid WEB_AUTH_MAX_FAILURES.  Must be a positive number of attempts.")
+		}
+		a.maxFailures = n
+	}
+	if len(os.Getenv("WEB_AUTH_LOCKOUT")) > 0 {
+		d, err := time.ParseDuration(os.Getenv("WEB_AUTH_LOCKOUT"))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid WEB_AUTH_LOCKOUT.  Must be a duration such as '15m'.")
+		}
+		a.lockout = d
+	}
+	return a, nil
+}
+
+// loadHtpasswd reads "user:hash" lines, as written by "htpasswd -B".  Only bcrypt hashes are
+// accepted; the MD5 and SHA-1 schemes are too quick to brute force.
+func (a *webAuth) loadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || len(kv[0]) < 1 {
+			return fmt.Errorf("line %d is not 'user:hash'", n)
+		} else if _, err := bcrypt.Cost([]byte(kv[1])); err != nil {
+			return fmt.Errorf("line %d for %s is not a bcrypt hash (use htpasswd -B)", n, kv[0])
+		}
+		a.users[kv[0]] = []byte(kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(a.users) < 1 {
+		return fmt.Errorf("no users in %s", path)
+	}
+	return nil
+}
+
+// wrap requires a valid login for a handler, making the user available to it
+func (a *webAuth) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pw, ok := r.BasicAuth()
+		if !ok {
+			authChallenge(w)
+			return
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if until := a.lockedUntil(user, ip); !until.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if !a.check(user, pw) {
+			a.failed(user, ip)
+			authChallenge(w)
+			return
+		}
+		a.succeeded(user, ip)
+		h(w, withIdentity(r, &webIdentity{user: user}))
+	}
+}
+
+func authChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="traildash", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// withIdentity passes who made a request on to the handlers
+func withIdentity(r *http.Request, id *webIdentity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), webIdentityKey{}, id))
+}
+
+// requestIdentity is who a request was authenticated as, or nil
+func requestIdentity(r *http.Request) *webIdentity {
+	id, _ := r.Context().Value(webIdentityKey{}).(*webIdentity)
+	return id
+}
+
+// check compares credentials without revealing how much of them matched.  Browsers send them
+// with every request, so recent successful bcrypt checks are remembered rather than repeated.
+func (a *webAuth) check(user, pw string) bool {
+	if len(a.envUser) > 0 {
+		userHash := sha256.Sum256([]byte(user))
+		envUserHash := sha256.Sum256([]byte(a.envUser))
+		pwHash := sha256.Sum256([]byte(pw))
+		userOK := subtle.ConstantTimeCompare(userHash[:], envUserHash[:])
+		pwOK := subtle.ConstantTimeCompare(pwHash[:], a.envPwHash[:])
+		if userOK&pwOK == 1 {
+			return true
+		}
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + pw))
+	a.lock.Lock()
+	expires, ok := a.verified[key]
+	a.lock.Unlock()
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+	hash, ok := a.users[user]
+	if !ok {
+		// spend as long as a real check, so unknown users can't be told apart by timing
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(pw))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pw)) != nil {
+		return false
+	}
+	a.lock.Lock()
+	if len(a.verified) >= authMaxTracked {
+		a.verified = map[[sha256.Size]byte]time.Time{}
+	}
+	a.verified[key] = time.Now().Add(authCacheTTL)
+	a.lock.Unlock()
+	return true
+}
+
+// lockedUntil is when a lockout of the user or the client address ends, or zero if neither is
+// locked out
+func (a *webAuth) lockedUntil(user, ip string) time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var until time.Time
+	for _, key := range []string{"user:" + user, "ip:" + ip} {
+		if f, ok := a.failures[key]; ok && time.Now().Before(f.locked) && f.locked.After(until) {
+			until = f.locked
+		}
+	}
+	return until
+}
+
+// failed counts a failed login, locking out the user and the address once they reach the
+// maximum
+func (a *webAuth) failed(user, ip string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	if len(a.failures) >= authMaxTracked {
+		for key, f := range a.failures {
+			if now.Sub(f.last) > a.lockout && now.After(f.locked) {
+				delete(a.failures, key)
+			}
+		}
+	}
+	for _, key := range []string{"user:" + user, "ip:" + ip} {
+		f, ok := a.failures[key]
+		if !ok || now.Sub(f.last) > a.lockout { // old failures are forgotten
+			f = &authFailures{}
+			a.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count >= a.maxFailures {
+			f.count = 0
+			f.locked = now.Add(a.lockout)
+			log.Printf("Web login locked out for %s after %d failures (%s)", key, a.maxFailures, a.lockout)
+		}
+	}
+}
+
+// succeeded clears the failures of a user and address
+func (a *webAuth) succeeded(user, ip string) {
+	a.lock.Lock()
+	delete(a.failures, "user:"+user)
+	delete(a.failures, "ip:"+ip)
+	a.lock.Unlock()
+}
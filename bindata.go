@@ -161,7 +161,7 @@ func kibana_app_dashboards_blank_json() (*asset, error) {
 	return a, nil
 }
 
-var _kibana_app_dashboards_default_json = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xec\x5a\xcd\x6e\xdc\x36\x10\xbe\xfb\x29\x16\xec\xad\x88\x83\x95\x5d\xa7\x49\x6f\x6e\x93\x14\x39\xa4\x2d\x9a\xf4\x50\x04\x86\x40\x4b\xdc\x15\x61\x4a\x54\x49\x6a\xd7\xeb\xc2\xef\xde\x21\x29\x69\x45\x72\xb4\x8e\x53\xd7\x0d\x90\xe4\x64\xcd\xf0\x6f\xfe\xbe\x99\x9d\xc9\xdf\x47\x8b\x05\x31\xdc\x08\x46\x7e\x58\x90\x9f\x84\xec\xca\xf7\x8a\x72\x41\x9e\x58\x86\x66\x6a\xc3\x0b\xa6\x81\x67\x17\x02\xe5\xaf\x8e\xa9\xdd\xf8\x09\x04\xc1\xb5\x99\x7c\x03\x25\x0b\x3e\x81\xc0\x4b\xa0\x64\x4f\xa6\xa4\x42\x0a\xa9\xec\x8d\xdf\xbc\x3a\xff\xf1\xf9\xe9\x0b\x12\x70\xa9\xe0\xd4\xde\x49\x42\x72\xcb\x1b\x20\xae\xa8\xd0\x2c\xa0\x9b\x5d\xeb\x5e\x2f\xba\x82\x35\x2c\xdc\xc3\x1a\x7a\xe9\x64\x33\xaa\x0b\x77\x0d\x92\x90\x6f\xc9\x48\xbe\xed\xff\xba\x1d\x56\xc2\xdb\xed\x43\x3e\x8c\x2b\xb2\xfe\xaf\x8b\xa3\xc9\x3a\xb2\xe2\xc2\x30\x85\xa9\x25\x39\xa9\xdf\x78\xd4\x6f\x26\x4a\x6e\xf7\x37\x8c\xdb\x47\x8b\xfc\xda\x1a\x2e\x1b\x3d\x0a\x45\x2a\xc6\xd7\x95\x3d\x9a\x64\x67\xcb\xf6\x7a\xcf\x60\x25\x37\x88\xac\x56\xd5\x82\xb6\x9a\xc5\xaa\x1b\x18\xd8\x9e\x96\x36\x4c\x84\x82\x07\x16\xd5\xb0\x00\xb8\xcf\x42\x55\xe3\x0f\x08\x4c\x54\x81\x5a\xe4\x5a\xd1\x3a\xb4\x92\x90\xb4\xe4\xcd\xfa\x15\x9c\xe0\xdc\x22\xb5\x71\x2d\x4b\x77\x40\x21\xbb\xc6\x84\x9b\x0d\xaf\x59\xbe\xe2\x4c\x58\x37\x23\xaf\x36\xac\x31\xef\x81\x14\x2e\xda\x50\xd1\xed\x57\x35\x9d\x10\x01\xfb\xfa\x98\x5e\x73\x8d\xbd\x7c\x37\xcb\xd1\x05\x75\xc2\x86\x9e\xbd\xcb\x57\x52\xd5\xd4\x59\xa8\x91\xb1\x3b\xae\x95\x0b\x86\xa9\x32\xad\x70\xf4\x1a\x79\x94\x65\x38\x8f\x5f\x4e\x88\xb7\x89\x0f\xf3\x49\x78\x26\xea\xa2\x42\x90\xe8\xcc\xd8\xa3\xfd\xbf\x2c\xf8\xbe\x98\xbd\x91\x36\x8d\x34\xd4\xb0\xf4\xca\x31\xd2\x12\xe3\x85\xc1\x16\x71\x34\xbf\xb1\x9b\x4e\x96\x11\x7d\x34\x68\xee\x7c\x27\xde\x25\x95\x41\xa4\x20\xb9\x2e\xa4\x8a\x57\x03\xbd\x64\xba\x20\x1f\x2b\x62\x67\x64\xce\x1b\x83\xfa\xa1\x62\x5a\x8a\xce\xc6\xa4\xb5\xfc\x32\x78\x35\x81\x4d\x00\x98\x54\xb8\xe8\x8c\x5c\x7c\xe0\xa5\xca\x77\x17\xc6\x02\x66\x3a\xa1\xd4\x31\xe5\x2c\xa1\x64\xcb\x84\x74\x9a\x92\xb2\x2a\x59\x94\x50\xb2\x93\x94\x54\x26\x94\x6d\x42\xd9\x4d\xb5\x7c\x11\x06\x39\x6f\x9c\xab\xa6\x4a\x05\xf8\xb4\x3a\x5b\x26\xcb\xb7\xbc\x34\x15\x70\x4e\xc3\x3c\x20\x41\x99\xf8\x49\x8e\xa5\x00\x4c\x3a\xcb\x3f\x0b\x78\x97\x54\xe1\x71\x6c\x68\x71\x85\x32\xda\xdd\x1c\x9e\xdd\x48\x59\xc3\x03\xaf\xd0\x03\x65\x8f\xd9\x08\x4b\xb0\x35\x6b\x4a\xf4\xb2\x4a\x6e\xf3\x21\x4c\x12\xae\xf3\x1e\x5a\x18\xbe\x41\x9f\xe3\x8f\xcd\x1d\x38\xa2\xf7\x5a\x8c\xbc\xb1\x68\x04\x9e\x79\x69\x53\x0e\x64\xab\x50\x71\x4c\x41\xf2\x34\x74\x8d\x46\x30\xb9\x61\x4a\xf6\x56\x4a\xce\x2e\x01\x83\x36\xb4\x7f\x1a\x92\x9d\xa5\x14\x86\xb7\x29\x60\x78\x48\x1e\x32\x43\xd1\xd5\x9d\xf0\xa7\x60\xd8\x91\x53\x9d\x0f\x65\x81\x7d\xc1\x6c\xf4\x22\xb5\xcc\xc2\xe5\x04\x3d\x49\xf3\x4f\xf0\xb4\xc6\x94\x9a\x49\x3f\x9f\x9c\xf0\xc0\x6c\xb5\xbe\x6f\xb2\x1b\xb1\xef\x0f\xb0\xd3\x9b\x12\x1e\xcf\xcd\xee\x69\x07\x1f\xbf\xd0\x38\xb1\xb1\xeb\x42\x74\x0e\xec\x3f\x84\xe1\x56\x73\xad\xe1\x1a\xd4\x41\x4d\xe5\xaa\x95\xd4\x09\x3d\x18\x67\x61\x24\x4a\x55\xba\xe5\x58\xf2\xd5\x66\x27\x90\x64\xb0\x92\x8d\x39\xee\x4f\x03\x54\x6a\x0d\x99\x35\x58\x29\x9b\x0e\xc7\x5a\x03\x55\x15\xca\x10\xf4\xd2\x97\x27\x89\x00\x54\x29\xda\xac\x59\xcd\x1c\x7c\x93\x4a\x2a\x0e\x6e\x6f\x68\x98\x05\x49\x51\x51\x97\x3f\x2c\x26\x44\x1c\x2b\x22\x53\x79\x2b\x5d\x01\x4a\x2f\x65\xe4\x90\x87\x50\xe1\xf1\xd3\xb1\x19\x4e\x46\xfc\xcc\x00\xb0\x39\x21\x8d\x4c\x14\xe0\x62\x6f\x74\xb3\xb8\x9e\xea\x23\xe8\xdc\xe1\xcd\xc2\x3a\xa1\x4e\x6b\xe4\xd1\xdb\xa0\xcc\x31\xf0\x23\x61\xb0\xd4\xb4\x30\x4e\xeb\xd9\x9f\x15\x6d\x2b\xac\x9a\x3d\xf9\xff\xaa\xd9\x3b\xc3\xfe\xf4\xa3\xc3\x7e\xad\x64\xd7\xa6\x19\xbe\x64\x2b\xda\x09\x33\x9f\x1b\x0f\xc0\xc5\xe3\x3b\x55\x58\x4b\x7f\x12\xe4\xa4\xaa\x1c\x30\x07\x51\xb2\x87\x89\xe7\x5f\x0a\xe6\x78\xe7\x79\x38\xd4\x19\x63\xcb\x99\x6b\xf1\x1e\x5c\x29\x46\x82\x87\x41\x89\x7f\x9f\x3f\xbf\x06\xd2\xc3\x05\xd2\x7c\xf2\xfe\x62\xb2\x77\xcb\x0f\xc7\x51\xfa\xfb\xfb\xee\x58\x39\x14\x68\x9f\x4b\x18\x3d\x76\x19\xea\x94\xf5\x4e\x76\xf0\x13\xe1\xbf\x2f\x3e\xcf\xbe\x14\xef\xfd\x5a\x7b\x1e\xa8\x3d\x7d\x7c\x7a\x9f\x7b\xb0\xe2\xb3\xff\x1d\x88\x54\x9f\xcf\x3e\xe3\xea\x33\x3b\x79\xbc\xac\x99\x96\x26\x33\x59\xa5\xa5\x6b\x96\xb6\x58\xe4\x6a\xa5\x99\x55\x68\xb8\x18\xed\xd6\xcd\x74\x6a\x17\x69\xb3\xee\xe2\xde\x51\xff\xe2\x50\xd0\x43\x54\xa9\x95\x90\x5b\xbb\xb0\xe6\xcd\x71\xef\x05\x29\xf4\x21\xad\xba\xd9\x37\xe3\x19\x7e\x31\x8f\x9d\xc0\x3a\xdf\xea\xdf\xd9\xda\xb6\x12\x23\xc6\xdd\x3f\xfb\xc7\xa7\x20\xbd\x51\x7f\xd9\x9b\xdf\xce\xcb\x52\x31\xad\xe7\x35\x59\x81\xdc\xa2\x8f\x80\x08\xbf\xad\xc5\xe6\x9c\xac\x62\xb4\xc4\x11\x1c\x9c\x62\x06\xf4\x3f\x2b\xe0\x72\xc6\xcd\xfb\x09\x0d\x12\x76\x00\x5d\xae\x7d\x48\xde\x21\xf1\x60\x14\xaf\x5f\xd3\xc2\xe7\xcc\xd3\x28\x2a\x1a\xa9\x6a\xeb\x1d\x33\x3d\xb8\xd9\xce\x54\x98\x47\x84\xc8\x47\xff\x43\x12\x90\x2c\xa8\x70\x2e\x38\xd3\xe7\x7b\x9d\x8e\x42\xee\x89\x9f\x47\xfd\x02\x0c\x6b\x08\x6f\x4a\x76\x3d\x5a\x2b\xe8\x79\x4f\xea\x2d\xf0\x05\x03\x0c\x8b\x5f\xe4\x83\x90\x6b\x50\xaa\xae\x8e\x2f\xfe\x84\x7f\x4f\xdf\xbe\x7d\xfa\xf2\xe5\xb0\x6e\xc0\x26\x9b\xe2\xad\x5e\xcc\x38\x7d\x04\xe6\x96\xaa\x7a\xaf\x8b\xbe\xef\xe7\xe7\x66\x03\x0c\x90\x92\xaa\x2b\x3f\xad\x5c\xc1\x4e\x1b\xdc\x53\xb5\x79\xf8\xcd\x2b\x1e\x36\x46\x5d\xf5\x33\x99\xd8\x11\x4d\x37\x2c\x5f\x27\x2e\xe1\xe9\x4c\x50\x0d\x5a\xd2\x8c\xaa\xa2\x0a\xd4\xee\xf9\xce\x24\x08\x7d\x2f\x5b\xcc\x31\xac\x6e\x67\xc8\xb9\x31\x22\x47\xc6\x96\xe1\x0a\x2b\xf9\xe9\x72\xe8\xc8\x3b\x71\x86\xe7\x4f\xb6\x38\xf2\x81\xd7\xa7\xfc\x3c\x9a\xc7\xf8\x25\x88\x80\x15\x2f\xa7\x5e\xe3\x8d\xd2\x76\x42\x94\x72\xdb\x60\x13\xcd\x3e\xc5\xf8\x16\xf7\xdd\x19\x35\xf4\xcb\x91\x8c\x0c\x92\xec\x5c\xb8\x61\x71\x53\xdd\x8f\x1a\xdd\xda\x3d\x4e\xec\x67\xbd\xfb\x00\x50\x50\xb1\xd5\x97\xce\x17\xf6\x7d\xcf\x70\x6e\x8c\x57\x15\xbd\x40\x7e\xf6\x6b\x71\x0f\x13\x2a\x78\xd2\x8c\x4c\xc8\x5d\x63\x04\x36\x74\x33\xaf\x4b\x1b\xed\x2d\x2f\xae\x26\xcd\xfc\x7b\x2b\x74\x16\xed\xfc\x5c\x75\x3f\xcb\x98\x68\x31\x18\x3c\x91\x2c\xfa\x9c\x0e\x8e\xc8\xb3\xe0\x2b\x9c\x2a\x91\x93\xef\xc2\xcf\xe9\x80\x89\x7c\x1f\x7c\x59\x5f\x47\x4c\xb7\x82\x0c\x57\xe5\xf8\x6c\x8d\x9c\xe9\xe0\xee\xa5\x0e\x0f\x0c\xb9\x81\x0c\x07\x05\x0c\x27\x6a\x91\xbc\xa1\x80\x19\xf2\x68\xab\xd6\x03\xd3\x6a\xfc\x7f\x2c\x80\xf5\xb6\x31\xc9\x3b\x5e\xee\x06\xca\xcb\xc8\x6d\x7a\xcd\x8c\x01\x7a\x7b\xf4\x4f\x00\x00\x00\xff\xff\x89\xb0\xa0\x07\xe9\x21\x00\x00")
+var _kibana_app_dashboards_default_json = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xed\x5a\x4b\x6f\x1b\x37\x10\xbe\xfb\x57\x08\xec\xad\xb0\x0d\xc9\xaa\xdd\xa4\x37\xb7\x71\x8a\x1c\xd2\x16\x89\x7b\x28\x0c\x63\x41\xed\x52\x5a\xc2\xdc\xe5\x96\xe4\x4a\x96\x03\xff\xf7\x0e\xb9\x0f\x2d\xc9\x59\x39\x4e\x5d\x37\x40\x9c\x93\x77\x86\xaf\x19\x7e\xf3\xcd\x88\x93\x4f\x07\x93\x09\x31\xdc\x08\x46\x7e\x9a\x90\x5f\x84\xac\xb3\x4b\x45\xb9\x20\x87\x56\xa1\x99\x5a\xf3\x94\x69\xd0\x7d\x82\x6f\x90\xfc\x5d\x33\xb5\xed\x3f\x41\x20\xb8\x36\x83\x6f\x90\xcc\xbc\x4f\x10\xf0\x0c\x24\xb3\xc3\xa1\x28\x95\x42\x2a\xbb\xe3\x77\x17\xe7\x3f\xbf\x9a\xbf\x26\x9e\x96\x0a\x4e\xed\x9e\xc4\x17\x57\xbc\x04\xe1\x92\x0a\xcd\x3c\xb9\xd9\x56\xee\xf4\xa2\x4e\x59\xc9\xfc\x39\xac\xa4\x0b\x67\x9b\x51\xb5\x3f\xab\xb3\x84\x7c\x4f\x7a\xf1\x7d\xfb\xd7\x7d\x37\x12\xce\x6e\x0f\x72\xd5\x8f\x98\xb5\x7f\x5d\x1f\x0c\xc6\x91\x25\x17\x86\x29\xcc\x2d\xd1\x4a\xed\xc4\x83\x76\x32\x51\x72\xb3\xdb\xa1\x9f\xde\xdf\xc8\xef\x95\xe1\xb2\xd4\xbd\x51\x24\x67\x7c\x95\xdb\xa5\xc9\xec\x74\x5a\xdd\xee\x14\x2c\xe3\x06\xb1\xd5\xba\x5a\xd0\x4a\xb3\xd0\x75\x9d\x02\x9b\x53\xd1\x92\x09\xdf\x70\xef\x46\x35\x0c\x00\xed\x99\xef\x6a\xfc\x00\xde\x15\xe5\xe0\x16\xb9\x52\xb4\xf0\x6f\x49\x48\x9a\xf1\x72\x75\x01\x2b\x38\x58\xc4\x77\x5c\xc8\xcc\x2d\x90\xca\xba\x34\xfe\x64\xc3\x0b\x96\x2c\x39\x13\x16\x66\xe4\x62\xcd\x4a\x73\x09\x22\x7f\xd0\x9a\x8a\x7a\x37\xaa\xac\x85\xf0\xd4\xb7\x47\xf4\x96\x6b\xec\xe4\xdb\x51\x8d\x4e\xa9\x33\xd6\x47\xf6\x36\x59\x4a\x55\x50\x77\x43\xa5\x0c\xe1\xb8\x52\x2e\x18\x86\xce\xb4\xc6\xd1\x5b\xe4\x50\x56\xe1\x10\x3f\x1d\x08\xef\x23\x0c\xf3\x41\x78\x46\xee\xa2\x42\x90\x60\xcd\x10\xd1\x3e\xae\x87\xe8\xc6\x76\xa4\x65\x29\x0d\x35\x2c\xde\xb2\x8f\xb4\xe8\xf2\xfc\x60\x0b\x34\x9a\xdf\xd9\x49\x27\xd3\x40\xde\x5f\x68\xe2\xb0\x13\xce\x92\xca\x20\x56\x90\x44\xa7\x52\x85\xa3\x41\x9e\x31\x9d\x92\xcf\x35\xb1\x36\x32\xe1\xa5\x41\x71\xa8\x98\x96\xa2\xb6\x31\x69\x6f\x7e\xea\x9d\x9a\xc0\x24\x20\x4c\x2a\x5c\x74\x06\x10\xef\x74\xb1\xf3\xdd\x86\xa1\x81\x33\x1d\x49\x8a\x50\x72\x1a\x49\x66\xd3\x48\x34\x8f\x45\xb3\x3c\x1a\x14\x49\x66\x27\xb1\x28\x8b\x24\x9b\x48\xb2\x1d\x7a\xf9\xda\x0f\x72\x5e\x3a\xa8\xc6\x4e\x05\xfa\xb4\x3e\x9b\x46\xc3\x37\x3c\x33\x39\x68\xe6\x7e\x1e\x90\xe0\x4c\x7c\x25\xa7\x52\x40\x26\xb5\xd5\x9f\x7a\xba\x05\x55\x78\x1c\x1b\x9a\xde\xa0\x8a\x6a\x3b\xc6\x67\x77\x52\x16\x70\xc0\x1b\x74\x41\xd9\x72\x36\xa2\x12\x6c\xc5\xca\x0c\xdd\x2c\x97\x9b\xa4\x0b\x93\x48\xeb\xd0\x43\x53\xc3\xd7\x6c\x7c\xd9\xc4\x91\x23\xba\xaf\xe5\xc8\x3b\xcb\x46\x80\xcc\x85\x4d\x39\x90\xad\x7c\xc7\x31\x05\xc9\xd3\xd0\x15\x1a\xc1\xe4\x8e\x29\xd9\xde\x52\xb4\x76\x06\x1c\xb4\xa6\xed\xd1\x90\xec\x2c\xa5\x30\xbc\x8a\x09\xa3\xa1\xe4\x2e\x33\xa4\x75\x51\x8b\x66\x15\x8c\x3b\x12\xaa\x93\xae\x2c\xb0\x27\x18\x8d\x5e\xa4\x96\x99\xb8\x9c\xa0\x07\x69\xfe\x10\x4f\x6b\x4c\xa9\x91\xf4\xf3\xc5\x09\x0f\xae\xad\xd0\x8f\x4d\x76\x3d\xf7\xfd\x09\xf7\xf4\x2e\x83\xc3\x73\xb3\x3d\xae\xe1\xe3\x37\x1a\x26\x36\x76\x9b\x8a\xda\x91\xfd\x95\x1f\x6e\x05\xd7\x1a\xb6\x41\x01\x6a\x72\x57\xad\xc4\x20\x6c\xc8\x78\xe6\x47\xa2\x54\x99\x1b\x8e\x25\x5f\x6d\xb6\x02\x49\x06\x4b\x59\x9a\xa3\x76\x35\x60\xa5\xca\x90\xd1\x0b\xcb\x64\x59\xe3\x5c\x6b\xa0\xaa\x42\x15\x82\x2e\x9a\xf2\x24\x32\x80\x2a\x45\xcb\x15\x2b\x98\xa3\x6f\x92\x4b\xc5\x01\xf6\x86\xfa\x59\x90\xa4\x39\x75\xf9\xc3\x72\x42\xa0\xb1\x26\x32\x95\x54\xd2\x15\xa0\x74\x21\x03\x40\xee\x63\x85\xe7\x4f\xc7\xa6\x5b\x19\xc1\x99\x01\x62\x73\x46\x1a\x19\x39\xc0\xc5\x5e\x0f\x33\x82\x47\xd0\xb9\xe3\x9b\x89\x05\xa1\x8e\x6b\xe4\x1e\x6d\x50\xe6\x18\xf8\x91\xd0\xdd\xd4\xb0\x30\x8e\xeb\xd9\x5f\x15\xad\x72\xac\x9a\x3d\xf9\xff\xaa\xd9\x07\xc3\x7e\xfe\xd9\x61\xbf\x52\xb2\xae\xe2\x0c\x9f\xb1\x25\xad\x85\x19\xcf\x8d\x7b\xe8\xe2\xf9\x41\xe5\xd7\xd2\x5f\x44\x39\xb1\x2b\x3b\xce\x41\x9c\xdc\xd0\xc4\xab\x6f\x85\x73\x1a\xf0\x3c\x1d\xeb\xf4\xb1\xe5\xae\x6b\x72\x09\x50\x0a\x99\xe0\x69\x58\xe2\xdf\xe7\xcf\x97\x40\x7a\xba\x40\x1a\x4f\xde\xdf\x4c\xf6\xae\xf8\xfe\x38\x8a\x7f\x7f\x3f\x1c\x2b\xfb\x02\xed\x6b\x09\xa3\xe7\x2e\x43\x9d\xb3\x3e\xca\x1a\x7e\x22\xfc\xf7\xc5\xe7\xe9\x4b\xed\xf9\x52\x7b\xb6\xf1\xd9\x60\xee\xc9\x8a\xcf\xf6\x77\x20\x52\x7d\x9e\x7d\xc5\xd5\xe7\xec\xe4\xf9\xb2\x66\x5c\x9a\x8c\x64\x95\x8a\xae\x58\xfc\xc4\x22\x97\x4b\xcd\x4c\xf4\x94\x83\xbe\xd6\x8d\xbc\xd4\x22\x8f\x75\xd7\x8f\x8e\xfa\xd7\xfb\x82\x1e\xa2\x4a\x2d\x85\xdc\xd8\x81\x05\x2f\x8f\x5a\x14\xc4\xd4\xa7\x1f\x71\x66\x3c\xc3\x4f\xc6\xb9\x13\x54\xe7\x1b\xfd\x81\xad\xec\x53\x62\xa0\x78\xf8\x67\x7f\x7f\x14\xe4\x6d\xb4\xd9\xec\xdd\x1f\xe7\x59\xa6\x98\xd6\xe3\x9e\xcc\xc1\x6e\xd1\x46\x40\xc0\xdf\xf6\xc6\xc6\x40\x96\x33\x9a\xe1\x0c\x0e\xa0\x18\x21\xfd\xaf\x8a\xb8\xdc\xe5\x26\x6d\x87\x06\x09\x3b\xa0\x2e\xf7\x7c\x48\x3e\x22\xf1\x60\x14\x2f\xde\xd2\xb4\xc9\x99\xf3\x20\x2a\x4a\xa9\x0a\x8b\x0e\xbd\xb7\x4e\x8f\x5f\xa6\x82\xee\x97\x48\x7a\xfc\x21\x09\x48\xa6\x54\x38\x08\x8e\xbc\xf3\xbd\x8d\x5b\x21\x8f\xe4\xcf\x83\x76\x00\xc6\x35\x84\x97\x19\xbb\xdd\x35\x04\x87\x6f\xde\x83\x7a\x0b\xb0\x60\x40\x61\xf9\x8b\x5c\xa5\xd6\x60\x63\x0d\x3e\xba\xfe\x0b\xfe\x1d\xbf\x7f\x7f\xfc\xe6\x4d\x37\xb2\x63\x27\x9b\xe4\xfb\x81\x9d\x72\x43\x55\xb1\xf3\x46\xfb\xf2\xd7\x74\xce\x3a\x22\x20\x19\x55\x37\x4d\xbf\x72\x09\x33\x6d\x78\x0f\x1d\xd7\x10\x70\x92\x73\xff\x69\xd4\xd5\x3f\x83\x9e\x1d\xd1\x74\xcd\x92\x55\x04\x8a\x46\xce\x04\xd5\xe0\x27\xcd\xa8\x4a\x73\xcf\xf1\x8d\xde\x5d\x0a\x22\xdf\xd9\x16\x6a\x0c\x2b\xaa\x11\x71\x62\x8c\x48\x90\xc6\xa5\x3f\xc2\x5a\x3e\x9f\x76\x6f\xf2\xce\x9c\xee\xf8\x83\x29\x4e\xbc\xe7\xf4\xb1\x3e\x09\x3a\x32\xcd\x10\xc4\xc0\x9c\x67\x43\xdc\x34\x97\x52\xd5\x42\x64\x72\x53\x62\x3d\xcd\x36\xc9\x34\x8f\xdc\x0f\xe7\x54\x1f\x99\xbd\x18\x69\x25\xd9\xce\x70\xc9\xc2\x67\xf5\xa6\xd9\xe8\xc6\xee\x98\x62\xd7\xed\xdd\x85\x80\x82\x9a\xad\x58\x38\x2c\xec\x5e\x3e\xfd\xce\x31\x5e\x57\xb4\x06\x35\xdd\x5f\xcb\x7c\x98\x51\xde\x91\x46\x6c\x42\xf6\xea\x63\xb0\xa4\xeb\x71\x5f\xda\x78\xaf\x78\x7a\x33\x78\xce\x7f\xb4\x43\x47\xf9\xae\xe9\xac\xee\xba\x19\x03\x2f\x7a\xad\x27\x32\x0b\x3e\x87\xad\x23\x72\xe6\x7d\xf9\x7d\x25\x72\xf2\x83\xff\x39\x6c\x31\x91\x1f\xbd\x2f\x8b\x75\xe4\xea\x96\x90\xe3\xf2\x04\xef\xae\x91\x53\xed\xed\x3d\xd5\xfe\x82\xbe\xd6\xb3\x61\xaf\x81\x7e\x4f\x2d\xb0\xd7\x37\x70\x86\x1c\xda\xba\x75\x4f\xbf\x1a\xff\x3f\x0b\x70\x7b\x9b\x50\xd4\x00\x2f\x71\x2d\xe5\x69\x00\x9b\xd6\x33\x7d\x80\xde\x1f\xfc\x03\x8e\x92\x15\x1d\xeb\x21\x00\x00")
 
 func kibana_app_dashboards_default_json_bytes() ([]byte, error) {
 	return bindata_read(
@@ -176,7 +176,7 @@ func kibana_app_dashboards_default_json() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindata_file_info{name: "kibana/app/dashboards/default.json", size: 8681, mode: os.FileMode(436), modTime: time.Unix(1424968096, 0)}
+	info := bindata_file_info{name: "kibana/app/dashboards/default.json", size: 8683, mode: os.FileMode(436), modTime: time.Unix(1792365328, 0)}
 	a := &asset{bytes: bytes, info:  info}
 	return a, nil
 }
@@ -201,6 +201,26 @@ func kibana_app_dashboards_guided_json() (*asset, error) {
 	return a, nil
 }
 
+var _kibana_app_dashboards_insights_json = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xed\x5a\x4b\x53\x1c\x37\x10\xbe\xf3\x2b\xb6\x94\x5b\x0a\xa8\x5d\x08\xc4\xe4\x86\x01\xa7\x38\x38\x49\x19\x72\x48\x51\xd4\x94\x76\x46\xbb\xa3\x42\x23\x4d\x24\xcd\x3e\x70\xf1\xdf\xdd\xd2\x3c\x76\xf4\x98\x05\x1c\x82\x5d\x65\x7c\x62\xba\xf5\xea\xd6\xd7\x5f\xf7\xaa\xfd\x79\x67\x34\x42\x9a\x6a\x46\xd0\x6f\x23\x74\xc6\x44\x95\x5d\x4b\x4c\xd9\xe8\x92\x2b\x3a\xcf\xb5\x42\xbb\x66\x84\x22\x72\x41\x53\xa2\x60\xd0\x67\xf8\x06\xc9\xbf\x15\x91\xeb\xee\x13\x04\x8c\x2a\xdd\xfb\x06\xc9\xc4\xf9\x04\x01\xcd\x40\x32\xd9\xed\x8b\x52\xc1\x84\x34\x5b\xff\x74\x71\xfa\xfe\xdd\xe1\x09\x72\xb4\x98\x51\x6c\xf6\x44\xae\xb8\xa4\x1c\x84\x33\xcc\x14\x71\xe4\x7a\x5d\x5a\x33\x58\x95\x12\x4e\xdc\x39\x84\xe3\xa9\x35\x52\xcb\xca\x9d\xd5\x5a\x82\x7e\x46\x9d\xf8\xa1\xf9\xeb\xa1\x1d\x09\x67\x37\x07\xb9\xe9\x46\x4c\x9a\xbf\x6e\x77\x7a\xe3\xd0\x8c\x32\x4d\x64\xcc\x2d\xc1\x4a\xcd\xc4\x9d\x66\x32\x92\x62\xb9\xd9\xa1\x9b\xde\x5d\xcd\x9f\xa5\xa6\x82\xab\xce\x28\x94\x13\x73\x3f\x46\x35\x39\x1a\x97\xab\x8d\x82\x64\x54\x47\x6c\x35\xae\x66\xb8\x54\xc4\x77\x5d\xab\x88\xcd\x29\x31\x27\xcc\x35\xdc\xb9\x51\x05\x03\x40\x7b\xec\xba\x3a\x7e\x00\xe7\x8a\x72\x70\x8b\x98\x4b\x5c\xb8\xb7\xc4\x04\xce\x28\x9f\x5f\xc0\x0a\x16\x16\xe1\x1d\x17\x22\xb3\x0b\xa4\xa2\xe2\xda\x9d\xac\x69\x41\x92\x19\x25\xcc\xc0\x0c\x5d\x2c\x08\xd7\xd7\x20\x72\x07\x2d\x30\xab\x36\xa3\x78\xc5\x98\xa3\x5e\xed\xe1\x15\x55\xb1\x93\xaf\x07\x35\x2a\xc5\xd6\x58\x17\xd9\xeb\x64\x26\x64\x81\xed\x0d\x71\xe1\xc3\x71\x2e\x6d\x30\xf4\x9d\x69\x8c\xc3\xab\xc8\xa1\x8c\xc2\x22\x7e\xdc\x13\x3e\x04\x18\xa6\xbd\xf0\x0c\xdc\x85\x19\x43\xde\x9a\x3e\xa2\x5d\x5c\xf7\xd1\x1d\xdb\x11\x73\x2e\x34\xd6\x24\xdc\xb2\x8b\xb4\xe0\xf2\xdc\x60\xf3\x34\x8a\xde\x9b\x49\x07\x63\x4f\xde\x5d\x68\x62\xb1\xe3\xcf\x12\x52\x47\xac\x40\x89\x4a\x85\xf4\x47\x83\x3c\x23\x2a\x45\x4f\x35\xb1\xd2\x22\xa1\x5c\x47\x71\x28\x89\x12\xac\x32\x31\x69\x6e\x7e\xec\x9c\x1a\xc1\x24\x20\x4c\xcc\x6c\x74\xe6\x28\xaa\x0b\x9d\x6f\x37\xf4\x0d\x9c\xa8\x40\x52\xf8\x92\xa3\x40\x32\x19\x07\xa2\xc3\x50\xe4\x1d\xcd\x0c\x0a\x24\x93\x83\x50\x94\x05\x92\x65\x20\x59\xf7\xbd\x7c\xeb\x06\x39\xe5\x16\xaa\xa1\x53\x81\x3e\x8d\xcf\xc6\xc1\xf0\x25\xcd\x74\x0e\x9a\x43\x37\x0f\x08\x70\x66\x7c\x25\xab\x92\x40\x26\x95\xd1\x1f\x39\xba\x29\x96\xf1\x38\xd6\x38\xbd\x8b\x2a\xca\xf5\x10\x9f\xdd\x0b\x51\xc0\x01\xef\xa2\x0b\x8a\x86\xb3\x23\x2a\x46\xe6\x84\x67\xd1\xcd\x72\xb1\x4c\xda\x30\x09\xb4\x16\x3d\x38\xd5\x74\x41\x86\x97\x4d\x2c\x39\x46\xf7\x35\x1c\x79\x6f\xd8\x08\x90\x39\x35\x29\x07\xb2\x95\xeb\x38\x22\x21\x79\x6a\x3c\x8f\x46\x30\xba\x27\x52\x34\xb7\x14\xac\x9d\x01\x07\x2d\x70\x73\xb4\x48\x76\x16\x82\x69\x5a\x86\x84\x51\x53\x72\x9b\x19\xd2\xaa\xa8\x58\xbd\x4a\x8c\x3b\x12\xac\x92\xb6\x2c\x30\x27\x18\x8c\xde\x2e\x73\x36\x95\xcc\xc8\x26\x04\xd5\xcb\xf1\xbb\xf1\x9c\x46\xa4\x1c\xc8\x3d\x5f\x9d\xed\xe0\xce\x0a\xf5\xdc\x4c\xd7\x11\x5f\x63\xc0\x39\xd1\x50\x98\xa9\xfd\xe6\xf3\xda\x67\x43\x44\x56\x29\xab\x2c\xdd\xdf\xb8\x01\x57\x50\xa5\x60\xaf\xe8\x2e\x42\xe7\xb6\x60\x09\x71\x58\xf3\xf1\xc4\x0d\x46\x21\x33\x3b\x3c\x96\x7f\x95\x5e\xb3\x48\x3e\x98\x09\xae\xf7\x9a\xd5\x80\x98\x4a\x8d\x06\xef\x2c\x13\xbc\x8a\xd3\xad\x86\xc2\x2a\xaa\x60\x78\x5a\x57\x28\x81\x01\x58\x4a\xcc\xe7\xa4\x20\x96\xc1\x51\x2e\x24\x05\xe4\x6b\xec\x26\x42\x94\xe6\xd8\xa6\x10\x43\x0b\x9e\xc6\x98\x48\x64\x52\x0a\x5b\x83\xe2\xa9\xf0\x30\xb9\x8d\x18\x5e\x3f\x23\xeb\x76\xe5\x08\xda\x34\x70\x9b\x35\x52\x8b\xc0\x01\x36\xfc\x3a\xb0\xa1\xed\x41\x64\x50\xa7\xc2\x3a\xb9\xc3\x1b\x94\x3a\x1a\x7e\x28\xb4\x57\xd5\x2f\x8e\xc3\x9a\xf6\x77\x89\xcb\x3c\x56\xd1\x1e\x7c\xbb\x8a\xf6\xd1\xe8\x3f\x7c\x72\xf4\xcf\xa5\xa8\xca\x30\xcb\x67\x64\x86\x2b\xa6\x87\xf3\xe3\x16\xd6\x78\x7d\x54\x0d\xb1\x90\x65\xd3\x3f\x70\xf1\x92\x1c\x14\xf1\x79\x4d\x1b\xef\x7e\x14\x0e\xaa\xb1\xf4\x72\x2c\xd4\x85\xda\xdf\xbc\x52\x15\x66\xa3\xd3\xbf\x2e\x47\x67\x00\x12\x9f\x1f\x5e\x86\x3b\xfe\x7b\x6e\x7d\x8b\xae\xff\x2d\xba\x86\x33\xfc\x0f\x93\xe2\x4b\xba\x3d\xb8\xc2\xdf\xe9\x4f\x0d\xa0\x6d\x31\xf8\xbd\xc4\xd6\x37\xae\x5b\x2d\xa6\xaf\x44\x05\xbf\x2f\x9e\x8d\xea\xf0\xa7\xd5\x23\xa0\x3e\x7a\xab\x5a\xdf\xaa\xd6\xfa\x0d\x70\x54\x63\xee\xc5\xaa\x56\xe7\x65\xdc\x2b\x5c\x8f\xbf\xe3\xc2\x75\x72\xf0\x7a\xb9\x35\x2c\x63\x06\x92\x4d\x89\xe7\x24\x7c\xa1\x11\xb3\x99\x22\x3a\x78\x09\x8a\x3e\xf6\x0d\x3c\xf4\x46\xde\xfa\x6e\x9f\x1d\xf7\x27\xdb\xc2\x1e\xe2\x4a\xce\x98\x58\x9a\x81\x05\xe5\x7b\x0d\x0a\x42\x2e\x54\xcf\x38\xb3\xc7\x99\x57\xf6\x89\x75\xfb\x98\x21\x5e\x1d\x3d\xb1\xaa\x18\x3d\xf9\x85\x61\x70\xe4\x19\x38\x8d\xac\xb4\x3d\x2e\x55\x10\x4a\x6a\xff\x3d\x56\xc4\xbc\xdc\xed\x9f\x82\x9b\xcc\x6b\xd2\xd7\x2e\xd4\x68\x86\xd6\x39\x5d\xaa\x4f\x64\x6e\x9e\x61\x3d\xc5\x27\x92\x52\xc8\xf7\x5c\x9f\xa6\x96\x12\x2f\xb3\x61\x2c\xe4\xb0\x03\x6b\x62\xd8\xcb\x41\x06\x73\x43\x61\x92\x13\x9c\xc5\xb3\x10\xc0\x7a\x20\x71\x7d\x57\xe4\x6b\xe1\x99\x34\x2d\xaa\x08\x71\xc0\x2d\xd8\xf7\x53\x74\x15\x89\x68\x2d\x69\xf1\x01\xa7\x75\x19\x70\xe8\xc5\x35\x17\xb2\x30\xf8\x56\x5b\x7f\x95\x78\x4f\x73\x5e\xef\x8f\x25\x5d\xf8\x44\x32\xa8\x48\x31\xb3\x11\x34\xf0\xca\xf9\x21\x6c\x04\x3d\x33\x01\xec\x34\x03\x62\x54\x89\x28\xcf\xc8\x6a\xd3\x0e\xed\xbf\xf8\xf7\xaa\x48\x00\x82\x06\x85\xa1\x5f\x74\x93\x9a\xee\xaa\x36\xdd\xd5\x84\xd6\x86\xef\xdd\xfe\x03\xff\xf6\x3f\x7e\xdc\x3f\x3f\x6f\x67\xb4\x24\x6b\xaa\x95\x60\x42\x3b\x68\x89\x65\xb1\xf1\x4e\xf3\x0e\x5a\xf7\x11\x5b\x5e\x43\x19\x96\x77\x75\xf7\x76\x06\x2b\x18\xb6\xea\x3b\xb2\xce\x27\x49\x4e\xdd\x87\x62\x5b\xdf\xf5\x3a\x98\x48\xe1\x05\x49\xe6\x01\x42\x6a\x39\x61\xd8\x06\x29\xc1\x32\xcd\x9d\x8b\xa8\xf5\xf6\x92\x22\xf2\x8d\x8d\xbe\x46\x93\xa2\x1c\x10\x27\x5a\xb3\x24\xd2\xc6\x75\x47\x18\xcb\x0f\xc7\x6d\x87\xc2\x9a\xd3\x1e\xbf\x37\xc5\x8a\xb7\x9c\x3e\xd4\x27\x5e\x7f\xaa\x1e\x12\x31\x30\xa7\x59\x1f\x47\xf5\xa5\x94\x15\x63\x99\x58\xf2\x58\x87\xb7\xc9\x99\xf5\x93\xff\xe3\x25\x82\x8b\xd4\x4e\x1c\x69\xac\x99\x3e\x39\x27\x7e\x93\xa1\x6e\xbd\xda\xb1\x1b\xda\xd8\xf4\xbe\x37\x21\x21\xa1\x08\x2d\xa6\x16\x0b\x9b\x47\x60\xb7\x8f\x1e\x2f\x94\x1a\x83\xea\x5e\xb8\xa1\xc1\x98\x51\xce\x91\x06\x6c\x8a\xec\xd5\xc5\x24\xc7\x8b\x61\x5f\x9a\xf8\x2f\x69\x7a\xd7\x6b\x6e\x3c\xdb\xa1\x83\xe4\x57\xf7\x99\x37\xbd\x9d\x9e\x17\x9d\x86\x1a\x3a\x76\xbe\xdc\x46\x1a\x3a\xf8\xc5\xfd\xec\xf7\xd4\xd0\xaf\xce\xd7\x06\xce\xf6\xf3\x64\x9c\xc5\x2e\x6b\x26\x89\xca\x93\x78\x77\x11\x1d\x29\xe7\x28\x63\xe5\xae\xef\x6a\xfb\x7d\x42\xb7\xb7\x88\x26\xee\xa7\xdb\x53\xf4\xcc\x77\xed\x9d\x44\x0e\x6d\x1c\xb9\xa5\x5f\x1f\xff\x3f\x1b\x70\x5f\x4b\x5f\x54\x43\x2d\xb1\x2d\xf5\xb1\x07\x94\xc6\x33\x5d\x48\x3e\xec\x7c\x01\x3b\xa3\x6d\x47\xf4\x22\x00\x00")
+
+func kibana_app_dashboards_insights_json_bytes() ([]byte, error) {
+	return bindata_read(
+		_kibana_app_dashboards_insights_json,
+		"kibana/app/dashboards/insights.json",
+	)
+}
+
+func kibana_app_dashboards_insights_json() (*asset, error) {
+	bytes, err := kibana_app_dashboards_insights_json_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "kibana/app/dashboards/insights.json", size: 8948, mode: os.FileMode(436), modTime: time.Unix(1792365328, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
 var _kibana_app_dashboards_logstash_js = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\x57\xdf\x6f\xdb\x36\x10\x7e\xf7\x5f\x71\xcd\x8b\xec\x4c\x55\x92\x6e\x7b\x51\x10\x74\x59\x9d\x0e\xc1\xd2\x16\x68\x53\x0c\x43\x11\x18\xb4\x44\x59\x9c\x25\x52\x15\x29\xbb\x5a\xe2\xff\x7d\x77\x24\xf5\xc3\xae\x33\x6c\x45\x51\x98\xe2\xf1\xf8\xdd\x77\x77\xdf\xb1\x67\xa7\xb0\x2a\xd4\x92\x15\xb0\x80\xd3\xb3\xc9\xe4\xec\x74\x02\xa7\xf0\x46\x95\x55\xc1\xbf\x81\x4e\x6a\x51\x19\x9e\xc2\x9d\x5a\x69\xc3\x74\x0e\x29\xfe\xb3\x54\xac\x4e\xc9\xec\x3e\x17\xda\xdb\xc0\x8a\x4b\x5e\x33\xc3\x35\xb0\xc1\x08\xd4\xf2\x2f\x9e\x18\x30\x39\x33\xf0\xbb\x58\x32\xc9\x20\x61\x12\x0a\xc5\xd2\x08\x6e\x0d\xb0\x42\x2b\x30\x6c\x6d\x8f\xc9\xa6\x5c\xf2\x1a\x54\x06\x8d\xe6\x35\x5d\xa0\x9b\xaa\x2a\x04\xde\xff\xf9\xe3\x1d\x54\xac\x66\x25\x37\xbc\xd6\x21\x48\x25\x39\xb0\x9a\x43\xcd\xbf\x36\xa2\xe6\x69\x8c\xe6\x74\x42\xc8\x14\x71\xc7\x31\xfc\x91\x8b\x24\xf7\x4b\xa3\x40\x73\x56\x27\xf9\x6b\xb8\xcd\x10\x0c\xa2\x26\xe0\x15\x4f\x44\x86\xde\x43\x34\x43\xb7\x1b\x24\x81\x3e\x73\x43\x07\x02\xba\x22\x20\x97\x15\x33\xb8\x2b\xc9\xe9\x5c\x21\x50\xa9\xd0\x83\x5c\x81\xc8\xbc\xfb\xb1\xaf\x08\x3e\xe1\x79\x06\x46\x94\x1c\x19\x2b\x2b\x04\xef\xac\xbc\x9b\x08\xe6\x3c\x63\x4d\x61\x62\xf8\x52\x78\x56\x5f\x3e\xfc\x89\x7f\xa2\x77\xef\xa2\xf9\xdc\x05\xe1\xe1\xe0\x95\xe8\x4e\x23\x64\xde\xdd\xd5\x6d\x4d\xf9\x2a\x46\xa2\xdb\x70\xcb\xf9\x3a\x2c\x95\x34\x79\xd8\x62\x90\xb3\x70\xb8\x00\xb7\x3d\x2d\x1a\x69\x34\xe4\xee\x1e\x3d\x25\x39\x32\x99\xa0\x1f\x4b\x8c\xdd\xa1\x0b\xbe\x36\xbc\x16\x18\x9f\x92\x83\x87\x20\xb4\x14\xd0\x56\x4b\xc7\x7f\x6d\x21\x75\x7b\x21\x06\x99\xa8\xb2\x64\x48\x18\x65\x86\xaa\xa4\x10\xda\x50\xfa\x3a\x4f\xe8\xbe\x6e\xc6\x11\x9f\x7a\x38\x59\xad\x4a\x17\x1c\x65\xc5\x65\x84\x95\xaa\x91\xf6\x38\x71\x07\x4b\x96\xac\x43\xe0\x2b\xb8\xf8\xb9\x0c\xe1\x22\x0f\xe1\x55\x3a\xf2\x84\x5f\xc9\x11\x99\x22\xed\x45\xda\xc5\xe6\x16\x09\xd2\xc1\x84\xa4\x2c\x51\x64\xd6\x21\x82\xc9\x44\x41\x51\x2b\x39\xe2\xe8\x97\x3e\x53\x1d\x36\xf2\xa0\xc9\xdf\x73\xe1\x79\x0b\x22\x2f\x57\x5b\x4c\x89\xbb\x84\x2d\x0b\x6e\xb9\x56\xb5\x39\x76\xdc\x41\xa3\x53\x64\x40\x20\x98\x4c\x21\xc5\xea\x4d\x8c\xa0\x25\x06\x4b\x5b\x57\x03\xa4\x30\xe5\x3a\x71\xb8\xb0\x37\xb1\x3b\xcf\xa8\x1e\x9a\x0a\xed\x30\xa2\x0d\xab\x05\x5d\xaa\x27\xf8\x6b\x68\xba\xb0\xe3\x3f\x84\x45\xba\xb0\xbe\x2a\x26\x2f\xed\xe9\xeb\xa2\x80\xa6\x2e\x46\xbd\x64\xbb\x88\x6d\x98\x28\xc8\x15\x6c\x04\xb3\xd1\x5c\x7f\xfc\xed\x93\xef\x5e\xeb\x9d\xd6\x97\x1d\x00\x6a\x71\xc7\x1f\x74\xee\xa9\x1b\xa8\x27\x85\x96\x81\x19\xda\x61\x32\x42\x00\x57\x10\x5c\xa4\x81\xf3\x72\x2b\x8d\x60\x85\xf8\x1b\xef\x06\xbd\xe6\x05\x37\x58\x76\x5b\x61\xf2\xbe\xbd\x96\x0d\xdd\x53\xab\x2d\x41\xac\x59\x6b\xd9\x42\x5d\xd8\x88\x84\x77\xc8\x06\xa1\xb9\x82\xc7\x09\x38\x6b\x6c\xac\x87\x10\x17\xde\x96\x3e\x3c\xee\x26\xbb\x31\x7a\x23\x0c\xe6\xaa\x3f\x1d\xd9\x35\xe1\xeb\x75\xce\x55\x66\xd0\xb3\x86\x79\x26\x5a\x48\x98\x9c\x9c\x98\xa1\x29\x43\x0a\x1e\x57\xd8\x1b\x58\x77\x21\x64\x0c\x59\xa6\xfa\x25\xcb\xae\xc7\xa3\x89\xc8\xa6\x2f\x16\x91\xd0\x9f\xf1\x4c\x26\x24\x4f\xa7\x44\x6a\x64\x5d\xcc\x66\x16\xff\x80\xc8\x75\xbb\x8b\x0a\x3a\xb6\x63\x18\x4e\x84\x76\xa3\x93\x83\xb8\xd7\x2b\xc0\x40\x77\xc0\x0b\xcd\xed\x59\x44\x3f\x57\x94\x92\x0c\x33\x4c\x78\xbc\xab\xbd\xcb\x68\x4f\x6d\x30\xb2\x2b\xc2\xae\xf9\xe5\xff\x83\xf2\xf4\x14\x5c\xcf\xe7\x8b\xeb\xc5\xfd\xed\xbb\x9b\xc5\xdb\xdb\xbb\xfb\x9b\x8f\x81\xc3\xe7\x45\xcf\x5b\xfb\x15\xda\x1f\x95\xbe\xe0\x30\x26\x7f\x85\x5b\xe2\x29\xd4\xb3\x2e\x42\x5f\x44\x4e\x3a\x86\x32\xd8\x72\xc0\x52\xb2\x69\xd2\x78\xb9\xd6\xbd\x16\x31\x7d\xbc\x9f\x91\x92\x4e\xfc\xda\xa1\x2d\x22\x72\xff\xa1\x76\x59\xa5\x89\xe5\x2a\xba\xa5\x6a\xb5\x82\x39\x68\x68\xa3\x3b\x99\x71\x3b\xac\x77\x62\x21\x66\xde\x35\xc1\x74\x69\x0f\xbd\x21\xfd\x95\x78\x3d\xdb\xd3\xcd\xd6\xd7\xb6\xa6\xd3\xef\x3f\xdc\xdf\xc4\x20\x50\x6c\xca\x06\x4d\x96\xdc\x92\xb3\xb2\x23\x30\xe7\x12\x1b\x81\xee\xc5\x0b\x35\xc7\x86\x9a\xce\xf4\x73\x45\x66\x3d\xfb\x22\xeb\x18\xb9\x82\x45\xe4\xee\x9a\x2e\xa2\x92\x55\x23\xcb\xc8\x22\x74\x1f\xec\x4f\x24\x3f\x0c\x70\xb4\x64\x8d\xb4\x62\x35\xdd\x84\xeb\x99\xaf\x88\x1a\x15\x09\xc7\xe3\x97\x75\xe8\xd6\xee\x86\x36\x86\x4d\xe8\xd7\x22\x8d\x07\x90\xeb\xf0\xe2\x7c\xd6\xed\xa0\x04\x30\x8d\x96\x76\xb9\x7b\xa0\xc2\xdb\xcd\x66\x87\x15\xfc\x5e\xf5\xa0\x29\xa7\x3c\xc5\x39\x2e\xc5\x48\x40\x30\x03\x45\x97\x43\x64\xb4\x64\x06\xa7\x0a\xc7\x8a\x6e\xad\x96\xec\x05\xed\x40\x9e\xc7\x70\x80\x36\x38\x0d\xc6\x78\xcf\xdd\x62\x37\xae\xb7\xf7\xa8\x02\x95\xaa\x9a\x02\xcb\x67\x54\x35\x9d\x28\x59\xf9\x52\x4d\xdd\x67\x70\xe8\xa1\x4e\x8b\x1c\xbd\x1e\x85\x4d\x7b\xdc\xab\xf5\x04\x6c\xa6\x63\x70\xd9\x58\x44\x6b\xde\xea\xa9\xdf\x9d\x85\x03\xf5\xb3\x47\x4f\x79\xcf\xe9\x86\x38\xbd\xdc\xcd\x3a\x95\xbb\xa3\x47\x83\x7d\x5b\xb1\x34\x3d\x50\x6b\x3f\x05\x43\x1b\x00\xf6\x55\xc3\xa9\xf4\xb6\xf6\xb9\x44\x95\xbe\xe4\x83\x7c\xc3\xb2\xed\x65\xef\x58\x34\x7e\xa0\x0e\xe1\xc4\xdf\xd3\x4b\xb3\x3e\x86\x13\xa9\xb6\x2f\x4f\x7e\x70\x25\x45\x9f\x9e\x9e\x46\xa3\xa1\xaf\x07\xa3\x9c\xe9\x49\xf7\xc1\x0e\x4d\xaf\x04\xfd\xb0\x7f\x7a\x3a\x19\x66\x64\x6f\x6a\xda\x8a\xe3\x69\xda\xe8\xbf\x61\x8b\x8a\x0d\x7e\x35\x75\xc3\x9f\xcb\xae\x27\x1e\xc7\xc6\xf9\x43\x47\xe0\x07\x7c\x7b\x14\x44\x62\x89\x6f\x53\x37\x6d\x69\xb6\x44\xf6\x8d\xf1\xd6\x46\xad\xe9\x0b\xb5\x75\xa2\x8a\x82\x55\xda\xb1\xd5\xc9\xeb\x40\x96\x9d\x49\x57\xf0\x05\x6f\x71\x94\xd8\x61\x83\x40\xdf\xa0\x84\x18\x8f\x34\xe7\x62\x95\x23\x7b\x27\x3f\x9e\x9f\x57\xdf\x4e\x3a\x58\xfb\x07\x6e\x36\x5c\x1a\x7d\x78\xe2\xa7\xfe\xc4\xe4\xc1\x8f\x2b\x49\x39\x47\x61\x34\x6a\x85\x52\xe4\x5e\xdf\x8c\x66\x98\xde\x1f\x62\x5e\xd3\xdc\x20\xf3\xef\x4a\x9a\xb2\xbe\x4c\x90\xe9\x83\x38\x90\x21\x14\x71\x89\xbd\x79\x2c\xa2\x80\x5b\x80\x60\x47\x09\xf9\xf0\x0d\xe5\x12\x13\xf4\x80\xba\xcf\x68\xb1\xf8\xcf\xf9\x65\x8d\x51\x0b\x44\x39\xce\x25\x95\x0e\x3e\x05\x5f\x7d\x1f\xbc\x7d\x8c\xd9\x04\x6d\x73\x8e\x0f\x9c\x56\x35\x7b\x32\xee\xde\x62\xf6\x45\x61\x1f\x63\x75\xba\x57\xe0\x36\xd6\x8b\x7f\x8d\x95\x66\xbc\x8b\x77\x3f\x4a\x7b\xb3\xff\xe4\x1e\x8a\x31\x1c\x53\x64\xb7\x37\x83\xd7\x30\x5a\x7a\xdd\x25\xad\xed\x9f\x31\x60\x21\x1e\x77\x42\x3b\xbd\x0b\x5a\x1c\x38\x38\xa4\x35\x18\x68\x45\x13\x7a\x5c\x06\xfe\x0e\xca\x59\x86\x15\x42\x59\xfc\x86\x61\xa7\xc1\xf3\x14\x93\x0c\x7a\x05\xa2\xd2\xf1\xff\xc7\x23\x32\xb7\x3c\x40\xb2\x57\x4a\xa5\x2f\x26\xde\xa2\x27\xf5\x72\xf2\x4f\x00\x00\x00\xff\xff\x59\x46\x1b\x2c\x6c\x0e\x00\x00")
 
 func kibana_app_dashboards_logstash_js_bytes() ([]byte, error) {
@@ -3129,6 +3149,7 @@ var _bindata = map[string]func() (*asset, error){
 	"kibana/app/dashboards/blank.json": kibana_app_dashboards_blank_json,
 	"kibana/app/dashboards/default.json": kibana_app_dashboards_default_json,
 	"kibana/app/dashboards/guided.json": kibana_app_dashboards_guided_json,
+	"kibana/app/dashboards/insights.json": kibana_app_dashboards_insights_json,
 	"kibana/app/dashboards/logstash.js": kibana_app_dashboards_logstash_js,
 	"kibana/app/dashboards/logstash.json": kibana_app_dashboards_logstash_json,
 	"kibana/app/dashboards/noted.json": kibana_app_dashboards_noted_json,
@@ -3334,6 +3355,8 @@ var _bintree = &_bintree_t{nil, map[string]*_bintree_t{
 				}},
 				"guided.json": &_bintree_t{kibana_app_dashboards_guided_json, map[string]*_bintree_t{
 				}},
+				"insights.json": &_bintree_t{kibana_app_dashboards_insights_json, map[string]*_bintree_t{
+				}},
 				"logstash.js": &_bintree_t{kibana_app_dashboards_logstash_js, map[string]*_bintree_t{
 				}},
 				"logstash.json": &_bintree_t{kibana_app_dashboards_logstash_json, map[string]*_bintree_t{
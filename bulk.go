@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bulkAttempts   = 5
+	bulkRetryDelay = 1 * time.Second
+)
+
+var deadLetterLock sync.Mutex
+
+// bulkItem is one document in an ElasticSearch bulk request
+type bulkItem struct {
+	Index string
+	ID    string
+	Doc   json.RawMessage
+	job   *indexJob
+}
+
+// bulkAction is the action line before each document of a bulk request
+type bulkAction struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type,omitempty"`
+	ID    string `json:"_id"`
+}
+
+// bulkResponse is the body ElasticSearch returns for a _bulk request, which is "200 OK"
+// even when some of the documents failed
+type bulkResponse struct {
+	Errors bool                    `json:"errors"`
+	Items  []map[string]bulkResult `json:"items"`
+}
+
+type bulkResult struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// deadLetter is a line in the dead-letter file
+type deadLetter struct {
+	Time     string
+	Index    string
+	ID       string
+	Status   int
+	Reason   string
+	Document json.RawMessage
+}
+
+// bulk indexes documents, re-submitting only the items ElasticSearch rejected because it was
+// overloaded.  Documents rejected for good are written to the dead-letter file.  On error, the
+// documents which were not indexed are returned.
+func (c *config) bulk(items []*bulkItem) ([]*bulkItem, error) {
+	delay := bulkRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := c.bulkRequest(items)
+		if err != nil {
+			return items, err
+		} else if len(retry) < 1 {
+			return nil, nil
+		} else if attempt >= bulkAttempts {
+			return retry, fmt.Errorf("ElasticSearch still rejecting %d of %d documents after %d attempts", len(retry), len(items), attempt)
+		}
+		log.Printf("ElasticSearch rejected %d documents, retrying in %s", len(retry), delay)
+		time.Sleep(delay)
+		delay *= 2
+		items = retry
+	}
+}
+
+// bulkRequest sends one bulk request and returns the items which should be retried
+func (c *config) bulkRequest(items []*bulkItem) ([]*bulkItem, error) {
+	var bulk bytes.Buffer
+	for _, i := range items {
+		action := bulkAction{Index: i.Index, ID: i.ID}
+		if !c.esVersion.typeless() {
+			action.Type = esType
+		}
+		line, err := json.Marshal(map[string]bulkAction{"index": action})
+		if err != nil {
+			return nil, err
+		}
+		bulk.Write(line)
+		bulk.WriteString("\n")
+		bulk.Write(i.Doc)
+		bulk.WriteString("\n")
+	}
+	resp, err := c.es.request("POST", "/_bulk", &bulk)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != "200 OK" {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Error response from Elasticsearch: %s %s", resp.Status, string(body))
+	}
+	br := bulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, fmt.Errorf("Error decoding Elasticsearch bulk response: %s", err.Error())
+	}
+	if !br.Errors {
+		return nil, nil
+	} else if len(br.Items) != len(items) {
+		return nil, fmt.Errorf("Elasticsearch bulk response has %d items but %d were sent", len(br.Items), len(items))
+	}
+
+	retry := []*bulkItem{}
+	for n, result := range br.Items {
+		for _, r := range result { // keyed by the action, "index"
+			if r.Status < 300 {
+				continue
+			}
+			reason := r.reason()
+			if r.retryable(reason) {
+				c.debug("ElasticSearch rejected %s/%s for now: %d %s", items[n].Index, items[n].ID, r.Status, reason)
+				retry = append(retry, items[n])
+				continue
+			}
+			log.Printf("ElasticSearch failed to index %s/%s: %d %s", items[n].Index, items[n].ID, r.Status, reason)
+			if err := c.writeDeadLetter(items[n], r.Status, reason); err != nil {
+				return nil, fmt.Errorf("Error writing to dead-letter file %s: %s", c.deadLetterFile, err.Error())
+			}
+		}
+	}
+	return retry, nil
+}
+
+// reason extracts the error message - a string in ElasticSearch 1.x, an object in later versions
+func (r *bulkResult) reason() string {
+	var s string
+	if err := json.Unmarshal(r.Error, &s); err == nil {
+		return s
+	}
+	var e struct {
+		Type     string `json:"type"`
+		Reason   string `json:"reason"`
+		CausedBy *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"caused_by"`
+	}
+	if err := json.Unmarshal(r.Error, &e); err != nil {
+		return string(r.Error)
+	}
+	reason := e.Type + ": " + e.Reason
+	if e.CausedBy != nil {
+		reason += " (" + e.CausedBy.Type + ": " + e.CausedBy.Reason + ")"
+	}
+	return reason
+}
+
+// retryable reports whether a document was refused only because ElasticSearch was busy
+func (r *bulkResult) retryable(reason string) bool {
+	return r.Status == 429 ||
+		strings.Contains(reason, "es_rejected_execution") ||
+		strings.Contains(reason, "EsRejectedExecution")
+}
+
+// writeDeadLetter appends a permanently rejected document and the reason to the dead-letter file
+func (c *config) writeDeadLetter(item *bulkItem, status int, reason string) error {
+	line, err := json.Marshal(deadLetter{
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Index:    item.Index,
+		ID:       item.ID,
+		Status:   status,
+		Reason:   reason,
+		Document: item.Doc,
+	})
+	if err != nil {
+		return err
+	}
+
+	deadLetterLock.Lock()
+	defer deadLetterLock.Unlock()
+	f, err := os.OpenFile(c.deadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
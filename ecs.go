@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+const ecsVersion = "8.11.0"
+
+// ecsDocument maps a CloudTrail record into Elastic Common Schema fields, keeping the
+// original record under "aws.cloudtrail" so nothing is lost
+func ecsDocument(r *cloudtrailRecord) interface{} {
+	event := map[string]interface{}{
+		"id":       r.EventID,
+		"kind":     "event",
+		"module":   "aws",
+		"dataset":  "aws.cloudtrail",
+		"provider": r.EventSource,
+		"action":   r.EventName,
+		"outcome":  ecsOutcome(r),
+	}
+	if r.Provenance != nil {
+		event["ingested"] = r.Provenance.IngestTime
+	}
+	doc := map[string]interface{}{
+		"@timestamp": r.EventTime,
+		"ecs":        map[string]interface{}{"version": ecsVersion},
+		"event":      event,
+		"cloud": map[string]interface{}{
+			"provider": "aws",
+			"region":   r.AwsRegion,
+			"account":  map[string]interface{}{"id": r.RecipientAccountId},
+		},
+		"aws": map[string]interface{}{"cloudtrail": rawDocument(r)},
+	}
+
+	if r.isInsight() && r.InsightDetails != nil {
+		event["kind"] = "alert"
+		event["provider"] = r.InsightDetails.EventSource
+		event["action"] = r.InsightDetails.EventName
+		doc["rule"] = map[string]interface{}{"name": r.InsightDetails.InsightType}
+		return doc
+	}
+
+	related := map[string]interface{}{}
+	if ip := net.ParseIP(r.SourceIPAddress); ip != nil {
+		doc["source"] = map[string]interface{}{"ip": r.SourceIPAddress}
+		related["ip"] = []string{r.SourceIPAddress}
+	} else if len(r.SourceIPAddress) > 0 {
+		doc["source"] = map[string]interface{}{"domain": r.SourceIPAddress} // AWS service principals
+	}
+	if len(r.UserAgent) > 0 {
+		doc["user_agent"] = map[string]interface{}{"original": r.UserAgent}
+	}
+	if user := ecsUser(r.UserIdentity); len(user) > 0 {
+		doc["user"] = user
+		if name, ok := user["name"]; ok {
+			related["user"] = []interface{}{name}
+		}
+	}
+	if len(related) > 0 {
+		doc["related"] = related
+	}
+	if r.failed() {
+		doc["error"] = map[string]interface{}{"code": r.ErrorCode, "message": r.ErrorMessage}
+	}
+	return doc
+}
+
+// ecsUser maps a CloudTrail userIdentity into the ECS user fields
+func ecsUser(identity map[string]interface{}) map[string]interface{} {
+	user := map[string]interface{}{}
+	if id, ok := identity["principalId"].(string); ok {
+		user["id"] = id
+	}
+	if name := principalName(identity); len(name) > 0 {
+		user["name"] = name
+	}
+	if account, ok := identity["accountId"].(string); ok {
+		user["domain"] = account
+	}
+	return user
+}
+
+// ecsOutcome reports "failure" for calls CloudTrail recorded an error for
+func ecsOutcome(r *cloudtrailRecord) string {
+	if r.isInsight() {
+		return "unknown"
+	} else if r.failed() {
+		return "failure"
+	}
+	return "success"
+}
+
+// principalName finds the most readable name for a CloudTrail userIdentity
+func principalName(identity map[string]interface{}) string {
+	if name, ok := identity["userName"].(string); ok && len(name) > 0 {
+		return name
+	}
+	if session, ok := identity["sessionContext"].(map[string]interface{}); ok {
+		if issuer, ok := session["sessionIssuer"].(map[string]interface{}); ok {
+			if name, ok := issuer["userName"].(string); ok && len(name) > 0 {
+				return name
+			}
+		}
+	}
+	if arn, ok := identity["arn"].(string); ok && len(arn) > 0 {
+		return arn[strings.LastIndex(arn, "/")+1:]
+	}
+	if service, ok := identity["invokedBy"].(string); ok {
+		return service
+	}
+	return ""
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	esHealthInterval = 10 * time.Second
+	esHealthTimeout  = 5 * time.Second
+	esRequestTimeout = 2 * time.Minute // long enough for a big bulk request
+)
+
+// esClient sends authenticated requests to ElasticSearch, shared by the indexer and the proxy.
+// Requests are spread across the healthy nodes and fail over to the next node.
+type esClient struct {
+	nodes    []*esNode
+	next     uint32
+	client   *http.Client
+	username string
+	password string
+	apiKey   string
+	signer   *sigv4Signer
+}
+
+// esNode is one ElasticSearch URL and what the health checks last found
+type esNode struct {
+	url       *url.URL // without credentials
+	lock      sync.Mutex
+	healthy   bool
+	lastError string
+	lastCheck time.Time
+}
+
+// esClientOptions are the connection settings for ElasticSearch
+type esClientOptions struct {
+	URL        string // comma separated
+	Username   string
+	Password   string
+	APIKey     string
+	CAFile     string
+	ClientCert string
+	ClientKey  string
+	Signer     *sigv4Signer
+}
+
+func newESClient(o esClientOptions) (*esClient, error) {
+	e := esClient{username: o.Username, password: o.Password, apiKey: o.APIKey, signer: o.Signer}
+	for _, s := range strings.Split(o.URL, ",") {
+		s = strings.TrimSpace(s)
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ElasticSearch URL: %s", err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("Invalid ElasticSearch URL %q: must start with http:// or https://", s)
+		}
+		if u.User != nil { // credentials in the URL are used unless given separately
+			if len(e.username) < 1 {
+				e.username = u.User.Username()
+				e.password, _ = u.User.Password()
+			}
+			u.User = nil
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		e.nodes = append(e.nodes, &esNode{url: u, healthy: true})
+	}
+	if len(e.apiKey) > 0 && len(e.username) > 0 {
+		return nil, fmt.Errorf("Use either an ElasticSearch API key or a username and password, not both.")
+	} else if e.signer != nil && (len(e.apiKey) > 0 || len(e.username) > 0) {
+		return nil, fmt.Errorf("ElasticSearch requests signed with AWS credentials can't also use a username, password or API key.")
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(o.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ElasticSearch CA file: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in ElasticSearch CA file %s", o.CAFile)
+		}
+	}
+	if len(o.ClientCert) > 0 || len(o.ClientKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading ElasticSearch client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	e.client = &http.Client{Timeout: esRequestTimeout, Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	return &e, nil
+}
+
+// newRequest builds a request for an ElasticSearch API path such as "/_bulk", which do sends
+// to one of the nodes
+func (e *esClient) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request to a healthy ElasticSearch node with its credentials, trying the next
+// node if one can't be reached or answers with a server error, such as 503 with no master
+func (e *esClient) do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	var lastErr error
+	nodes := e.pick()
+	for n, node := range nodes {
+		attempt, err := http.NewRequest(req.Method, node.url.String()+req.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range req.Header {
+			attempt.Header[k] = v
+		}
+		if err := e.authorize(attempt); err != nil {
+			return nil, err
+		}
+		resp, err := e.client.Do(attempt)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		} else if err == nil {
+			node.setHealth(fmt.Errorf("%s", resp.Status))
+			if n == len(nodes)-1 { // no node left to try, so the caller gets the error response
+				return resp, nil
+			}
+			resp.Body.Close()
+			continue
+		}
+		node.setHealth(err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// authorize adds the ElasticSearch credentials to a request for a node
+func (e *esClient) authorize(req *http.Request) error {
+	if e.signer != nil {
+		return e.signer.sign(req)
+	} else if len(e.apiKey) > 0 {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if len(e.username) > 0 {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	return nil
+}
+
+// request sends a request for an ElasticSearch API path
+func (e *esClient) request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := e.newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return e.do(req)
+}
+
+// pick orders the nodes to try, starting from the next healthy node in turn.  If none are
+// healthy, every node is tried anyway.
+func (e *esClient) pick() []*esNode {
+	healthy, down := []*esNode{}, []*esNode{}
+	for _, node := range e.nodes {
+		if node.isHealthy() {
+			healthy = append(healthy, node)
+		} else {
+			down = append(down, node)
+		}
+	}
+	if len(healthy) > 1 {
+		start := int(atomic.AddUint32(&e.next, 1) % uint32(len(healthy)))
+		healthy = append(healthy[start:], healthy[:start]...)
+	}
+	return append(healthy, down...)
+}
+
+// healthLoop checks every node in the background so requests avoid the ones which are down
+func (e *esClient) healthLoop() {
+	for range time.Tick(esHealthInterval) {
+		for _, node := range e.nodes {
+			go e.checkNode(node)
+		}
+	}
+}
+
+// checkNode asks a node for its root URL.  Any response other than a server error counts as up.
+func (e *esClient) checkNode(node *esNode) {
+	req, err := http.NewRequest("GET", node.url.String()+"/", nil)
+	if err != nil {
+		return
+	}
+	if err := e.authorize(req); err != nil {
+		node.setHealth(err)
+		return
+	}
+	client := *e.client
+	client.Timeout = esHealthTimeout
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	node.setHealth(err)
+}
+
+// setHealth records a health check or request result, logging when a node goes down or comes back
+func (n *esNode) setHealth(err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.lastCheck = time.Now()
+	if err != nil {
+		n.lastError = err.Error()
+		if n.healthy {
+			log.Printf("ElasticSearch node %s is down: %s", n.url, err.Error())
+		}
+		n.healthy = false
+		return
+	}
+	if !n.healthy {
+		log.Printf("ElasticSearch node %s is back up", n.url)
+	}
+	n.healthy = true
+}
+
+func (n *esNode) isHealthy() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.healthy
+}
+
+// esNodeStatus is the state of a node on the status page
+type esNodeStatus struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"last_error,omitempty"`
+	LastCheck string `json:"last_check,omitempty"`
+}
+
+// status reports the state of every node
+func (e *esClient) status() []esNodeStatus {
+	nodes := []esNodeStatus{}
+	for _, n := range e.nodes {
+		n.lock.Lock()
+		s := esNodeStatus{URL: n.url.String(), Healthy: n.healthy}
+		if !n.healthy {
+			s.LastError = n.lastError
+		}
+		if !n.lastCheck.IsZero() {
+			s.LastCheck = n.lastCheck.UTC().Format(time.RFC3339)
+		}
+		n.lock.Unlock()
+		nodes = append(nodes, s)
+	}
+	return nodes
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// esNodeStandIn answers every request with a fixed status and counts the requests
+type esNodeStandIn struct {
+	status   int
+	requests int
+}
+
+func (s *esNodeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	w.WriteHeader(s.status)
+	w.Write([]byte(`{}`))
+}
+
+func TestESClientFailover(t *testing.T) {
+	for _, status := range []int{502, 503, 504} {
+		down := &esNodeStandIn{status: status}
+		up := &esNodeStandIn{status: 200}
+		downServer, upServer := httptest.NewServer(down), httptest.NewServer(up)
+		es, err := newESClient(esClientOptions{URL: downServer.URL + "," + upServer.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// requests take turns between the nodes, so one of the first two goes to the failing node
+		for n := 0; n < 2; n++ {
+			resp, err := es.request("POST", "/cloudtrail/_search", strings.NewReader(`{"size":0}`))
+			if err != nil {
+				t.Fatalf("%d: %s", status, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("%d: got %s, want a failover to the second node", status, resp.Status)
+			}
+		}
+		if down.requests != 1 || up.requests != 2 {
+			t.Errorf("%d: %d and %d requests, want the failing node tried once", status, down.requests, up.requests)
+		}
+		if es.nodes[0].isHealthy() {
+			t.Errorf("%d: node still healthy", status)
+		}
+		downServer.Close()
+		upServer.Close()
+	}
+}
+
+func TestESClientAllNodesFailing(t *testing.T) {
+	first, second := &esNodeStandIn{status: 503}, &esNodeStandIn{status: 503}
+	firstServer, secondServer := httptest.NewServer(first), httptest.NewServer(second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+	es, err := newESClient(esClientOptions{URL: firstServer.URL + "," + secondServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := es.request("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Errorf("got %s, want the last node's 503", resp.Status)
+	}
+	if first.requests+second.requests != 2 {
+		t.Errorf("%d requests, want one to each node", first.requests+second.requests)
+	}
+	if es.client.Timeout != esRequestTimeout {
+		t.Errorf("client timeout %s, want %s", es.client.Timeout, esRequestTimeout)
+	}
+}
+
+func TestStatusOnlyWithElasticSearch(t *testing.T) {
+	es, err := newESClient(esClientOptions{URL: "http://localhost:9200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enabled := range []bool{true, false} {
+		c := &config{es: es, esEnabled: enabled}
+		w := httptest.NewRecorder()
+		c.statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+		status := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := status["elasticsearch"]; ok != enabled {
+			t.Errorf("ElasticSearch output %v, but its status reported %v", enabled, ok)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// esVersion is the distribution and version of the cluster, which decides the request formats
+type esVersion struct {
+	OpenSearch bool
+	Major      int
+	Minor      int
+	Number     string
+}
+
+// parseESVersion reads a version number such as "7.17.3", prefixed "opensearch-" for OpenSearch
+func parseESVersion(s string) (esVersion, error) {
+	v := esVersion{}
+	if strings.HasPrefix(strings.ToLower(s), "opensearch-") {
+		v.OpenSearch = true
+		s = s[len("opensearch-"):]
+	}
+	v.Number = s
+	parts := strings.SplitN(s, ".", 3)
+	var err error
+	if v.Major, err = strconv.Atoi(parts[0]); err != nil {
+		return v, fmt.Errorf("Invalid ElasticSearch version %q", s)
+	}
+	if len(parts) > 1 {
+		if v.Minor, err = strconv.Atoi(parts[1]); err != nil {
+			return v, fmt.Errorf("Invalid ElasticSearch version %q", s)
+		}
+	}
+	return v, nil
+}
+
+func (v esVersion) String() string {
+	if v.OpenSearch {
+		return "OpenSearch " + v.Number
+	}
+	return "ElasticSearch " + v.Number
+}
+
+// supported reports whether this traildash knows the request formats of the version
+func (v esVersion) supported() bool {
+	if v.OpenSearch {
+		return v.Major >= 1 && v.Major <= 2
+	}
+	return v.Major >= 1 && v.Major <= 8
+}
+
+// typeless reports whether mappings and bulk actions no longer name a mapping type (ES 7+)
+func (v esVersion) typeless() bool {
+	return v.OpenSearch || v.Major >= 7
+}
+
+// keywordTypes reports whether "string" fields were split into "keyword" and "text" (ES 5+)
+func (v esVersion) keywordTypes() bool {
+	return v.OpenSearch || v.Major >= 5
+}
+
+// allField reports whether the _all field can still be configured (before ES 6)
+func (v esVersion) allField() bool {
+	return !v.OpenSearch && v.Major < 6
+}
+
+// indexPatterns reports whether templates use "index_patterns" rather than "template" (ES 6+)
+func (v esVersion) indexPatterns() bool {
+	return v.OpenSearch || v.Major >= 6
+}
+
+// boolFilter reports whether bool queries take a "filter" clause, replacing "filtered" queries (ES 2+)
+func (v esVersion) boolFilter() bool {
+	return v.OpenSearch || v.Major >= 2
+}
+
+// composableTemplates reports whether the _index_template API is available (ES 7.8+)
+func (v esVersion) composableTemplates() bool {
+	return v.OpenSearch || v.Major > 7 || (v.Major == 7 && v.Minor >= 8)
+}
+
+// detectVersion asks the cluster for its version unless ES_VERSION gave one
+func (c *config) detectVersion() error {
+	if c.esVersion.Major > 0 {
+		log.Printf("Using %s request formats (from ES_VERSION)", c.esVersion)
+		return nil
+	}
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	found, err := c.getJSON("/", &info)
+	if err != nil {
+		return err
+	} else if !found || len(info.Version.Number) < 1 {
+		return fmt.Errorf("ElasticSearch did not report its version.  Set ES_VERSION.")
+	}
+	number := info.Version.Number
+	if info.Version.Distribution == "opensearch" {
+		number = "opensearch-" + number
+	}
+	v, err := parseESVersion(number)
+	if err != nil {
+		return err
+	}
+	if !v.supported() {
+		log.Printf("WARNING: %s has not been tested with traildash.  Using the request formats of the nearest supported version.", v)
+	} else {
+		c.debug("Connected to %s", v)
+	}
+	c.esVersion = v
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// esStandIn answers like a cluster with no traildash templates or indices yet, serving a
+// recorded "GET /" response and keeping the templates it is sent
+type esStandIn struct {
+	info      []byte
+	lock      sync.Mutex
+	templates map[string]map[string]interface{} // by request path
+}
+
+func (s *esStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/":
+		w.Write(s.info)
+	case r.Method == "PUT":
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.lock.Lock()
+		s.templates[r.URL.Path] = body
+		s.lock.Unlock()
+		w.Write([]byte(`{"acknowledged":true}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestVersionMatrix(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		version     string
+		templateAPI string // "_template" or "_index_template"
+		typeless    bool
+		allField    bool
+		keywords    bool
+		patterns    bool // "index_patterns" rather than "template"
+	}{
+		{"es-1.7.json", "ElasticSearch 1.7.6", "_template", false, true, false, false},
+		{"es-2.4.json", "ElasticSearch 2.4.6", "_template", false, true, false, false},
+		{"es-5.6.json", "ElasticSearch 5.6.16", "_template", false, true, true, false},
+		{"es-6.8.json", "ElasticSearch 6.8.23", "_template", false, false, true, true},
+		{"es-7.7.json", "ElasticSearch 7.7.1", "_template", true, false, true, true},
+		{"es-7.17.json", "ElasticSearch 7.17.16", "_index_template", true, false, true, true},
+		{"es-8.11.json", "ElasticSearch 8.11.3", "_index_template", true, false, true, true},
+		{"opensearch-2.11.json", "OpenSearch 2.11.1", "_index_template", true, false, true, true},
+	}
+	for _, tt := range tests {
+		info, err := ioutil.ReadFile(filepath.Join("testdata", "esversion", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		standIn := &esStandIn{info: info, templates: map[string]map[string]interface{}{}}
+		server := httptest.NewServer(standIn)
+		es, err := newESClient(esClientOptions{URL: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &config{es: es, formatName: "cloudtrail"}
+		if err := c.setupIndices(); err != nil {
+			t.Fatalf("%s: %s", tt.fixture, err)
+		}
+		server.Close()
+
+		if c.esVersion.String() != tt.version {
+			t.Errorf("%s: detected %s, want %s", tt.fixture, c.esVersion, tt.version)
+		}
+		template, ok := standIn.templates["/"+tt.templateAPI+"/"+esIndex]
+		if !ok {
+			t.Errorf("%s: no template PUT to /%s/%s, got %v", tt.fixture, tt.templateAPI, esIndex, standIn.templates)
+			continue
+		} else if len(standIn.templates) != 2 {
+			t.Errorf("%s: %d templates installed, want cloudtrail and cloudtrail_insight", tt.fixture, len(standIn.templates))
+		}
+
+		body := template
+		if tt.templateAPI == "_index_template" {
+			body, _ = template["template"].(map[string]interface{})
+		}
+		_, hasPatterns := template["index_patterns"]
+		if hasPatterns != tt.patterns {
+			t.Errorf("%s: index_patterns %v, want %v", tt.fixture, hasPatterns, tt.patterns)
+		}
+		mapping, _ := body["mappings"].(map[string]interface{})
+		if typed, ok := mapping[esType].(map[string]interface{}); ok == tt.typeless {
+			t.Errorf("%s: mappings keyed by the %q type: %v, want typeless %v", tt.fixture, esType, ok, tt.typeless)
+		} else if ok {
+			mapping = typed
+		}
+		_, hasAll := mapping["_all"]
+		if hasAll != tt.allField {
+			t.Errorf("%s: _all %v, want %v", tt.fixture, hasAll, tt.allField)
+		}
+		properties, _ := mapping["properties"].(map[string]interface{})
+		account, _ := properties["RecipientAccountId"].(map[string]interface{})
+		if keyword := account["type"] == "keyword"; keyword != tt.keywords {
+			t.Errorf("%s: RecipientAccountId mapped as %v, want keyword types %v", tt.fixture, account, tt.keywords)
+		}
+		if !strings.Contains(tt.version, "OpenSearch") && c.esVersion.OpenSearch {
+			t.Errorf("%s: detected as OpenSearch", tt.fixture)
+		}
+	}
+}
+
+func TestParseESVersion(t *testing.T) {
+	tests := []struct {
+		in         string
+		major      int
+		minor      int
+		openSearch bool
+		err        bool
+	}{
+		{"7.17.3", 7, 17, false, false},
+		{"8", 8, 0, false, false},
+		{"opensearch-2.11", 2, 11, true, false},
+		{"OpenSearch-1.3.0", 1, 3, true, false},
+		{"seven", 0, 0, false, true},
+		{"7.x", 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		v, err := parseESVersion(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.in, err)
+			continue
+		}
+		if !tt.err && (v.Major != tt.major || v.Minor != tt.minor || v.OpenSearch != tt.openSearch) {
+			t.Errorf("%s: parsed %+v", tt.in, v)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileMaxBytes = 128 * 1024 * 1024
+	defaultFileMaxAge   = 1 * time.Hour
+	fileRotateCheck     = 10 * time.Second
+)
+
+// fileOutput archives events as gzip-compressed NDJSON files under YYYY/MM/DD directories by
+// event date.  Files are written with a ".tmp" suffix and renamed once complete, so anything
+// without the suffix is never half-written.  Each batch is a separate gzip member, synced to disk
+// before the batch is acknowledged.
+type fileOutput struct {
+	c        *config
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	lock     sync.Mutex
+	files    map[string]*ndjsonFile // open file of each day
+	seq      int
+}
+
+// ndjsonFile is a file being written
+type ndjsonFile struct {
+	path   string // final name, written as path + ".tmp"
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func newFileOutputFromEnv(c *config) (*fileOutput, error) {
+	dir := os.Getenv("FILE_OUTPUT_DIR")
+	if len(dir) < 1 {
+		return nil, fmt.Errorf("Must set FILE_OUTPUT_DIR for the file output.")
+	}
+	maxBytes, maxAge := int64(defaultFileMaxBytes), defaultFileMaxAge
+	var err error
+	if len(os.Getenv("FILE_MAX_BYTES")) > 0 {
+		if maxBytes, err = strconv.ParseInt(os.Getenv("FILE_MAX_BYTES"), 10, 64); err != nil || maxBytes < 1 {
+			return nil, fmt.Errorf("Invalid FILE_MAX_BYTES.  Must be a positive number of bytes.")
+		}
+	}
+	if len(os.Getenv("FILE_MAX_AGE")) > 0 {
+		if maxAge, err = time.ParseDuration(os.Getenv("FILE_MAX_AGE")); err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("Invalid FILE_MAX_AGE.  Must be a duration such as '1h'.")
+		}
+	}
+	return newFileOutput(c, dir, maxBytes, maxAge)
+}
+
+func newFileOutput(c *config, dir string, maxBytes int64, maxAge time.Duration) (*fileOutput, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating FILE_OUTPUT_DIR: %s", err.Error())
+	}
+	o := &fileOutput{c: c, dir: dir, maxBytes: maxBytes, maxAge: maxAge, files: map[string]*ndjsonFile{}}
+	if err := o.recover(); err != nil {
+		return nil, fmt.Errorf("Error recovering files in FILE_OUTPUT_DIR: %s", err.Error())
+	}
+	go o.rotateLoop()
+	return o, nil
+}
+
+func (o *fileOutput) send(events []*outputEvent, done func(error)) {
+	days := []string{}
+	byDay := map[string][]*outputEvent{}
+	for _, e := range events {
+		day := e.Record.eventTime().Format("2006/01/02")
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], e)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, day := range days {
+		if err := o.write(day, byDay[day]); err != nil {
+			done(fmt.Errorf("Error writing file output: %s", err.Error()))
+			return
+		}
+	}
+	done(nil)
+}
+
+// write appends events to the open file of a day as one gzip member and syncs it
+func (o *fileOutput) write(day string, events []*outputEvent) error {
+	file, ok := o.files[day]
+	if !ok {
+		var err error
+		if file, err = o.create(day); err != nil {
+			return err
+		}
+		o.files[day] = file
+	}
+
+	gz := gzip.NewWriter(file.f)
+	for _, e := range events {
+		gz.Write(e.Doc)
+		gz.Write([]byte("\n"))
+	}
+	err := gz.Close()
+	if err == nil {
+		err = file.f.Sync()
+	}
+	if err != nil {
+		// the file may end in a partial member, so finish it with only its complete batches
+		delete(o.files, day)
+		file.f.Close()
+		if serr := salvage(file.path + ".tmp"); serr != nil {
+			log.Printf("Error salvaging %s: %s", file.path+".tmp", serr.Error())
+		}
+		return err
+	}
+	if fi, err := file.f.Stat(); err == nil {
+		file.size = fi.Size()
+	}
+	if file.size >= o.maxBytes {
+		// the batch is safely written, so a failure to finish the file mustn't have it sent again.
+		// The file keeps its ".tmp" name and is finished at the next startup.
+		if err := o.rotate(day); err != nil {
+			log.Printf("Error finishing %s: %s", file.path, err.Error())
+		}
+	}
+	return nil
+}
+
+// create opens a new temporary file in the directory of a day
+func (o *fileOutput) create(day string) (*ndjsonFile, error) {
+	dir := filepath.Join(o.dir, filepath.FromSlash(day))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	o.seq++
+	name := fmt.Sprintf("cloudtrail-%s-%d-%d.ndjson.gz", now.Format("20060102T150405Z"), os.Getpid(), o.seq)
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &ndjsonFile{path: path, f: f, opened: now}, nil
+}
+
+// rotate closes the file of a day and renames it to its final name
+func (o *fileOutput) rotate(day string) error {
+	file := o.files[day]
+	delete(o.files, day)
+	if err := file.f.Sync(); err != nil {
+		file.f.Close()
+		return err
+	}
+	if err := file.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.path+".tmp", file.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(file.path))
+	o.c.debug("Wrote %s (%d bytes)", file.path, file.size)
+	return nil
+}
+
+// rotateLoop finishes files which have been open longer than the maximum age
+func (o *fileOutput) rotateLoop() {
+	for range time.Tick(fileRotateCheck) {
+		o.lock.Lock()
+		for day, file := range o.files {
+			if time.Since(file.opened) >= o.maxAge {
+				if err := o.rotate(day); err != nil {
+					log.Printf("Error finishing %s: %s", file.path, err.Error())
+				}
+			}
+		}
+		o.lock.Unlock()
+	}
+}
+
+// recover finishes files left behind by a crash.  Every batch in them was synced before it was
+// acknowledged, so only a trailing batch which was never acknowledged can be incomplete.
+func (o *fileOutput) recover() error {
+	// salvaging creates and renames files, so find them all before changing any
+	stale, unfinished := []string{}, []string{}
+	err := filepath.Walk(o.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp.new") {
+			stale = append(stale, path)
+		} else if strings.HasSuffix(path, ".ndjson.gz.tmp") {
+			unfinished = append(unfinished, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	for _, path := range unfinished {
+		log.Printf("Recovering %s", path)
+		if err := salvage(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// salvage renames an unfinished file into place, rewriting it without any incomplete batch.
+// Each batch is one gzip member, so a batch is only kept if its whole member, checksum
+// included, was written.
+func salvage(tmp string) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	batches := [][]byte{}
+	r := bufio.NewReader(f)
+	gz, err := gzip.NewReader(r)
+	for err == nil {
+		gz.Multistream(false)
+		batch, rerr := ioutil.ReadAll(gz)
+		if rerr != nil {
+			break // a partial member
+		}
+		batches = append(batches, batch)
+		err = gz.Reset(r)
+	}
+	complete := err == io.EOF
+	f.Close()
+
+	final := strings.TrimSuffix(tmp, ".tmp")
+	if len(batches) < 1 {
+		return os.Remove(tmp)
+	} else if complete {
+		if err := os.Rename(tmp, final); err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(final))
+		return nil
+	}
+
+	out, err := os.OpenFile(tmp+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		gz := gzip.NewWriter(out)
+		gz.Write(batch)
+		if err := gz.Close(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp+".new", final); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(final))
+	return os.Remove(tmp)
+}
+
+// syncDir makes renames in a directory durable
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gzipLines(t *testing.T, lines string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(lines))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFileOutputRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	day := filepath.Join(dir, "2024", "01", "02")
+	if err := os.MkdirAll(day, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a complete file, one whose last batch was cut off, and a rewrite left by an earlier crash
+	complete := filepath.Join(day, "cloudtrail-1.ndjson.gz")
+	ioutil.WriteFile(complete+".tmp", gzipLines(t, "{\"a\":1}\n{\"a\":2}\n"), 0644)
+	truncated := filepath.Join(day, "cloudtrail-2.ndjson.gz")
+	second := gzipLines(t, "{\"b\":2}\n")
+	ioutil.WriteFile(truncated+".tmp", append(gzipLines(t, "{\"b\":1}\n"), second[:len(second)/2]...), 0644)
+	ioutil.WriteFile(truncated+".tmp.new", []byte("partial rewrite"), 0644)
+	// and one whose last batch is missing only its gzip trailer, so its lines all decompress
+	// but the batch wasn't finished and will be sent again
+	trailer := filepath.Join(day, "cloudtrail-3.ndjson.gz")
+	third := gzipLines(t, "{\"c\":2}\n{\"c\":3}\n")
+	ioutil.WriteFile(trailer+".tmp", append(gzipLines(t, "{\"c\":1}\n"), third[:len(third)-4]...), 0644)
+
+	o := &fileOutput{dir: dir}
+	if err := o.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readGzip(t, complete); got != "{\"a\":1}\n{\"a\":2}\n" {
+		t.Errorf("complete file recovered as %q", got)
+	}
+	if got := readGzip(t, truncated); got != "{\"b\":1}\n" {
+		t.Errorf("truncated file recovered as %q, want only the whole batch", got)
+	}
+	if got := readGzip(t, trailer); got != "{\"c\":1}\n" {
+		t.Errorf("file without its last trailer recovered as %q, want only the whole batch", got)
+	}
+	for _, leftover := range []string{complete + ".tmp", truncated + ".tmp", truncated + ".tmp.new", trailer + ".tmp"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", leftover)
+		}
+	}
+}
+
+// testFileOutput is a file output in a temporary directory, without the rotation timer
+func testFileOutput(t *testing.T, maxBytes int64) *fileOutput {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fileOutput{c: &config{}, dir: dir, maxBytes: maxBytes, maxAge: time.Hour, files: map[string]*ndjsonFile{}}
+}
+
+// fileEvents are events of 2024-01-02 with the documents {"n":from} to {"n":to-1}
+func fileEvents(from, to int) []*outputEvent {
+	events := []*outputEvent{}
+	for n := from; n < to; n++ {
+		e := storeEvent(fmt.Sprintf("event-%d", n), "2024-01-02T03:04:05Z", "RunInstances")
+		e.Doc = []byte(fmt.Sprintf(`{"n":%d}`, n))
+		events = append(events, e)
+	}
+	return events
+}
+
+// dayFiles lists the files written for 2024-01-02
+func dayFiles(t *testing.T, o *fileOutput) (finished, unfinished []string) {
+	paths, _ := filepath.Glob(filepath.Join(o.dir, "2024", "01", "02", "*"))
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".tmp") {
+			unfinished = append(unfinished, path)
+		} else {
+			finished = append(finished, path)
+		}
+	}
+	return finished, unfinished
+}
+
+func TestFileOutputRotate(t *testing.T) {
+	o := testFileOutput(t, 1)
+	defer os.RemoveAll(o.dir)
+
+	// every batch fills a file, so each is finished as soon as it is written
+	var sendErr error
+	o.send(fileEvents(0, 2), func(err error) { sendErr = err })
+	o.send(fileEvents(2, 3), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	finished, unfinished := dayFiles(t, o)
+	if len(finished) != 2 || len(unfinished) != 0 || len(o.files) != 0 {
+		t.Fatalf("finished %v and unfinished %v, want two finished files", finished, unfinished)
+	}
+	if got := readGzip(t, finished[0]) + readGzip(t, finished[1]); got != "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n" {
+		t.Errorf("files hold %q", got)
+	}
+
+	// batches are appended until the file is big enough
+	o = testFileOutput(t, 1024*1024)
+	defer os.RemoveAll(o.dir)
+	o.send(fileEvents(0, 1), func(err error) { sendErr = err })
+	o.send(fileEvents(1, 2), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	finished, unfinished = dayFiles(t, o)
+	if len(finished) != 0 || len(unfinished) != 1 {
+		t.Fatalf("finished %v and unfinished %v, want one unfinished file", finished, unfinished)
+	}
+	if err := o.rotate("2024/01/02"); err != nil {
+		t.Fatal(err)
+	}
+	finished, _ = dayFiles(t, o)
+	if len(finished) != 1 || readGzip(t, finished[0]) != "{\"n\":0}\n{\"n\":1}\n" {
+		t.Errorf("finished %v, want one file of both batches", finished)
+	}
+}
+
+func TestFileOutputRotateFailure(t *testing.T) {
+	o := testFileOutput(t, 1024*1024)
+	defer os.RemoveAll(o.dir)
+	var sendErr error
+	o.send(fileEvents(0, 1), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+
+	// a directory in the way of the final name makes the rename fail
+	file := o.files["2024/01/02"]
+	if err := os.MkdirAll(filepath.Join(file.path, "in-the-way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	o.maxBytes = 1
+	o.send(fileEvents(1, 2), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Errorf("batch reported as failed after it was written: %s", sendErr)
+	}
+	if got := readGzip(t, file.path+".tmp"); got != "{\"n\":0}\n{\"n\":1}\n" {
+		t.Errorf("unfinished file holds %q", got)
+	}
+
+	// the next batch starts a new file
+	os.RemoveAll(file.path)
+	o.send(fileEvents(2, 3), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	if finished, _ := dayFiles(t, o); len(finished) != 1 || readGzip(t, finished[0]) != "{\"n\":2}\n" {
+		t.Errorf("finished %v, want a new file of the last batch", finished)
+	}
+}
+
+func TestFileOutputWriteFailure(t *testing.T) {
+	o := testFileOutput(t, 1024*1024)
+	defer os.RemoveAll(o.dir)
+	var sendErr error
+	o.send(fileEvents(0, 1), func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+
+	// a batch which can't be written is reported, and the file is finished with the batches
+	// before it
+	file := o.files["2024/01/02"]
+	file.f.Close()
+	o.send(fileEvents(1, 2), func(err error) { sendErr = err })
+	if sendErr == nil {
+		t.Errorf("failed batch reported as written")
+	}
+	finished, unfinished := dayFiles(t, o)
+	if len(finished) != 1 || len(unfinished) != 0 || len(o.files) != 0 {
+		t.Fatalf("finished %v and unfinished %v, want the file finished", finished, unfinished)
+	}
+	if got := readGzip(t, finished[0]); got != "{\"n\":0}\n" {
+		t.Errorf("file holds %q, want only the written batch", got)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const filterReportInterval = 15 * time.Minute
+
+// filterRules decides which CloudTrail records are indexed.  Rules are checked in order
+// and the first match wins; records matching no rule follow Default.
+type filterRules struct {
+	Default      string        `json:"default"`
+	Rules        []*filterRule `json:"rules"`
+	defaultStats filterStats
+}
+
+// filterRule matches records on glob patterns ("Describe*") - empty fields match anything
+type filterRule struct {
+	Name        string   `json:"name"`
+	Action      string   `json:"action"`
+	EventSource string   `json:"eventSource"`
+	EventName   string   `json:"eventName"`
+	Principal   string   `json:"principal"`
+	UserAgent   string   `json:"userAgent"`
+	ReadOnly    *bool    `json:"readOnly"`
+	Account     string   `json:"account"`
+	SampleRate  *float64 `json:"sampleRate"`
+	stats       filterStats
+}
+
+// filterStats counts filter decisions, updated atomically
+type filterStats struct {
+	indexed uint64
+	dropped uint64
+}
+
+// loadFilterRules reads and validates a JSON rules file
+func loadFilterRules(file string) (*filterRules, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f := filterRules{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("JSON error in %s: %s", file, err.Error())
+	}
+	if len(f.Default) < 1 {
+		f.Default = "include"
+	}
+	if f.Default != "include" && f.Default != "exclude" {
+		return nil, fmt.Errorf("Invalid default action %q.  Must be 'include' or 'exclude'.", f.Default)
+	}
+	for i, r := range f.Rules {
+		if len(r.Name) < 1 {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if r.Action != "include" && r.Action != "exclude" {
+			return nil, fmt.Errorf("Invalid action %q in rule %s.  Must be 'include' or 'exclude'.", r.Action, r.Name)
+		}
+		if r.SampleRate != nil && (*r.SampleRate < 0 || *r.SampleRate > 1) {
+			return nil, fmt.Errorf("Invalid sampleRate in rule %s.  Must be between 0 and 1.", r.Name)
+		}
+		for _, p := range []string{r.EventSource, r.EventName, r.Principal, r.UserAgent, r.Account} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("Invalid pattern %q in rule %s: %s", p, r.Name, err.Error())
+			}
+		}
+	}
+	return &f, nil
+}
+
+// filter returns the records which should be indexed
+func (f *filterRules) filter(records *[]cloudtrailRecord) *[]cloudtrailRecord {
+	kept := make([]cloudtrailRecord, 0, len(*records))
+	for _, r := range *records {
+		if f.keep(&r) {
+			kept = append(kept, r)
+		}
+	}
+	return &kept
+}
+
+// keep applies the first matching rule to a record and counts the outcome
+func (f *filterRules) keep(r *cloudtrailRecord) bool {
+	for _, rule := range f.Rules {
+		if rule.matches(r) {
+			return rule.stats.count(sampled(r.EventID, rule.rate()))
+		}
+	}
+	return f.defaultStats.count(f.Default == "include")
+}
+
+// rate is the fraction of matching records which are indexed
+func (rule *filterRule) rate() float64 {
+	if rule.SampleRate != nil {
+		return *rule.SampleRate
+	} else if rule.Action == "include" {
+		return 1
+	}
+	return 0
+}
+
+// matches checks every non-empty criteria in a rule against a record
+func (rule *filterRule) matches(r *cloudtrailRecord) bool {
+	if !globMatch(rule.EventSource, r.EventSource) ||
+		!globMatch(rule.EventName, r.EventName) ||
+		!globMatch(rule.UserAgent, r.UserAgent) ||
+		!globMatch(rule.Account, r.RecipientAccountId) {
+		return false
+	}
+	if rule.ReadOnly != nil && (r.ReadOnly == nil || *r.ReadOnly != *rule.ReadOnly) {
+		return false
+	}
+	if len(rule.Principal) > 0 {
+		for _, k := range []string{"arn", "userName", "principalId"} {
+			if v, ok := r.UserIdentity[k].(string); ok && globMatch(rule.Principal, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// report logs indexed vs dropped counts for each rule
+func (f *filterRules) report() {
+	for _, rule := range f.Rules {
+		log.Printf("Filter rule %s (%s): %d indexed, %d dropped", rule.Name, rule.Action,
+			atomic.LoadUint64(&rule.stats.indexed), atomic.LoadUint64(&rule.stats.dropped))
+	}
+	log.Printf("Filter default (%s): %d indexed, %d dropped", f.Default,
+		atomic.LoadUint64(&f.defaultStats.indexed), atomic.LoadUint64(&f.defaultStats.dropped))
+}
+
+// reportLoop periodically logs filter counts
+func (f *filterRules) reportLoop() {
+	for range time.Tick(filterReportInterval) {
+		f.report()
+	}
+}
+
+// count records a filter decision and returns it
+func (s *filterStats) count(keep bool) bool {
+	if keep {
+		atomic.AddUint64(&s.indexed, 1)
+	} else {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return keep
+}
+
+// sampled deterministically selects a fraction of events by hashing the event ID,
+// so re-processing a CloudTrail file makes the same decisions
+func sampled(eventID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	} else if rate <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(eventID))
+	return float64(h.Sum32())/float64(1<<32) < rate
+}
+
+// globMatch matches a shell-style pattern, with an empty pattern matching anything
+func globMatch(pattern, s string) bool {
+	if len(pattern) < 1 {
+		return true
+	}
+	// path.Match won't let "*" cross a "/", which is common in ARNs and user agents
+	ok, _ := path.Match(strings.Replace(pattern, "/", "\x00", -1), strings.Replace(s, "/", "\x00", -1))
+	return ok
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRules writes a rules file to a temporary directory, returning its path
+func writeRules(t *testing.T, dir, rules string) string {
+	file := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(file, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadFilterRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := loadFilterRules(writeRules(t, dir, `{"rules":[
+		{"action":"exclude","eventName":"Describe*"},
+		{"name":"s3-reads","action":"include","sampleRate":0.5}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Default != "include" {
+		t.Errorf("default %q, want include", f.Default)
+	}
+	if f.Rules[0].Name != "rule1" || f.Rules[1].Name != "s3-reads" {
+		t.Errorf("rules named %q and %q, want rule1 and s3-reads", f.Rules[0].Name, f.Rules[1].Name)
+	}
+
+	invalid := []struct {
+		rules string
+		err   string
+	}{
+		{`{"rules":[`, "JSON error"},
+		{`{"default":"drop"}`, "Invalid default action"},
+		{`{"rules":[{"eventName":"Describe*"}]}`, "Invalid action"},
+		{`{"rules":[{"action":"keep"}]}`, "Invalid action"},
+		{`{"rules":[{"action":"include","sampleRate":1.5}]}`, "Invalid sampleRate"},
+		{`{"rules":[{"action":"include","sampleRate":-0.1}]}`, "Invalid sampleRate"},
+		{`{"rules":[{"action":"exclude","eventName":"Describe["}]}`, "Invalid pattern"},
+		{`{"rules":[{"action":"exclude","principal":"arn:aws:iam::*:role/[a-"}]}`, "Invalid pattern"},
+	}
+	for _, tt := range invalid {
+		_, err := loadFilterRules(writeRules(t, dir, tt.rules))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.rules, err, tt.err)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"", "anything", true},
+		{"", "", true},
+		{"Describe*", "DescribeInstances", true},
+		{"Describe*", "RunInstances", false},
+		{"*.amazonaws.com", "s3.amazonaws.com", true},
+		{"Get?bject", "GetObject", true},
+		{"[GL]ist*", "ListBuckets", true},
+
+		// "*" and "?" cross the "/" in ARNs and user agents
+		{"arn:aws:sts::*:assumed-role/*", "arn:aws:sts::123456789012:assumed-role/admin/alice", true},
+		{"*datadog*", "datadog-agent/7.50.0 (linux)", true},
+		{"aws-cli/*", "aws-cli/2.15.0 Python/3.11.6", true},
+		{"a?b", "a/b", true},
+		{"*", "a/b/c", true},
+		{"arn:aws:iam::*:role/ops", "arn:aws:iam::123456789012:role/ops/extra", false},
+
+		// a "/" in a pattern, escaped or in a class, matches itself
+		{"a/b", "a/b", true},
+		{`a\/b`, "a/b", true},
+		{"a/b", "ab", false},
+		{"a[/]b", "a/b", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.match)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	readOnly, write := true, false
+	rec := &cloudtrailRecord{
+		EventSource:        "s3.amazonaws.com",
+		EventName:          "GetObject",
+		UserAgent:          "aws-sdk-go/1.44.0",
+		RecipientAccountId: "123456789012",
+		ReadOnly:           &readOnly,
+		UserIdentity: map[string]interface{}{
+			"arn":         "arn:aws:sts::123456789012:assumed-role/reader/i-0abc",
+			"principalId": "AROAEXAMPLE:i-0abc",
+		},
+	}
+	tests := []struct {
+		rule  filterRule
+		match bool
+	}{
+		{filterRule{}, true},
+		{filterRule{EventSource: "s3.*", EventName: "Get*", Account: "1234*"}, true},
+		{filterRule{EventSource: "ec2.*"}, false},
+		{filterRule{ReadOnly: &readOnly}, true},
+		{filterRule{ReadOnly: &write}, false},
+		{filterRule{Principal: "*assumed-role/reader/*"}, true},
+		{filterRule{Principal: "AROAEXAMPLE:*"}, true},
+		{filterRule{Principal: "alice"}, false},
+		{filterRule{UserAgent: "aws-sdk-go/*"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(rec); got != tt.match {
+			t.Errorf("%+v matched %v, want %v", tt.rule, got, tt.match)
+		}
+	}
+
+	// a readOnly rule doesn't match records which don't say
+	if (&filterRule{ReadOnly: &write}).matches(&cloudtrailRecord{}) {
+		t.Errorf("readOnly rule matched a record without ReadOnly")
+	}
+}
+
+func TestFilterFirstMatchWins(t *testing.T) {
+	half := 0.5
+	f := &filterRules{Default: "exclude", Rules: []*filterRule{
+		{Name: "describes", Action: "exclude", EventName: "Describe*"},
+		{Name: "ec2", Action: "include", EventSource: "ec2.amazonaws.com"},
+		{Name: "s3", Action: "include", EventSource: "s3.amazonaws.com", SampleRate: &half},
+	}}
+	records := []cloudtrailRecord{
+		{EventID: "1", EventSource: "ec2.amazonaws.com", EventName: "DescribeInstances"},
+		{EventID: "2", EventSource: "ec2.amazonaws.com", EventName: "RunInstances"},
+		{EventID: "3", EventSource: "iam.amazonaws.com", EventName: "CreateUser"},
+	}
+	kept := *f.filter(&records)
+	if len(kept) != 1 || kept[0].EventID != "2" {
+		t.Errorf("kept %+v, want only event 2", kept)
+	}
+	counts := []filterStats{f.Rules[0].stats, f.Rules[1].stats, f.Rules[2].stats, f.defaultStats}
+	want := []filterStats{{dropped: 1}, {indexed: 1}, {}, {dropped: 1}}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("counts %d are %+v, want %+v", i, counts[i], want[i])
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	if !sampled("any", 1) || sampled("any", 0) || !sampled("any", 1.5) || sampled("any", -1) {
+		t.Errorf("rates of 0 and 1 don't drop or keep everything")
+	}
+
+	// the same event gets the same decision every time, and a higher rate keeps whatever a
+	// lower rate kept
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("event-%d", i)
+		if sampled(id, 0.3) != sampled(id, 0.3) {
+			t.Fatalf("%s sampled differently", id)
+		}
+		if sampled(id, 0.3) && !sampled(id, 0.6) {
+			t.Errorf("%s kept at 0.3 but not 0.6", id)
+		}
+	}
+
+	// the fraction kept is close to the rate
+	for _, rate := range []float64{0.01, 0.1, 0.5, 0.9} {
+		kept, n := 0, 100000
+		for i := 0; i < n; i++ {
+			if sampled(fmt.Sprintf("%08x-4f1c-8a3e-%012d", i, i), rate) {
+				kept++
+			}
+		}
+		if got := float64(kept) / float64(n); got < rate*0.9 || got > rate*1.1 {
+			t.Errorf("rate %v kept %v", rate, got)
+		}
+	}
+}
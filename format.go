@@ -0,0 +1,24 @@
+package main
+
+// documentFormatter converts a CloudTrail record into the document which is stored
+type documentFormatter func(r *cloudtrailRecord) interface{}
+
+var outputFormatMap = map[string]documentFormatter{
+	"cloudtrail": rawDocument,
+	"ecs":        ecsDocument,
+	"ocsf":       ocsfDocument,
+}
+
+// rawDocument stores records with their CloudTrail field names
+func rawDocument(r *cloudtrailRecord) interface{} {
+	if r.isInsight() {
+		return r.insight()
+	}
+	return r
+}
+
+// failed reports whether CloudTrail recorded the call as unsuccessful.  Failed console
+// logins carry only an error message.
+func (r *cloudtrailRecord) failed() bool {
+	return len(r.ErrorCode) > 0 || r.ErrorMessage == "Failed authentication"
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBulkMaxBytes      = 5 * 1024 * 1024
+	defaultBulkMaxDocs       = 1000
+	defaultBulkFlushInterval = 5 * time.Second
+	maxPendingFiles          = 50
+	bulkWorkers              = 4
+)
+
+// indexer fills ElasticSearch bulk requests from several CloudTrail files, up to a byte and
+// document limit, and flushes partial batches on a timer
+type indexer struct {
+	c             *config
+	maxBytes      int
+	maxDocs       int
+	flushInterval time.Duration
+	jobs          chan *indexJob
+	pending       chan struct{} // limits files waiting to be indexed
+	sending       chan struct{} // limits bulk requests in flight, retries included
+	batch         []*bulkItem
+	batchBytes    int
+}
+
+// indexJob tracks the documents of one CloudTrail file until every one is confirmed
+type indexJob struct {
+	lock      sync.Mutex
+	items     []*bulkItem
+	remaining int
+	err       error
+	done      func(error)
+}
+
+func newIndexer(c *config, maxBytes, maxDocs int, flushInterval time.Duration) *indexer {
+	return &indexer{
+		c:             c,
+		maxBytes:      maxBytes,
+		maxDocs:       maxDocs,
+		flushInterval: flushInterval,
+		jobs:          make(chan *indexJob),
+		pending:       make(chan struct{}, maxPendingFiles),
+		sending:       make(chan struct{}, bulkWorkers),
+	}
+}
+
+// submit queues the documents of one file, calling done once all are indexed (or dead-lettered)
+// or with the first error.  It blocks while too many files are waiting.
+func (ix *indexer) submit(items []*bulkItem, done func(error)) {
+	ix.pending <- struct{}{}
+	job := &indexJob{items: items, remaining: len(items)}
+	job.done = func(err error) {
+		<-ix.pending
+		done(err)
+	}
+	for _, i := range items {
+		i.job = job
+	}
+	ix.jobs <- job
+}
+
+// run batches submitted documents until the program exits
+func (ix *indexer) run() {
+	ticker := time.NewTicker(ix.flushInterval)
+	for {
+		select {
+		case job := <-ix.jobs:
+			if len(job.items) < 1 {
+				go job.done(nil)
+				continue
+			}
+			for _, i := range job.items {
+				ix.add(i)
+			}
+		case <-ticker.C:
+			ix.flush()
+		}
+	}
+}
+
+// add appends a document to the batch, sending the batch first if it would grow too big
+func (ix *indexer) add(i *bulkItem) {
+	size := len(i.Doc) + 128 // allow for the action line
+	if len(ix.batch) > 0 && ix.batchBytes+size > ix.maxBytes {
+		ix.flush()
+	}
+	ix.batch = append(ix.batch, i)
+	ix.batchBytes += size
+	if len(ix.batch) >= ix.maxDocs || ix.batchBytes >= ix.maxBytes {
+		ix.flush()
+	}
+}
+
+// flush hands the current batch to a bulk worker, so batching carries on while it is sent and
+// retried.  It only waits when every worker is busy.
+func (ix *indexer) flush() {
+	if len(ix.batch) < 1 {
+		return
+	}
+	batch := ix.batch
+	ix.batch = nil
+	ix.batchBytes = 0
+
+	ix.sending <- struct{}{}
+	go func() {
+		ix.send(batch)
+		<-ix.sending
+	}()
+}
+
+// send indexes a batch and acknowledges each document to its file
+func (ix *indexer) send(batch []*bulkItem) {
+	failed, err := ix.c.bulk(batch)
+	if err != nil {
+		ix.c.debug("Bulk request of %d documents failed: %s", len(batch), err.Error())
+	}
+	unconfirmed := map[*bulkItem]bool{}
+	for _, i := range failed {
+		unconfirmed[i] = true
+	}
+	for _, i := range batch {
+		if unconfirmed[i] {
+			i.job.ack(fmt.Errorf("Document %s/%s not indexed: %s", i.Index, i.ID, err.Error()))
+		} else {
+			i.job.ack(nil)
+		}
+	}
+}
+
+// ack confirms one document of a job, finishing the job after the last one
+func (j *indexJob) ack(err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if err != nil && j.err == nil {
+		j.err = err
+	}
+	j.remaining--
+	if j.remaining == 0 {
+		go j.done(j.err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+)
+
+const esIndexDateFormat = "2006.01.02"
+
+// dailyIndex names the time-partitioned index for a record, based on when the event happened
+func dailyIndex(r *cloudtrailRecord) string {
+	alias := esIndex
+	if r.isInsight() {
+		alias = esInsightIndex
+	}
+	return alias + "-" + r.eventTime().Format(esIndexDateFormat)
+}
+
+// eventTime is when the event happened in UTC, or now if the record has no valid time
+func (r *cloudtrailRecord) eventTime() time.Time {
+	t, err := time.Parse(time.RFC3339, r.EventTime)
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC()
+}
+
+// setupIndices installs the templates which give every daily index its mappings and adds it
+// to an alias, so dashboards can keep searching "cloudtrail"
+func (c *config) setupIndices() error {
+	if err := c.detectVersion(); err != nil {
+		return err
+	}
+	legacy, err := c.isConcreteIndex(esIndex)
+	if err != nil {
+		return err
+	}
+	c.legacyIdx = legacy
+	if legacy {
+		log.Printf("Found a pre-daily ElasticSearch index named %q.  New events are stored in daily %s-YYYY.MM.DD indices and searches of %q include both.  Delete the old index once it is no longer needed.", esIndex, esIndex, esIndex)
+	}
+
+	if err := c.installTemplate(esIndex, false, !legacy); err != nil {
+		return err
+	}
+	if err := c.installTemplate(esInsightIndex, true, true); err != nil {
+		return err
+	}
+	if err := c.checkMappings(esIndex); err != nil {
+		return err
+	}
+	return c.checkMappings(esInsightIndex)
+}
+
+// putTemplate creates or replaces an index template, composable where the cluster supports it
+func (c *config) putTemplate(name string, template map[string]interface{}) error {
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	api := "/_template/"
+	if c.esVersion.composableTemplates() {
+		api = "/_index_template/"
+	}
+	resp, err := c.es.request("PUT", api+name, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != "200 OK" {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Error response from Elasticsearch: %s %s", resp.Status, string(b))
+	}
+	return nil
+}
+
+// isConcreteIndex checks whether name is an index rather than an alias
+func (c *config) isConcreteIndex(name string) (bool, error) {
+	resp, err := c.es.request("HEAD", "/"+name, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return false, nil
+	}
+	resp, err = c.es.request("HEAD", "/_alias/"+name, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == 404, nil
+}
+
+// legacyIndexPath makes proxied searches of the "cloudtrail" alias also cover a pre-daily
+// index of the same name, since an alias can't be created while that index exists
+func (c *config) legacyIndexPath(path string) string {
+	if !c.legacyIdx {
+		return path
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if parts[0] != esIndex {
+		return path
+	}
+	parts[0] = esIndex + "," + esIndex + "-*"
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,70 @@
+package main
+
+const insightEventType = "AwsCloudTrailInsight"
+
+// insightRecord is a CloudTrail Insights event, which shares only a few fields with API events
+type insightRecord struct {
+	EventVersion       string
+	EventTime          string
+	AwsRegion          string
+	EventID            string
+	EventType          string
+	EventCategory      string
+	RecipientAccountId string
+	SharedEventID      string
+	InsightDetails     *insightDetails
+	Provenance         *provenance `json:",omitempty"`
+}
+
+type insightDetails struct {
+	State          string
+	EventSource    string
+	EventName      string
+	ErrorCode      string `json:",omitempty"`
+	InsightType    string
+	InsightContext struct {
+		Statistics struct {
+			Baseline         insightStatistics
+			Insight          insightStatistics
+			BaselineDuration int64
+			InsightDuration  int64
+		}
+		Attributions []insightAttribution
+	}
+}
+
+type insightStatistics struct {
+	Average float64
+}
+
+type insightAttribution struct {
+	Attribute string
+	Insight   []insightAttributionValue
+	Baseline  []insightAttributionValue
+}
+
+type insightAttributionValue struct {
+	Value   string
+	Average float64
+}
+
+// isInsight reports whether a record is a CloudTrail Insights event
+func (r *cloudtrailRecord) isInsight() bool {
+	return r.EventType == insightEventType
+}
+
+// insight extracts the Insights view of a record
+func (r *cloudtrailRecord) insight() *insightRecord {
+	return &insightRecord{
+		EventVersion:       r.EventVersion,
+		EventTime:          r.EventTime,
+		AwsRegion:          r.AwsRegion,
+		EventID:            r.EventID,
+		EventType:          r.EventType,
+		EventCategory:      r.EventCategory,
+		RecipientAccountId: r.RecipientAccountId,
+		SharedEventID:      r.SharedEventID,
+		InsightDetails:     r.InsightDetails,
+		Provenance:         r.Provenance,
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal Kafka producer: enough of the wire protocol to find partition leaders and produce
+// record batches, with SASL authentication.  Request versions are the oldest ones Kafka 4 still
+// accepts, so it works with brokers from 1.0 on.
+// See https://kafka.apache.org/protocol
+
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 4
+
+	kafkaClientID       = "traildash"
+	kafkaRequestTimeout = 30 * time.Second
+)
+
+// kafkaRetriable are errors which go away once leadership settles or replicas catch up:
+// UNKNOWN_TOPIC_OR_PARTITION, LEADER_NOT_AVAILABLE, NOT_LEADER_OR_FOLLOWER, REQUEST_TIMED_OUT,
+// NETWORK_EXCEPTION, NOT_ENOUGH_REPLICAS and NOT_ENOUGH_REPLICAS_AFTER_APPEND
+var kafkaRetriable = map[int16]bool{3: true, 5: true, 6: true, 7: true, 13: true, 19: true, 20: true}
+
+// kafkaError is an error code returned by a broker
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	names := map[kafkaError]string{
+		1: "OFFSET_OUT_OF_RANGE", 2: "CORRUPT_MESSAGE", 3: "UNKNOWN_TOPIC_OR_PARTITION",
+		5: "LEADER_NOT_AVAILABLE", 6: "NOT_LEADER_OR_FOLLOWER", 7: "REQUEST_TIMED_OUT",
+		10: "MESSAGE_TOO_LARGE", 13: "NETWORK_EXCEPTION", 17: "INVALID_TOPIC_EXCEPTION",
+		18: "RECORD_LIST_TOO_LARGE", 19: "NOT_ENOUGH_REPLICAS", 20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+		29: "TOPIC_AUTHORIZATION_FAILED", 33: "UNSUPPORTED_SASL_MECHANISM", 34: "ILLEGAL_SASL_STATE",
+		58: "SASL_AUTHENTICATION_FAILED",
+	}
+	if name, ok := names[e]; ok {
+		return fmt.Sprintf("Kafka error %d (%s)", int16(e), name)
+	}
+	return fmt.Sprintf("Kafka error %d", int16(e))
+}
+
+// kafkaBroker is a connection to one broker.  Requests on it are sent one at a time.
+type kafkaBroker struct {
+	addr        string
+	dial        func(addr string) (net.Conn, error)
+	sasl        *kafkaSASL
+	lock        sync.Mutex
+	conn        net.Conn
+	correlation int32
+}
+
+// kafkaSASL is the SASL mechanism and credentials to authenticate connections with
+type kafkaSASL struct {
+	mechanism string // "PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512"
+	username  string
+	password  string
+}
+
+// request sends a request and returns the response body, connecting first if needed.  The
+// connection is dropped after any error, so the next request starts afresh.
+func (b *kafkaBroker) request(apiKey, version int16, body []byte) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == nil {
+		if err := b.connect(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := b.roundTrip(apiKey, version, body)
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return resp, err
+}
+
+func (b *kafkaBroker) connect() error {
+	conn, err := b.dial(b.addr)
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+	if b.sasl != nil {
+		if err := b.authenticate(); err != nil {
+			b.conn.Close()
+			b.conn = nil
+			return fmt.Errorf("SASL authentication with %s failed: %s", b.addr, err.Error())
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a framed request and reads the response with the same correlation ID
+func (b *kafkaBroker) roundTrip(apiKey, version int16, body []byte) ([]byte, error) {
+	b.correlation++
+	var e kafkaEncoder
+	e.int32(0) // size, filled in below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(b.correlation)
+	e.string(kafkaClientID)
+	e.Write(body)
+	req := e.Bytes()
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	b.conn.SetDeadline(time.Now().Add(kafkaRequestTimeout))
+	if _, err := b.conn.Write(req); err != nil {
+		return nil, err
+	}
+	var size int32
+	if err := binary.Read(b.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	} else if size < 4 || size > 64*1024*1024 {
+		return nil, fmt.Errorf("Invalid Kafka response size %d from %s", size, b.addr)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != b.correlation {
+		return nil, fmt.Errorf("Kafka response from %s has correlation ID %d, expected %d", b.addr, id, b.correlation)
+	}
+	return resp[4:], nil
+}
+
+// authenticate runs the SASL handshake and exchange on a new connection
+func (b *kafkaBroker) authenticate() error {
+	var e kafkaEncoder
+	e.string(b.sasl.mechanism)
+	resp, err := b.roundTrip(kafkaSaslHandshake, 1, e.Bytes())
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{b: resp}
+	if code := d.int16(); code != 0 {
+		return kafkaError(code)
+	} else if d.err != nil {
+		return d.err
+	}
+
+	if b.sasl.mechanism == "PLAIN" {
+		_, err := b.saslAuthenticate([]byte("\x00" + b.sasl.username + "\x00" + b.sasl.password))
+		return err
+	}
+	return b.scram()
+}
+
+// saslAuthenticate sends one SASL message and returns the server's reply
+func (b *kafkaBroker) saslAuthenticate(msg []byte) ([]byte, error) {
+	var e kafkaEncoder
+	e.bytes(msg)
+	resp, err := b.roundTrip(kafkaSaslAuthenticate, 0, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	d := &kafkaDecoder{b: resp}
+	code := d.int16()
+	message := d.nullableString()
+	reply := d.bytes()
+	if d.err != nil {
+		return nil, d.err
+	} else if code != 0 {
+		return nil, fmt.Errorf("%s: %s", kafkaError(code).Error(), message)
+	}
+	return reply, nil
+}
+
+// scram authenticates with SCRAM-SHA-256 or SCRAM-SHA-512 (RFC 5802)
+func (b *kafkaBroker) scram() error {
+	h := sha256.New
+	if b.sasl.mechanism == "SCRAM-SHA-512" {
+		h = sha512.New
+	}
+	nonce := make([]byte, 24)
+	rand.Read(nonce)
+	clientNonce := base64.RawStdEncoding.EncodeToString(nonce)
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(b.sasl.username)
+	clientFirst := "n=" + user + ",r=" + clientNonce
+
+	serverFirst, err := b.saslAuthenticate([]byte("n,," + clientFirst))
+	if err != nil {
+		return err
+	}
+	attrs := scramAttributes(string(serverFirst))
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return fmt.Errorf("Invalid SCRAM salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return fmt.Errorf("Invalid SCRAM iteration count")
+	} else if !strings.HasPrefix(attrs["r"], clientNonce) {
+		return fmt.Errorf("SCRAM server nonce doesn't start with the client nonce")
+	}
+
+	salted := pbkdf2(h, []byte(b.sasl.password), salt, iterations)
+	clientKey := scramHMAC(h, salted, "Client Key")
+	storedKey := h()
+	storedKey.Write(clientKey)
+	clientFinal := "c=biws,r=" + attrs["r"]
+	authMessage := clientFirst + "," + string(serverFirst) + "," + clientFinal
+	proof := scramHMAC(h, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	serverFinal, err := b.saslAuthenticate([]byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof)))
+	if err != nil {
+		return err
+	}
+	serverSignature := scramHMAC(h, scramHMAC(h, salted, "Server Key"), authMessage)
+	if !hmac.Equal([]byte(scramAttributes(string(serverFinal))["v"]), []byte(base64.StdEncoding.EncodeToString(serverSignature))) {
+		return fmt.Errorf("SCRAM server signature doesn't match")
+	}
+	return nil
+}
+
+func scramAttributes(s string) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if len(kv) > 2 && kv[1] == '=' {
+			attrs[kv[:1]] = kv[2:]
+		}
+	}
+	return attrs
+}
+
+func scramHMAC(h func() hash.Hash, key []byte, msg string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// pbkdf2 derives a key the length of one hash (RFC 2898), as SCRAM needs
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+// kafkaRecord is one message to produce
+type kafkaRecord struct {
+	key       []byte // nil for none
+	value     []byte
+	timestamp int64 // milliseconds
+}
+
+// encodeRecordBatch encodes records as a v2 record batch, optionally gzip-compressed
+func encodeRecordBatch(records []*kafkaRecord, compress bool) ([]byte, error) {
+	base, max := records[0].timestamp, records[0].timestamp
+	for _, r := range records {
+		if r.timestamp < base {
+			base = r.timestamp
+		}
+		if r.timestamp > max {
+			max = r.timestamp
+		}
+	}
+
+	var recs bytes.Buffer
+	for n, r := range records {
+		var rec kafkaEncoder
+		rec.int8(0) // attributes
+		rec.varint(r.timestamp - base)
+		rec.varint(int64(n))
+		if r.key == nil {
+			rec.varint(-1)
+		} else {
+			rec.varint(int64(len(r.key)))
+			rec.Write(r.key)
+		}
+		rec.varint(int64(len(r.value)))
+		rec.Write(r.value)
+		rec.varint(0) // headers
+		var length kafkaEncoder
+		length.varint(int64(rec.Len()))
+		recs.Write(length.Bytes())
+		recs.Write(rec.Bytes())
+	}
+
+	attributes := int16(0)
+	if compress {
+		attributes = 1 // gzip
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(recs.Bytes())
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		recs = gz
+	}
+
+	var body kafkaEncoder // everything the CRC covers
+	body.int16(attributes)
+	body.int32(int32(len(records) - 1)) // last offset delta
+	body.int64(base)
+	body.int64(max)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(records)))
+	body.Write(recs.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset, assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), crc32.MakeTable(crc32.Castagnoli))))
+	batch.Write(body.Bytes())
+	return batch.Bytes(), nil
+}
+
+// murmur2 is the hash of Kafka's default partitioner, so events land on the same partition as
+// they would from any other producer using the same key
+func murmur2(data []byte) int32 {
+	const m, r = uint32(0x5bd1e995), 24
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaEncoder writes big-endian protocol fields
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.WriteByte(byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// varint is a zigzag-encoded variable length integer, as used inside record batches
+func (e *kafkaEncoder) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.Write(b[:binary.PutVarint(b, v)])
+}
+
+// kafkaDecoder reads big-endian protocol fields, remembering the first error
+type kafkaDecoder struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	} else if n < 0 || d.off+n > len(d.b) {
+		d.err = fmt.Errorf("Truncated Kafka response")
+		return nil
+	}
+	d.off += n
+	return d.b[d.off-n : d.off]
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// count is an array length, guarding against lengths the response can't hold
+func (d *kafkaDecoder) count() int {
+	n := int(d.int32())
+	if n > len(d.b)-d.off {
+		d.err = fmt.Errorf("Truncated Kafka response")
+	}
+	if d.err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
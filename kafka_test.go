@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	xpbkdf2 "golang.org/x/crypto/pbkdf2"
+)
+
+const kafkaStandInPartitions = 3
+
+// kafkaStandInRecord is a record a stand-in broker accepted
+type kafkaStandInRecord struct {
+	topic      string
+	partition  int32
+	key        []byte // nil for none
+	value      string
+	timestamp  int64
+	compressed bool
+}
+
+// kafkaStandIn is a cluster of brokers on loopback listeners which speaks just enough of the
+// protocol for the producer: Metadata v4, Produce v3 and SASL PLAIN and SCRAM.  Every topic has
+// kafkaStandInPartitions partitions, led by broker partition % brokers.  Record batches are
+// checked and decoded as they arrive.
+type kafkaStandIn struct {
+	t         *testing.T
+	listeners []net.Listener
+	sasl      *kafkaSASL // credentials required, if any
+
+	lock          sync.Mutex
+	records       []kafkaStandInRecord
+	notLeaderOnce map[int32]bool // partitions which fail their first produce with NOT_LEADER_OR_FOLLOWER
+	authenticated int
+}
+
+func newKafkaStandIn(t *testing.T, brokers int, sasl *kafkaSASL) *kafkaStandIn {
+	k := &kafkaStandIn{t: t, sasl: sasl, notLeaderOnce: map[int32]bool{}}
+	for id := 0; id < brokers; id++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.listeners = append(k.listeners, l)
+		go k.serve(int32(id), l)
+	}
+	return k
+}
+
+func (k *kafkaStandIn) close() {
+	for _, l := range k.listeners {
+		l.Close()
+	}
+}
+
+// dial connects to the listener of an advertised broker name, such as kafka-0.test:9092
+func (k *kafkaStandIn) dial(addr string) (net.Conn, error) {
+	var id int
+	if _, err := fmt.Sscanf(addr, "kafka-%d.test:9092", &id); err != nil || id >= len(k.listeners) {
+		return nil, fmt.Errorf("no stand-in broker %s", addr)
+	}
+	return net.Dial("tcp", k.listeners[id].Addr().String())
+}
+
+func (k *kafkaStandIn) serve(id int32, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(id, conn)
+	}
+}
+
+// handle answers the requests of one connection, refusing any before SASL authentication
+func (k *kafkaStandIn) handle(id int32, conn net.Conn) {
+	defer conn.Close()
+	exchange := &saslExchange{}
+	authenticated := k.sasl == nil
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &kafkaDecoder{b: req}
+		apiKey, version, correlation := d.int16(), d.int16(), d.int32()
+		if clientID := d.string(); clientID != kafkaClientID {
+			k.t.Errorf("client ID %q", clientID)
+		}
+
+		var resp kafkaEncoder
+		resp.int32(correlation)
+		switch {
+		case apiKey == kafkaSaslHandshake:
+			if version != 1 {
+				k.t.Errorf("SaslHandshake v%d, want v1", version)
+			}
+			if k.sasl == nil || d.string() != k.sasl.mechanism {
+				resp.int16(33) // UNSUPPORTED_SASL_MECHANISM
+				resp.int32(0)
+			} else {
+				resp.int16(0)
+				resp.int32(1)
+				resp.string(k.sasl.mechanism)
+			}
+		case apiKey == kafkaSaslAuthenticate:
+			reply, ok := k.authenticate(exchange, d.bytes())
+			if !ok {
+				resp.int16(58) // SASL_AUTHENTICATION_FAILED
+				resp.string("Authentication failed")
+				resp.bytes(nil)
+			} else {
+				resp.int16(0)
+				resp.int16(-1)
+				resp.bytes(reply)
+			}
+			authenticated = ok && exchange.done
+		case !authenticated:
+			k.t.Errorf("API %d requested before SASL authentication", apiKey)
+			return
+		case apiKey == kafkaMetadata:
+			if version != 4 {
+				k.t.Errorf("Metadata v%d, want v4", version)
+			}
+			k.metadata(d, &resp)
+		case apiKey == kafkaProduce:
+			if version != 3 {
+				k.t.Errorf("Produce v%d, want v3", version)
+			}
+			k.produce(id, d, &resp)
+		default:
+			k.t.Errorf("unexpected API %d", apiKey)
+			return
+		}
+		if d.err != nil {
+			k.t.Errorf("malformed request for API %d: %s", apiKey, d.err)
+			return
+		}
+		binary.Write(conn, binary.BigEndian, int32(resp.Len()))
+		conn.Write(resp.Bytes())
+	}
+}
+
+// saslExchange is the server side of the SASL exchange of a connection
+type saslExchange struct {
+	clientFirstBare string
+	serverFirst     string
+	salted          []byte
+	done            bool
+}
+
+// authenticate checks one SASL message, returning the reply and false if authentication failed
+func (k *kafkaStandIn) authenticate(s *saslExchange, msg []byte) ([]byte, bool) {
+	if k.sasl == nil {
+		return nil, false
+	} else if k.sasl.mechanism == "PLAIN" {
+		parts := strings.Split(string(msg), "\x00")
+		if len(parts) != 3 || parts[1] != k.sasl.username || parts[2] != k.sasl.password {
+			return nil, false
+		}
+		s.done = true
+		k.lock.Lock()
+		k.authenticated++
+		k.lock.Unlock()
+		return nil, true
+	}
+
+	h, size := sha256.New, sha256.Size
+	if k.sasl.mechanism == "SCRAM-SHA-512" {
+		h, size = sha512.New, sha512.Size
+	}
+	mac := func(key []byte, msg string) []byte {
+		m := hmac.New(h, key)
+		m.Write([]byte(msg))
+		return m.Sum(nil)
+	}
+	if len(s.serverFirst) < 1 {
+		if !strings.HasPrefix(string(msg), "n,,") {
+			k.t.Errorf("SCRAM client-first message %q has no GS2 header", msg)
+			return nil, false
+		}
+		s.clientFirstBare = string(msg[3:])
+		attrs := scramAttributes(s.clientFirstBare)
+		if attrs["n"] != k.sasl.username {
+			return nil, false
+		}
+		salt := []byte("stand-in salt")
+		s.salted = xpbkdf2.Key([]byte(k.sasl.password), salt, 4096, size, h)
+		s.serverFirst = "r=" + attrs["r"] + "serverNonce,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+		return []byte(s.serverFirst), true
+	}
+
+	i := strings.Index(string(msg), ",p=")
+	if i < 0 {
+		return nil, false
+	}
+	clientFinal, proof := string(msg[:i]), string(msg[i+3:])
+	if clientFinal != "c=biws,r="+scramAttributes(s.serverFirst)["r"] {
+		k.t.Errorf("SCRAM client-final message %q", clientFinal)
+		return nil, false
+	}
+	authMessage := s.clientFirstBare + "," + s.serverFirst + "," + clientFinal
+	clientKey := mac(s.salted, "Client Key")
+	storedKey := h()
+	storedKey.Write(clientKey)
+	signature := mac(storedKey.Sum(nil), authMessage)
+	p, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || len(p) != len(signature) {
+		return nil, false
+	}
+	for j := range p {
+		p[j] ^= signature[j]
+	}
+	if !hmac.Equal(p, clientKey) {
+		return nil, false
+	}
+	s.done = true
+	k.lock.Lock()
+	k.authenticated++
+	k.lock.Unlock()
+	return []byte("v=" + base64.StdEncoding.EncodeToString(mac(mac(s.salted, "Server Key"), authMessage))), true
+}
+
+// metadata advertises every broker as kafka-<id>.test:9092 and the partitions of the topics
+func (k *kafkaStandIn) metadata(d *kafkaDecoder, resp *kafkaEncoder) {
+	topics := []string{}
+	for i, n := 0, d.count(); i < n; i++ {
+		topics = append(topics, d.string())
+	}
+	d.int8() // allow auto topic creation
+
+	resp.int32(0) // throttle time
+	resp.int32(int32(len(k.listeners)))
+	for id := range k.listeners {
+		resp.int32(int32(id))
+		resp.string(fmt.Sprintf("kafka-%d.test", id))
+		resp.int32(9092)
+		resp.int16(-1) // no rack
+	}
+	resp.int16(-1) // no cluster ID
+	resp.int32(0)  // controller
+	resp.int32(int32(len(topics)))
+	for _, topic := range topics {
+		resp.int16(0)
+		resp.string(topic)
+		resp.int8(0)
+		resp.int32(kafkaStandInPartitions)
+		for p := int32(0); p < kafkaStandInPartitions; p++ {
+			leader := p % int32(len(k.listeners))
+			resp.int16(0)
+			resp.int32(p)
+			resp.int32(leader)
+			resp.int32(1) // replicas
+			resp.int32(leader)
+			resp.int32(1) // in-sync replicas
+			resp.int32(leader)
+		}
+	}
+}
+
+// produce accepts the batches of the partitions this broker leads
+func (k *kafkaStandIn) produce(id int32, d *kafkaDecoder, resp *kafkaEncoder) {
+	if d.nullableString() != "" {
+		k.t.Errorf("produce request has a transactional ID")
+	}
+	if acks := d.int16(); acks != -1 {
+		k.t.Errorf("produce request with acks=%d, want all", acks)
+	}
+	d.int32() // timeout
+	nt := d.count()
+	resp.int32(int32(nt))
+	for i := 0; i < nt; i++ {
+		topic := d.string()
+		resp.string(topic)
+		np := d.count()
+		resp.int32(int32(np))
+		for j := 0; j < np; j++ {
+			partition := d.int32()
+			batch := d.bytes()
+			resp.int32(partition)
+			k.lock.Lock()
+			notLeader := k.notLeaderOnce[partition]
+			delete(k.notLeaderOnce, partition)
+			k.lock.Unlock()
+			if partition%int32(len(k.listeners)) != id {
+				k.t.Errorf("partition %d produced to broker %d, which doesn't lead it", partition, id)
+				notLeader = true
+			}
+			if notLeader {
+				resp.int16(6) // NOT_LEADER_OR_FOLLOWER
+			} else {
+				k.decodeBatch(topic, partition, batch)
+				resp.int16(0)
+			}
+			resp.int64(0)  // base offset
+			resp.int64(-1) // log append time
+		}
+	}
+	resp.int32(0) // throttle time
+}
+
+// decodeBatch checks a v2 record batch, including its CRC, and keeps its records
+func (k *kafkaStandIn) decodeBatch(topic string, partition int32, b []byte) {
+	d := &kafkaDecoder{b: b}
+	if offset := d.int64(); offset != 0 {
+		k.t.Errorf("batch base offset %d", offset)
+	}
+	if length := d.int32(); int(length) != len(b)-12 {
+		k.t.Errorf("batch length %d, want %d", length, len(b)-12)
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != 2 {
+		k.t.Errorf("record batch magic %d, want 2", magic)
+	}
+	crc := uint32(d.int32())
+	if want := crc32.Checksum(b[d.off:], crc32.MakeTable(crc32.Castagnoli)); crc != want {
+		k.t.Errorf("record batch CRC %08x, want CRC-32C %08x", crc, want)
+	}
+	attributes := d.int16()
+	lastOffsetDelta := d.int32()
+	firstTimestamp := d.int64()
+	maxTimestamp := d.int64()
+	if producerID, epoch, sequence := d.int64(), d.int16(), d.int32(); producerID != -1 || epoch != -1 || sequence != -1 {
+		k.t.Errorf("idempotence fields %d %d %d, want -1", producerID, epoch, sequence)
+	}
+	count := d.int32()
+	if d.err != nil {
+		k.t.Errorf("truncated record batch: %s", d.err)
+		return
+	} else if lastOffsetDelta != count-1 {
+		k.t.Errorf("last offset delta %d for %d records", lastOffsetDelta, count)
+	}
+
+	records := b[d.off:]
+	compressed := attributes&7 == 1
+	if compressed {
+		gz, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			k.t.Errorf("gzip batch: %s", err)
+			return
+		}
+		if records, err = ioutil.ReadAll(gz); err != nil {
+			k.t.Errorf("gzip batch: %s", err)
+			return
+		}
+	} else if attributes != 0 {
+		k.t.Errorf("batch attributes %d", attributes)
+	}
+
+	r := &kafkaDecoder{b: records}
+	for n := int32(0); n < count; n++ {
+		length := varint(r)
+		start := r.off
+		r.int8() // attributes
+		timestamp := firstTimestamp + varint(r)
+		if delta := varint(r); delta != int64(n) {
+			k.t.Errorf("record %d has offset delta %d", n, delta)
+		}
+		var key []byte
+		if kl := varint(r); kl >= 0 {
+			key = r.next(int(kl))
+		}
+		value := string(r.next(int(varint(r))))
+		if headers := varint(r); headers != 0 {
+			k.t.Errorf("record has %d headers", headers)
+		}
+		if r.err != nil || int64(r.off-start) != length {
+			k.t.Errorf("record %d length %d, read %d: %v", n, length, r.off-start, r.err)
+			return
+		}
+		if timestamp > maxTimestamp {
+			k.t.Errorf("record timestamp %d after the batch's max timestamp %d", timestamp, maxTimestamp)
+		}
+		k.lock.Lock()
+		k.records = append(k.records, kafkaStandInRecord{topic, partition, key, value, timestamp, compressed})
+		k.lock.Unlock()
+	}
+	if r.off != len(records) {
+		k.t.Errorf("%d bytes after the last record", len(records)-r.off)
+	}
+}
+
+// varint reads a zigzag-encoded varint of a record
+func varint(d *kafkaDecoder) int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b[d.off:])
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func testKafkaOutput(t *testing.T, k *kafkaStandIn, topic string, compress bool, batchBytes int, sasl *kafkaSASL) *kafkaOutput {
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kafkaOutput{
+		c:          &config{},
+		bootstrap:  []string{"kafka-0.test:9092"},
+		topic:      tmpl,
+		compress:   compress,
+		batchBytes: batchBytes,
+		dial:       k.dial,
+		sasl:       sasl,
+		brokers:    map[int32]*kafkaBroker{},
+		leaders:    map[string][]int32{},
+		pending:    make(chan struct{}, 1),
+	}
+}
+
+func kafkaEvent(id, account, source string) *outputEvent {
+	return &outputEvent{
+		Record: &cloudtrailRecord{EventID: id, RecipientAccountId: account, EventSource: source, EventTime: "2024-01-02T03:04:05Z"},
+		Doc:    []byte(`{"EventID":"` + id + `"}`),
+	}
+}
+
+// TestMurmur2 checks the hash against the values of Kafka's own tests
+func TestMurmur2(t *testing.T) {
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for s, want := range tests {
+		if got := murmur2([]byte(s)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestKafkaProduce(t *testing.T) {
+	k := newKafkaStandIn(t, 2, nil)
+	defer k.close()
+	k.lock.Lock()
+	k.notLeaderOnce[1] = true // a leader moving is retried
+	k.lock.Unlock()
+
+	accounts := []string{"111111111111", "222222222222", "333333333333", ""}
+	events := []*outputEvent{}
+	for n := 0; n < 40; n++ {
+		source := []string{"s3.amazonaws.com", "iam.amazonaws.com"}[n%2]
+		events = append(events, kafkaEvent(fmt.Sprintf("event-%02d", n), accounts[n%4], source))
+	}
+	o := testKafkaOutput(t, k, "cloudtrail.{{.EventSource}}", true, 200, nil)
+	if err := o.sendAll(events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.records) != len(events) {
+		t.Fatalf("%d records produced, want %d", len(k.records), len(events))
+	}
+	seen := map[string]bool{}
+	for _, r := range k.records {
+		seen[r.value] = true
+		if !r.compressed {
+			t.Errorf("record %s not gzip compressed", r.value)
+		}
+		if r.topic != "cloudtrail.s3.amazonaws.com" && r.topic != "cloudtrail.iam.amazonaws.com" {
+			t.Errorf("record %s produced to topic %s", r.value, r.topic)
+		}
+		if r.timestamp != 1704164645000 {
+			t.Errorf("record %s timestamp %d, want the event time", r.value, r.timestamp)
+		}
+		if r.key == nil {
+			continue
+		}
+		want := (murmur2(r.key) & 0x7fffffff) % kafkaStandInPartitions
+		if r.partition != want {
+			t.Errorf("account %s on partition %d, want %d", r.key, r.partition, want)
+		}
+	}
+	if len(seen) != len(events) {
+		t.Errorf("%d distinct records, want %d", len(seen), len(events))
+	}
+	for _, e := range events {
+		if !seen[string(e.Doc)] {
+			t.Errorf("%s not produced", e.Doc)
+		}
+	}
+}
+
+func TestKafkaSASL(t *testing.T) {
+	for _, mechanism := range []string{"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"} {
+		sasl := &kafkaSASL{mechanism, "alice", "pencil,=secret"}
+		k := newKafkaStandIn(t, 1, sasl)
+		o := testKafkaOutput(t, k, "cloudtrail", false, defaultKafkaBatchBytes, sasl)
+		if err := o.sendAll([]*outputEvent{kafkaEvent("event-1", "111111111111", "s3.amazonaws.com")}); err != nil {
+			t.Errorf("%s: %s", mechanism, err)
+		} else if len(k.records) != 1 || k.records[0].compressed || string(k.records[0].key) != "111111111111" {
+			t.Errorf("%s: produced %+v", mechanism, k.records)
+		}
+		if k.authenticated < 1 {
+			t.Errorf("%s: never authenticated", mechanism)
+		}
+
+		wrong := &kafkaBroker{addr: "kafka-0.test:9092", dial: k.dial, sasl: &kafkaSASL{mechanism, "alice", "wrong"}}
+		if _, err := wrong.request(kafkaMetadata, kafkaMetadataVersion, []byte{0, 0, 0, 0, 1}); err == nil {
+			t.Errorf("%s: wrong password accepted", mechanism)
+		}
+		k.close()
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultKafkaBatchBytes = 1000000 // the default max.message.bytes of a topic
+	kafkaAttempts          = 5
+	kafkaRetryDelay        = 1 * time.Second
+	kafkaDialTimeout       = 10 * time.Second
+)
+
+// kafkaOutput produces events to Kafka topics, keyed by account ID so each account's events
+// stay in order on one partition.  A file's events are acknowledged once every in-sync replica
+// has them (acks=all), so delivery is at-least-once: a file which fails is sent again when its
+// SQS message reappears.
+type kafkaOutput struct {
+	c          *config
+	bootstrap  []string
+	topic      *template.Template
+	compress   bool
+	batchBytes int
+	dial       func(addr string) (net.Conn, error) // replaced to test against a stand-in broker
+	sasl       *kafkaSASL
+	lock       sync.Mutex
+	brokers    map[int32]*kafkaBroker // by node ID, from metadata
+	seeds      []*kafkaBroker         // bootstrap brokers
+	leaders    map[string][]int32     // partition leaders of each topic, -1 when unavailable
+	pending    chan struct{}          // limits files being sent at once
+}
+
+// kafkaPartition is a partition of a topic
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+func newKafkaOutputFromEnv(c *config) (*kafkaOutput, error) {
+	o := &kafkaOutput{
+		c:          c,
+		batchBytes: defaultKafkaBatchBytes,
+		brokers:    map[int32]*kafkaBroker{},
+		leaders:    map[string][]int32{},
+		pending:    make(chan struct{}, maxPendingFiles),
+	}
+	for _, addr := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			o.bootstrap = append(o.bootstrap, addr)
+		}
+	}
+	if len(o.bootstrap) < 1 {
+		return nil, fmt.Errorf("Must set KAFKA_BROKERS for the kafka output.")
+	}
+
+	topic := os.Getenv("KAFKA_TOPIC")
+	if len(topic) < 1 {
+		topic = "cloudtrail"
+	}
+	var err error
+	if o.topic, err = template.New("topic").Option("missingkey=error").Parse(topic); err != nil {
+		return nil, fmt.Errorf("Invalid KAFKA_TOPIC template: %s", err.Error())
+	}
+
+	switch os.Getenv("KAFKA_COMPRESSION") {
+	case "", "none":
+	case "gzip":
+		o.compress = true
+	default:
+		return nil, fmt.Errorf("Invalid KAFKA_COMPRESSION.  Must be 'none' or 'gzip'.")
+	}
+	if len(os.Getenv("KAFKA_BATCH_BYTES")) > 0 {
+		if o.batchBytes, err = strconv.Atoi(os.Getenv("KAFKA_BATCH_BYTES")); err != nil || o.batchBytes < 1 {
+			return nil, fmt.Errorf("Invalid KAFKA_BATCH_BYTES.  Must be a positive number of bytes.")
+		}
+	}
+
+	switch mechanism := os.Getenv("KAFKA_SASL_MECHANISM"); mechanism {
+	case "":
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		o.sasl = &kafkaSASL{mechanism: mechanism, username: os.Getenv("KAFKA_SASL_USERNAME"), password: os.Getenv("KAFKA_SASL_PASSWORD")}
+		if len(o.sasl.username) < 1 {
+			return nil, fmt.Errorf("Must set KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD with KAFKA_SASL_MECHANISM.")
+		}
+	default:
+		return nil, fmt.Errorf("Invalid KAFKA_SASL_MECHANISM.  Must be 'PLAIN', 'SCRAM-SHA-256' or 'SCRAM-SHA-512'.")
+	}
+
+	dialer := &net.Dialer{Timeout: kafkaDialTimeout}
+	o.dial = func(addr string) (net.Conn, error) { return dialer.Dial("tcp", addr) }
+	if len(os.Getenv("KAFKA_TLS")) > 0 || len(os.Getenv("KAFKA_CA_FILE")) > 0 || len(os.Getenv("KAFKA_CLIENT_CERT")) > 0 {
+		tlsConfig := &tls.Config{}
+		if len(os.Getenv("KAFKA_CA_FILE")) > 0 {
+			pem, err := ioutil.ReadFile(os.Getenv("KAFKA_CA_FILE"))
+			if err != nil {
+				return nil, fmt.Errorf("Error reading KAFKA_CA_FILE: %s", err.Error())
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("No certificates found in KAFKA_CA_FILE %s", os.Getenv("KAFKA_CA_FILE"))
+			}
+		}
+		if len(os.Getenv("KAFKA_CLIENT_CERT")) > 0 {
+			cert, err := tls.LoadX509KeyPair(os.Getenv("KAFKA_CLIENT_CERT"), os.Getenv("KAFKA_CLIENT_KEY"))
+			if err != nil {
+				return nil, fmt.Errorf("Error loading KAFKA_CLIENT_CERT: %s", err.Error())
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		o.dial = func(addr string) (net.Conn, error) { return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig) }
+	}
+	return o, nil
+}
+
+func (o *kafkaOutput) send(events []*outputEvent, done func(error)) {
+	o.pending <- struct{}{}
+	go func() {
+		err := o.sendAll(events)
+		<-o.pending
+		if err != nil {
+			err = fmt.Errorf("Error sending to Kafka: %s", err.Error())
+		}
+		done(err)
+	}()
+}
+
+// sendAll produces the events of one file, retrying partitions whose leader moved or was
+// unavailable.  Each partition's batches are sent in order, one at a time.
+func (o *kafkaOutput) sendAll(events []*outputEvent) error {
+	byTopic := map[string][]*outputEvent{}
+	topics := []string{}
+	for _, e := range events {
+		var b bytes.Buffer
+		if err := o.topic.Execute(&b, e.Record); err != nil {
+			return fmt.Errorf("Error rendering KAFKA_TOPIC: %s", err.Error())
+		}
+		topic := kafkaTopicName(b.String())
+		if _, ok := byTopic[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		byTopic[topic] = append(byTopic[topic], e)
+	}
+
+	var queue map[kafkaPartition][][]byte
+	delay := kafkaRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := o.refreshMetadata(topics, attempt > 1)
+		if err == nil && queue == nil {
+			queue, err = o.batches(byTopic)
+		}
+		if err == nil {
+			err = o.produce(queue)
+		}
+		if err == nil {
+			return nil
+		} else if attempt >= kafkaAttempts {
+			return err
+		} else if code, ok := err.(kafkaError); ok && !kafkaRetriable[int16(code)] {
+			return err
+		}
+		log.Printf("Kafka produce failed, retrying in %s: %s", delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// kafkaTopicName replaces characters which aren't allowed in topic names
+func kafkaTopicName(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-' {
+			return c
+		}
+		return '_'
+	}, s)
+	if len(s) > 249 {
+		s = s[:249]
+	}
+	return s
+}
+
+// batches partitions events by account ID and encodes them as record batches of up to
+// KAFKA_BATCH_BYTES
+func (o *kafkaOutput) batches(byTopic map[string][]*outputEvent) (map[kafkaPartition][][]byte, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	records := map[kafkaPartition][]*kafkaRecord{}
+	for topic, events := range byTopic {
+		partitions := len(o.leaders[topic])
+		if partitions < 1 {
+			return nil, kafkaError(3) // UNKNOWN_TOPIC_OR_PARTITION
+		}
+		for _, e := range events {
+			r := &kafkaRecord{value: e.Doc, timestamp: e.Record.eventTime().UnixNano() / int64(time.Millisecond)}
+			hashed := []byte(e.Record.EventID) // spread events without an account evenly
+			if len(e.Record.RecipientAccountId) > 0 {
+				r.key = []byte(e.Record.RecipientAccountId)
+				hashed = r.key
+			}
+			tp := kafkaPartition{topic, (murmur2(hashed) & 0x7fffffff) % int32(partitions)}
+			records[tp] = append(records[tp], r)
+		}
+	}
+
+	queue := map[kafkaPartition][][]byte{}
+	for tp, recs := range records {
+		start, size := 0, 0
+		for n, r := range recs {
+			size += len(r.key) + len(r.value) + 32
+			if n > start && size > o.batchBytes {
+				batch, err := encodeRecordBatch(recs[start:n], o.compress)
+				if err != nil {
+					return nil, err
+				}
+				queue[tp] = append(queue[tp], batch)
+				start, size = n, len(r.key)+len(r.value)+32
+			}
+		}
+		batch, err := encodeRecordBatch(recs[start:], o.compress)
+		if err != nil {
+			return nil, err
+		}
+		queue[tp] = append(queue[tp], batch)
+	}
+	return queue, nil
+}
+
+// produce sends the first batch of every partition, grouped into one request per leader, until
+// all batches are sent.  Sent batches are removed from the queue, so a retry resumes where a
+// failure left off.
+func (o *kafkaOutput) produce(queue map[kafkaPartition][][]byte) error {
+	for len(queue) > 0 {
+		byLeader := map[int32]map[string]map[int32][]byte{}
+		o.lock.Lock()
+		for tp, batches := range queue {
+			leaders := o.leaders[tp.topic]
+			if int(tp.partition) >= len(leaders) || leaders[tp.partition] < 0 {
+				o.lock.Unlock()
+				return kafkaError(5) // LEADER_NOT_AVAILABLE
+			}
+			leader := leaders[tp.partition]
+			if byLeader[leader] == nil {
+				byLeader[leader] = map[string]map[int32][]byte{}
+			}
+			if byLeader[leader][tp.topic] == nil {
+				byLeader[leader][tp.topic] = map[int32][]byte{}
+			}
+			byLeader[leader][tp.topic][tp.partition] = batches[0]
+		}
+		o.lock.Unlock()
+
+		for leader, topics := range byLeader {
+			done, err := o.produceTo(leader, topics)
+			for _, tp := range done {
+				if queue[tp] = queue[tp][1:]; len(queue[tp]) < 1 {
+					delete(queue, tp)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// produceTo sends one produce request and returns the partitions which succeeded
+func (o *kafkaOutput) produceTo(leader int32, topics map[string]map[int32][]byte) ([]kafkaPartition, error) {
+	o.lock.Lock()
+	broker := o.brokers[leader]
+	o.lock.Unlock()
+	if broker == nil {
+		return nil, kafkaError(5)
+	}
+
+	var e kafkaEncoder
+	e.int16(-1) // no transactional ID
+	e.int16(-1) // acks from all in-sync replicas
+	e.int32(int32(kafkaRequestTimeout / time.Millisecond))
+	e.int32(int32(len(topics)))
+	for topic, partitions := range topics {
+		e.string(topic)
+		e.int32(int32(len(partitions)))
+		for partition, batch := range partitions {
+			e.int32(partition)
+			e.bytes(batch)
+		}
+	}
+	resp, err := broker.request(kafkaProduce, kafkaProduceVersion, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	done := []kafkaPartition{}
+	var first error
+	for i, nt := 0, d.count(); i < nt; i++ {
+		topic := d.string()
+		for j, np := 0, d.count(); j < np; j++ {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err == nil && code != 0 && first == nil {
+				first = kafkaError(code)
+			} else if d.err == nil && code == 0 {
+				done = append(done, kafkaPartition{topic, partition})
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	sent := 0
+	for _, partitions := range topics {
+		sent += len(partitions)
+	}
+	if first == nil && len(done) < sent {
+		first = fmt.Errorf("Kafka broker %s acknowledged %d of %d partitions", broker.addr, len(done), sent)
+	}
+	return done, first
+}
+
+// refreshMetadata looks up the brokers and partition leaders of topics which aren't known yet,
+// or of all of them when forced
+func (o *kafkaOutput) refreshMetadata(topics []string, force bool) error {
+	o.lock.Lock()
+	missing := []string{}
+	for _, topic := range topics {
+		if _, ok := o.leaders[topic]; force || !ok {
+			missing = append(missing, topic)
+		}
+	}
+	if len(o.seeds) < 1 {
+		for _, addr := range o.bootstrap {
+			o.seeds = append(o.seeds, &kafkaBroker{addr: addr, dial: o.dial, sasl: o.sasl})
+		}
+	}
+	candidates := []*kafkaBroker{}
+	for _, b := range o.brokers {
+		candidates = append(candidates, b)
+	}
+	candidates = append(candidates, o.seeds...)
+	o.lock.Unlock()
+	if len(missing) < 1 {
+		return nil
+	}
+
+	var e kafkaEncoder
+	e.int32(int32(len(missing)))
+	for _, topic := range missing {
+		e.string(topic)
+	}
+	e.int8(1) // let the broker create topics if it allows that
+
+	var err error
+	for _, b := range candidates {
+		var resp []byte
+		if resp, err = b.request(kafkaMetadata, kafkaMetadataVersion, e.Bytes()); err == nil {
+			if err = o.parseMetadata(resp); err == nil {
+				return nil
+			} else if _, ok := err.(kafkaError); ok { // the broker answered, but about a topic
+				return err
+			}
+		}
+		log.Printf("Error fetching Kafka metadata from %s: %s", b.addr, err.Error())
+	}
+	return err
+}
+
+// parseMetadata records the brokers and partition leaders of a metadata response, returning
+// the error of the first topic which has one
+func (o *kafkaOutput) parseMetadata(resp []byte) error {
+	d := &kafkaDecoder{b: resp}
+	d.int32() // throttle time
+	brokers := map[int32]string{}
+	for i, n := 0, d.count(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster ID
+	d.int32()          // controller ID
+	leaders := map[string][]int32{}
+	var topicErr error
+	for i, n := 0, d.count(); i < n; i++ {
+		code := d.int16()
+		topic := d.string()
+		d.int8() // internal
+		partitions := []int32{}
+		for j, np := 0, d.count(); j < np; j++ {
+			d.int16() // partition error, such as a replica being offline
+			id := d.int32()
+			leader := d.int32()
+			for k, nr := 0, d.count(); k < nr; k++ {
+				d.int32() // replicas
+			}
+			for k, ni := 0, d.count(); k < ni; k++ {
+				d.int32() // in-sync replicas
+			}
+			for int(id) >= len(partitions) {
+				partitions = append(partitions, -1)
+			}
+			partitions[id] = leader
+		}
+		if code != 0 {
+			log.Printf("Kafka metadata for topic %s: %s", topic, kafkaError(code).Error())
+			if topicErr == nil {
+				topicErr = kafkaError(code)
+			}
+			continue
+		}
+		leaders[topic] = partitions
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for id, addr := range brokers {
+		if b, ok := o.brokers[id]; !ok || b.addr != addr {
+			o.brokers[id] = &kafkaBroker{addr: addr, dial: o.dial, sasl: o.sasl}
+		}
+	}
+	for topic, partitions := range leaders {
+		o.leaders[topic] = partitions
+	}
+	return topicErr
+}
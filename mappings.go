@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// esTemplateVersion must be bumped whenever the field tables below change
+const esTemplateVersion = 2
+
+// esFields maps dotted field paths to an abstract field type, rendered by esFieldMapping
+type esFields map[string]string
+
+// esEventFields are the CloudTrail API event fields in the "cloudtrail" format
+var esEventFields = esFields{
+	"EventTime":                "date",
+	"EventName":                "keyword",
+	"EventSource":              "keyword",
+	"EventID":                  "keyword",
+	"EventType":                "keyword",
+	"EventVersion":             "keyword",
+	"EventCategory":            "keyword",
+	"AwsRegion":                "keyword",
+	"RequestID":                "keyword",
+	"RecipientAccountId":       "keyword",
+	"SharedEventID":            "keyword",
+	"SourceIPAddress":          "ip",
+	"UserAgent":                "keyword",
+	"ErrorCode":                "keyword",
+	"ErrorMessage":             "text",
+	"ReadOnly":                 "boolean",
+	"UserIdentity.type":        "keyword",
+	"UserIdentity.principalId": "keyword",
+	"UserIdentity.arn":         "keyword",
+	"UserIdentity.accountId":   "keyword",
+	"UserIdentity.accessKeyId": "keyword",
+	"UserIdentity.userName":    "keyword",
+	"UserIdentity.invokedBy":   "keyword",
+	"UserIdentity.sessionContext.attributes.creationDate":     "date",
+	"UserIdentity.sessionContext.attributes.mfaAuthenticated": "keyword",
+	"UserIdentity.sessionContext.sessionIssuer.type":          "keyword",
+	"UserIdentity.sessionContext.sessionIssuer.principalId":   "keyword",
+	"UserIdentity.sessionContext.sessionIssuer.arn":           "keyword",
+	"UserIdentity.sessionContext.sessionIssuer.accountId":     "keyword",
+	"UserIdentity.sessionContext.sessionIssuer.userName":      "keyword",
+	"RequestParameters":           "dynamic-keyword",
+	"Provenance.S3Bucket":         "keyword",
+	"Provenance.S3ObjectKey":      "keyword",
+	"Provenance.S3ETag":           "keyword",
+	"Provenance.S3VersionID":      "keyword",
+	"Provenance.SQSMessageID":     "keyword",
+	"Provenance.IngestTime":       "date",
+	"Provenance.TraildashVersion": "keyword",
+}
+
+// esInsightFields are the CloudTrail Insights event fields in the "cloudtrail" format
+var esInsightFields = esFields{
+	"EventTime":                  "date",
+	"EventID":                    "keyword",
+	"SharedEventID":              "keyword",
+	"EventType":                  "keyword",
+	"EventCategory":              "keyword",
+	"EventVersion":               "keyword",
+	"AwsRegion":                  "keyword",
+	"RecipientAccountId":         "keyword",
+	"InsightDetails.State":       "keyword",
+	"InsightDetails.EventSource": "keyword",
+	"InsightDetails.EventName":   "keyword",
+	"InsightDetails.ErrorCode":   "keyword",
+	"InsightDetails.InsightType": "keyword",
+	"InsightDetails.InsightContext.Statistics.Baseline.Average":   "double",
+	"InsightDetails.InsightContext.Statistics.Insight.Average":    "double",
+	"InsightDetails.InsightContext.Statistics.BaselineDuration":   "long",
+	"InsightDetails.InsightContext.Statistics.InsightDuration":    "long",
+	"InsightDetails.InsightContext.Attributions":                  "nested",
+	"InsightDetails.InsightContext.Attributions.Attribute":        "keyword",
+	"InsightDetails.InsightContext.Attributions.Insight.Value":    "keyword",
+	"InsightDetails.InsightContext.Attributions.Insight.Average":  "double",
+	"InsightDetails.InsightContext.Attributions.Baseline.Value":   "keyword",
+	"InsightDetails.InsightContext.Attributions.Baseline.Average": "double",
+	"Provenance.IngestTime":                                       "date",
+}
+
+// esECSFields are the Elastic Common Schema fields set by ecsDocument
+var esECSFields = esFields{
+	"@timestamp":          "date",
+	"ecs.version":         "keyword",
+	"event.id":            "keyword",
+	"event.kind":          "keyword",
+	"event.module":        "keyword",
+	"event.dataset":       "keyword",
+	"event.provider":      "keyword",
+	"event.action":        "keyword",
+	"event.outcome":       "keyword",
+	"event.ingested":      "date",
+	"cloud.provider":      "keyword",
+	"cloud.region":        "keyword",
+	"cloud.account.id":    "keyword",
+	"source.ip":           "ip",
+	"source.domain":       "keyword",
+	"user_agent.original": "keyword",
+	"user.id":             "keyword",
+	"user.name":           "keyword",
+	"user.domain":         "keyword",
+	"related.ip":          "ip",
+	"related.user":        "keyword",
+	"error.code":          "keyword",
+	"error.message":       "text",
+	"rule.name":           "keyword",
+}
+
+// esOCSFFields are the OCSF attributes set by ocsfDocument
+var esOCSFFields = esFields{
+	"time":                           "date",
+	"category_uid":                   "integer",
+	"category_name":                  "keyword",
+	"class_uid":                      "integer",
+	"class_name":                     "keyword",
+	"activity_id":                    "integer",
+	"activity_name":                  "keyword",
+	"type_uid":                       "long",
+	"type_name":                      "keyword",
+	"severity_id":                    "integer",
+	"severity":                       "keyword",
+	"status_id":                      "integer",
+	"status":                         "keyword",
+	"status_code":                    "keyword",
+	"status_detail":                  "text",
+	"metadata.uid":                   "keyword",
+	"metadata.version":               "keyword",
+	"metadata.correlation_uid":       "keyword",
+	"metadata.product.name":          "keyword",
+	"metadata.product.vendor_name":   "keyword",
+	"metadata.log_version":           "keyword",
+	"cloud.provider":                 "keyword",
+	"cloud.region":                   "keyword",
+	"cloud.account.uid":              "keyword",
+	"src_endpoint.ip":                "ip",
+	"http_request.user_agent":        "keyword",
+	"api.operation":                  "keyword",
+	"api.service.name":               "keyword",
+	"api.request.uid":                "keyword",
+	"dst_endpoint.svc_name":          "keyword",
+	"service.name":                   "keyword",
+	"actor.user.uid":                 "keyword",
+	"actor.user.uid_alt":             "keyword",
+	"actor.user.name":                "keyword",
+	"actor.user.type":                "keyword",
+	"actor.user.account.uid":         "keyword",
+	"actor.user.credential_uid":      "keyword",
+	"actor.invoked_by":               "keyword",
+	"actor.session.issuer":           "keyword",
+	"actor.session.created_time_dt":  "date",
+	"actor.session.is_mfa":           "boolean",
+	"user.uid":                       "keyword",
+	"user.uid_alt":                   "keyword",
+	"user.name":                      "keyword",
+	"user.type":                      "keyword",
+	"is_mfa":                         "boolean",
+	"auth_protocol":                  "keyword",
+	"auth_protocol_id":               "integer",
+	"unmapped.request":               "dynamic-keyword",
+	"unmapped.provenance.IngestTime": "date",
+}
+
+// esTemplateFields picks the field table for an output format and kind of event
+func esTemplateFields(format string, insight bool) esFields {
+	raw := esEventFields
+	if insight {
+		raw = esInsightFields
+	}
+	switch format {
+	case "ecs":
+		return esECSFields.merge("aws.cloudtrail.", raw)
+	case "ocsf":
+		if !insight {
+			return esOCSFFields
+		}
+	}
+	return raw
+}
+
+// merge returns a copy of f with the fields of other added under prefix
+func (f esFields) merge(prefix string, other esFields) esFields {
+	m := esFields{}
+	for k, v := range f {
+		m[k] = v
+	}
+	for k, v := range other {
+		m[prefix+k] = v
+	}
+	return m
+}
+
+// esTypeMapping renders the mapping of the event type for an output format and cluster version
+func esTypeMapping(format string, insight bool, v esVersion) map[string]interface{} {
+	fields := esTemplateFields(format, insight)
+	properties := map[string]interface{}{}
+	dynamic := []interface{}{}
+	paths := make([]string, 0, len(fields))
+	for p := range fields {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths) // parents before children
+	for _, p := range paths {
+		if fields[p] == "dynamic-keyword" {
+			// free-form objects such as request parameters vary in type between events, so
+			// index every leaf as a keyword to avoid mapping conflicts
+			for _, t := range []string{"string", "long", "double", "boolean"} {
+				name := strings.Replace(p, ".", "_", -1) + "_" + t
+				dynamic = append(dynamic, map[string]interface{}{name: map[string]interface{}{
+					"path_match":         p + ".*",
+					"match_mapping_type": t,
+					"mapping":            esFieldMapping("keyword", v),
+				}})
+			}
+			continue
+		}
+		setMappingPath(properties, p, esFieldMapping(fields[p], v))
+	}
+	mapping := map[string]interface{}{
+		"_meta":             map[string]interface{}{"traildash": esTemplateMeta{Version: esTemplateVersion, Format: format}},
+		"date_detection":    false,
+		"dynamic_templates": dynamic,
+		"properties":        properties,
+	}
+	if v.allField() {
+		mapping["_all"] = map[string]interface{}{"enabled": false}
+	}
+	return mapping
+}
+
+// esFieldMapping renders an abstract field type as a mapping for the cluster version
+func esFieldMapping(t string, v esVersion) map[string]interface{} {
+	switch t {
+	case "keyword":
+		if v.keywordTypes() {
+			return map[string]interface{}{"type": "keyword"}
+		}
+		return map[string]interface{}{"type": "string", "index": "not_analyzed"}
+	case "text":
+		if v.keywordTypes() {
+			return map[string]interface{}{"type": "text"}
+		}
+		return map[string]interface{}{"type": "string"}
+	case "ip":
+		// service principals such as "ec2.amazonaws.com" appear where IPs are expected,
+		// so keep a keyword copy and don't reject the event
+		return map[string]interface{}{"type": "ip", "ignore_malformed": true,
+			"fields": map[string]interface{}{"raw": esFieldMapping("keyword", v)}}
+	case "date":
+		if v.Major < 2 && !v.OpenSearch {
+			return map[string]interface{}{"type": "date", "format": "dateOptionalTime"}
+		}
+		return map[string]interface{}{"type": "date", "format": "strict_date_optional_time||epoch_millis"}
+	}
+	return map[string]interface{}{"type": t}
+}
+
+// setMappingPath stores a field mapping at a dotted path inside "properties" objects,
+// keeping any type already set on the parent objects (such as "nested")
+func setMappingPath(properties map[string]interface{}, path string, mapping map[string]interface{}) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		parent, ok := properties[p].(map[string]interface{})
+		if !ok {
+			parent = map[string]interface{}{}
+			properties[p] = parent
+		}
+		child, ok := parent["properties"].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			parent["properties"] = child
+		}
+		properties = child
+	}
+	last := parts[len(parts)-1]
+	if existing, ok := properties[last].(map[string]interface{}); ok {
+		for k, v := range mapping {
+			existing[k] = v
+		}
+		return
+	}
+	properties[last] = mapping
+}
+
+// esTemplateMeta is stored in every mapping so outdated templates and indices can be found
+type esTemplateMeta struct {
+	Version int    `json:"template_version"`
+	Format  string `json:"format"`
+}
+
+// installTemplate installs the template for a daily index alias unless the current
+// version is already there
+func (c *config) installTemplate(alias string, insight bool, aliased bool) error {
+	current, err := c.templateMeta(alias)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Version > esTemplateVersion {
+		log.Printf("ElasticSearch template %s is version %d, newer than this traildash's version %d.  Not replacing it.", alias, current.Version, esTemplateVersion)
+		return nil
+	} else if current != nil && current.Version == esTemplateVersion && current.Format == c.formatName {
+		c.debug("ElasticSearch template %s is up to date (version %d)", alias, current.Version)
+		return nil
+	}
+
+	if err := c.putTemplate(alias, c.esTemplate(alias, insight, aliased)); err != nil {
+		return err
+	}
+	log.Printf("Installed ElasticSearch template %s version %d (%s format, %s)", alias, esTemplateVersion, c.formatName, c.esVersion)
+	return nil
+}
+
+// esTemplate renders a composable or legacy index template in the format of the cluster version
+func (c *config) esTemplate(alias string, insight bool, aliased bool) map[string]interface{} {
+	v := c.esVersion
+	var mappings interface{} = esTypeMapping(c.formatName, insight, v)
+	if !v.typeless() {
+		mappings = map[string]interface{}{esType: mappings}
+	}
+	body := map[string]interface{}{"mappings": mappings}
+	if aliased {
+		body["aliases"] = map[string]interface{}{alias: map[string]interface{}{}}
+	}
+	if v.composableTemplates() {
+		return map[string]interface{}{
+			"index_patterns": []string{alias + "-*"},
+			"priority":       0,
+			"template":       body,
+		}
+	}
+	if v.indexPatterns() {
+		body["index_patterns"] = []string{alias + "-*"}
+	} else {
+		body["template"] = alias + "-*"
+	}
+	body["order"] = 0
+	return body
+}
+
+// templateMeta fetches the traildash metadata of an installed template, or nil if there is none
+func (c *config) templateMeta(name string) (*esTemplateMeta, error) {
+	var mappings json.RawMessage
+	if c.esVersion.composableTemplates() {
+		var templates struct {
+			IndexTemplates []struct {
+				Name          string `json:"name"`
+				IndexTemplate struct {
+					Template struct {
+						Mappings json.RawMessage `json:"mappings"`
+					} `json:"template"`
+				} `json:"index_template"`
+			} `json:"index_templates"`
+		}
+		found, err := c.getJSON(fmt.Sprintf("/_index_template/%s", name), &templates)
+		if err != nil || !found {
+			return nil, err
+		}
+		for _, t := range templates.IndexTemplates {
+			if t.Name == name {
+				mappings = t.IndexTemplate.Template.Mappings
+			}
+		}
+	} else {
+		var templates map[string]struct {
+			Mappings json.RawMessage `json:"mappings"`
+		}
+		found, err := c.getJSON(fmt.Sprintf("/_template/%s", name), &templates)
+		if err != nil || !found {
+			return nil, err
+		}
+		mappings = templates[name].Mappings
+	}
+	if m, ok := c.mappingMeta(mappings); ok && m != nil {
+		return m, nil
+	}
+	return &esTemplateMeta{}, nil // installed by an older traildash or by hand
+}
+
+// mappingMeta finds the traildash metadata in the mappings of a template or index, which are
+// keyed by the mapping type before ES 7.  ok is false if there is no traildash mapping type.
+func (c *config) mappingMeta(mappings json.RawMessage) (meta *esTemplateMeta, ok bool) {
+	type typeMapping struct {
+		Meta struct {
+			Traildash *esTemplateMeta `json:"traildash"`
+		} `json:"_meta"`
+	}
+	if len(mappings) < 1 {
+		return nil, false
+	}
+	if c.esVersion.typeless() {
+		var m typeMapping
+		if err := json.Unmarshal(mappings, &m); err != nil {
+			return nil, false
+		}
+		return m.Meta.Traildash, true
+	}
+	var types map[string]typeMapping
+	if err := json.Unmarshal(mappings, &types); err != nil {
+		return nil, false
+	}
+	m, ok := types[esType]
+	return m.Meta.Traildash, ok
+}
+
+// checkMappings warns about existing indices whose mappings predate the current template
+func (c *config) checkMappings(alias string) error {
+	var indices map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	found, err := c.getJSON(fmt.Sprintf("/%s/_mapping", alias), &indices)
+	if err != nil || !found {
+		return err
+	}
+	outdated := []string{}
+	for index, i := range indices {
+		meta, ok := c.mappingMeta(i.Mappings)
+		if !ok {
+			continue
+		}
+		if meta == nil || meta.Version < esTemplateVersion || meta.Format != c.formatName {
+			outdated = append(outdated, index)
+		}
+	}
+	if len(outdated) > 0 {
+		sort.Strings(outdated)
+		log.Printf("WARNING: %d ElasticSearch indices have out of date mappings (current template version %d, %s format): %s.  New daily indices will use the current mappings; reindex these or let them age out.",
+			len(outdated), esTemplateVersion, c.formatName, strings.Join(outdated, ", "))
+	}
+	return nil
+}
+
+// getJSON fetches and decodes an ElasticSearch API response, reporting false for a 404
+func (c *config) getJSON(path string, v interface{}) (bool, error) {
+	resp, err := c.es.request("GET", path, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return false, nil
+	} else if resp.StatusCode != 200 {
+		return false, fmt.Errorf("Error response from Elasticsearch: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("Error decoding Elasticsearch response: %s", err.Error())
+	}
+	return true, nil
+}
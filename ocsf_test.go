@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadRecord reads a CloudTrail record fixture from testdata
+func loadRecord(t *testing.T, name string) *cloudtrailRecord {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &cloudtrailRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return r
+}
+
+// getPath reads a dotted path from a document
+func getPath(doc map[string]interface{}, path ...string) interface{} {
+	var v interface{} = doc
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[p]
+	}
+	return v
+}
+
+func TestOCSFDocument(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		class    int
+		activity int
+		status   int
+		objects  []string
+	}{
+		{"console_login.json", ocsfClassAuthentication, 1, ocsfStatusSuccess, []string{"metadata", "cloud", "actor", "user", "src_endpoint", "service"}},
+		{"assume_role.json", ocsfClassAPIActivity, 2, ocsfStatusSuccess, []string{"metadata", "cloud", "actor", "api", "src_endpoint"}},
+		{"api_call.json", ocsfClassAPIActivity, 1, ocsfStatusSuccess, []string{"metadata", "cloud", "actor", "api", "src_endpoint"}},
+		{"error_event.json", ocsfClassAPIActivity, 4, ocsfStatusFailure, []string{"metadata", "cloud", "actor", "api", "src_endpoint"}},
+	}
+	for _, tt := range tests {
+		r := loadRecord(t, filepath.Join("ocsf", tt.fixture))
+		doc, ok := ocsfDocument(r).(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: document is not an object", tt.fixture)
+		}
+		if doc["class_uid"] != tt.class {
+			t.Errorf("%s: class_uid %v, want %d", tt.fixture, doc["class_uid"], tt.class)
+		}
+		if doc["activity_id"] != tt.activity {
+			t.Errorf("%s: activity_id %v, want %d", tt.fixture, doc["activity_id"], tt.activity)
+		}
+		if doc["type_uid"] != tt.class*100+tt.activity {
+			t.Errorf("%s: type_uid %v, want %d", tt.fixture, doc["type_uid"], tt.class*100+tt.activity)
+		}
+		if doc["status_id"] != tt.status {
+			t.Errorf("%s: status_id %v, want %d", tt.fixture, doc["status_id"], tt.status)
+		}
+		eventTime, _ := time.Parse(time.RFC3339, r.EventTime)
+		if doc["time"] != eventTime.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("%s: time %v, want milliseconds %d", tt.fixture, doc["time"], eventTime.UnixNano()/int64(time.Millisecond))
+		}
+		for _, field := range []string{"category_uid", "class_name", "activity_name", "type_name", "severity_id"} {
+			if _, ok := doc[field]; !ok {
+				t.Errorf("%s: missing required %s", tt.fixture, field)
+			}
+		}
+		for _, object := range tt.objects {
+			if _, ok := doc[object].(map[string]interface{}); !ok {
+				t.Errorf("%s: missing %s object", tt.fixture, object)
+			}
+		}
+		if v := getPath(doc, "metadata", "version"); v != ocsfVersion {
+			t.Errorf("%s: metadata.version %v, want the OCSF schema version %s", tt.fixture, v, ocsfVersion)
+		}
+		if v := getPath(doc, "metadata", "log_version"); v != r.EventVersion {
+			t.Errorf("%s: metadata.log_version %v, want %s", tt.fixture, v, r.EventVersion)
+		}
+		if v := getPath(doc, "metadata", "product", "version"); v != nil {
+			t.Errorf("%s: metadata.product.version is %v, but CloudTrail has no product version", tt.fixture, v)
+		}
+		if v := getPath(doc, "cloud", "account", "uid"); v != r.RecipientAccountId {
+			t.Errorf("%s: cloud.account.uid %v, want %s", tt.fixture, v, r.RecipientAccountId)
+		}
+	}
+}
+
+func TestOCSFAuthentication(t *testing.T) {
+	doc := ocsfDocument(loadRecord(t, "ocsf/console_login.json")).(map[string]interface{})
+	if v := getPath(doc, "user", "name"); v != "alice" {
+		t.Errorf("user.name %v, want alice", v)
+	}
+	if v := getPath(doc, "service", "name"); v != "signin.amazonaws.com" {
+		t.Errorf("service.name %v, want signin.amazonaws.com", v)
+	}
+	if doc["category_uid"] != 3 {
+		t.Errorf("category_uid %v, want 3", doc["category_uid"])
+	}
+}
+
+func TestOCSFError(t *testing.T) {
+	doc := ocsfDocument(loadRecord(t, "ocsf/error_event.json")).(map[string]interface{})
+	if doc["status_code"] != "Client.UnauthorizedOperation" {
+		t.Errorf("status_code %v", doc["status_code"])
+	}
+	if doc["status_detail"] != "You are not authorized to perform this operation." {
+		t.Errorf("status_detail %v", doc["status_detail"])
+	}
+}
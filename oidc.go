@@ -0,0 +1,650 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcSessionCookie     = "traildash_session"
+	oidcFlowCookie        = "traildash_login"
+	defaultOIDCSessionTTL = 8 * time.Hour
+	oidcFlowTTL           = 10 * time.Minute
+	oidcClockSkew         = 1 * time.Minute
+	oidcKeysRefetch       = 1 * time.Minute
+	oidcMaxCookieBytes    = 3800
+)
+
+// oidcAuth logs people in to the web interface through an OpenID Connect provider with the
+// authorization code flow (with PKCE), then keeps them logged in with a signed session cookie.
+// Requests with basic auth credentials are checked by WEB_AUTH_USER or WEB_AUTH_FILE instead,
+// if set, for scripts.
+type oidcAuth struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	logoutURL     string // where the provider sends people after logging out, if anywhere
+	scopes        string
+	userClaim     string
+	groupsClaim   string
+	allowedGroups map[string]bool // any of these, or anyone when empty
+	secret        []byte          // signs cookies
+	sessionTTL    time.Duration
+	secure        bool // cookies only over HTTPS
+	basic         *webAuth
+	client        *http.Client
+	lock          sync.Mutex
+	provider      *oidcProvider
+	keys          map[string]crypto.PublicKey // by key ID
+	keysFetched   time.Time
+}
+
+// oidcProvider is the provider's discovery document
+type oidcProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcSession is the content of a session cookie
+type oidcSession struct {
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Expires int64    `json:"e"`
+}
+
+// oidcFlow is the state of a login in progress, kept in a cookie until the callback
+type oidcFlow struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Redirect string `json:"r"`
+	Expires  int64  `json:"e"`
+}
+
+func newOIDCAuthFromEnv(c *config) (*oidcAuth, error) {
+	o := &oidcAuth{
+		issuer:        strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		clientID:      os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		logoutURL:     os.Getenv("OIDC_POST_LOGOUT_URL"),
+		scopes:        os.Getenv("OIDC_SCOPES"),
+		userClaim:     os.Getenv("OIDC_USER_CLAIM"),
+		groupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		allowedGroups: map[string]bool{},
+		sessionTTL:    defaultOIDCSessionTTL,
+		basic:         c.auth,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(o.issuer) < 1 {
+		return nil, nil
+	}
+	if len(o.clientID) < 1 || len(o.redirectURL) < 1 {
+		return nil, fmt.Errorf("Must set OIDC_CLIENT_ID and OIDC_REDIRECT_URL with OIDC_ISSUER.")
+	}
+	u, err := url.Parse(o.redirectURL)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("Invalid OIDC_REDIRECT_URL.  Must be the absolute URL of /auth/callback, such as 'https://traildash.example.com/auth/callback'.")
+	}
+	o.secure = u.Scheme == "https"
+	if len(o.scopes) < 1 {
+		o.scopes = "openid email profile"
+	}
+	if len(o.userClaim) < 1 {
+		o.userClaim = "email"
+	}
+	if len(o.groupsClaim) < 1 {
+		o.groupsClaim = "groups"
+	}
+	for _, g := range strings.Split(os.Getenv("OIDC_ALLOWED_GROUPS"), ",") {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			o.allowedGroups[g] = true
+		}
+	}
+	if len(o.allowedGroups) < 1 {
+		log.Printf("WARNING: OIDC_ALLOWED_GROUPS is not set, so anyone who can log in to %s can use traildash.", o.issuer)
+	}
+	if len(os.Getenv("OIDC_SESSION_TTL")) > 0 {
+		if o.sessionTTL, err = time.ParseDuration(os.Getenv("OIDC_SESSION_TTL")); err != nil || o.sessionTTL <= 0 {
+			return nil, fmt.Errorf("Invalid OIDC_SESSION_TTL.  Must be a duration such as '8h'.")
+		}
+	}
+	if secret := os.Getenv("OIDC_SESSION_SECRET"); len(secret) > 0 {
+		key := sha256.Sum256([]byte(secret))
+		o.secret = key[:]
+	} else {
+		o.secret = make([]byte, 32)
+		if _, err := rand.Read(o.secret); err != nil {
+			return nil, err
+		}
+		log.Printf("OIDC_SESSION_SECRET is not set, so web sessions will end when traildash restarts.")
+	}
+	return o, nil
+}
+
+// wrap requires a session for a handler, sending browsers to log in.  Requests from scripts and
+// from the dashboard's own API calls get a 401 instead, as they can't follow a login.
+func (o *oidcAuth) wrap(h http.HandlerFunc) http.HandlerFunc {
+	basic := o.basic.wrap(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok && o.basic != nil {
+			basic(w, r)
+			return
+		}
+		var s oidcSession
+		if cookie, err := r.Cookie(oidcSessionCookie); err == nil && o.verify(oidcSessionCookie, cookie.Value, &s) && time.Now().Unix() < s.Expires {
+			h(w, withIdentity(r, &webIdentity{user: s.User, groups: s.Groups}))
+			return
+		}
+		if r.Method != "GET" || strings.HasPrefix(r.URL.Path, "/es/") || strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/status" {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/auth/login?rd="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	}
+}
+
+// loginHandler starts a login, sending the browser to the provider
+func (o *oidcAuth) loginHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := o.discover()
+	if err != nil {
+		log.Printf("OIDC discovery error: %s", err.Error())
+		http.Error(w, "Error contacting the identity provider", http.StatusBadGateway)
+		return
+	}
+	flow := oidcFlow{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Redirect: localRedirect(r.URL.Query().Get("rd")),
+		Expires:  time.Now().Add(oidcFlowTTL).Unix(),
+	}
+	o.setCookie(w, oidcFlowCookie, o.sign(oidcFlowCookie, flow), oidcFlowTTL)
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.clientID},
+		"redirect_uri":          {o.redirectURL},
+		"scope":                 {o.scopes},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// localRedirect keeps the page to return to after logging in on this site, so login links
+// can't send people elsewhere.  Browsers treat "\" as "/" and drop tabs and newlines, so
+// "/\example.com" and "/\t/example.com" are as bad as "//example.com".
+func localRedirect(rd string) string {
+	unsafe := strings.IndexFunc(rd, func(c rune) bool { return c == '\\' || c < ' ' || c == 0x7f })
+	if !strings.HasPrefix(rd, "/") || strings.HasPrefix(rd, "//") || unsafe >= 0 {
+		return "/"
+	}
+	return rd
+}
+
+// callbackHandler finishes a login: it exchanges the code for an ID token, checks the token and
+// the user's groups, and starts a session
+func (o *oidcAuth) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	var flow oidcFlow
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil || !o.verify(oidcFlowCookie, cookie.Value, &flow) || time.Now().Unix() >= flow.Expires {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	o.setCookie(w, oidcFlowCookie, "", -1)
+	q := r.URL.Query()
+	if len(q.Get("error")) > 0 {
+		log.Printf("OIDC login failed: %s %s", q.Get("error"), q.Get("error_description"))
+		http.Error(w, "Login failed: "+q.Get("error"), http.StatusForbidden)
+		return
+	} else if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(flow.State)) != 1 {
+		http.Error(w, "Login state doesn't match, please try again", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := o.exchange(q.Get("code"), &flow)
+	if err != nil {
+		log.Printf("OIDC login error: %s", err.Error())
+		http.Error(w, "Login failed", http.StatusBadGateway)
+		return
+	}
+	user, _ := claims[o.userClaim].(string)
+	if len(user) < 1 {
+		user, _ = claims["sub"].(string)
+	}
+	if len(user) < 1 {
+		http.Error(w, "Login failed: the ID token doesn't say who you are", http.StatusForbidden)
+		return
+	}
+	groups := oidcGroups(claims[o.groupsClaim])
+	if !o.allowed(groups) {
+		log.Printf("OIDC login refused for %s: not in OIDC_ALLOWED_GROUPS", user)
+		http.Error(w, "You are not in a group allowed to use traildash", http.StatusForbidden)
+		return
+	}
+
+	s := oidcSession{User: user, Groups: groups, Expires: time.Now().Add(o.sessionTTL).Unix()}
+	value := o.sign(oidcSessionCookie, s)
+	if len(value) > oidcMaxCookieBytes { // too many groups for a cookie, so keep only those that matter
+		s.Groups = []string{}
+		for _, g := range groups {
+			if o.allowedGroups[g] {
+				s.Groups = append(s.Groups, g)
+			}
+		}
+		value = o.sign(oidcSessionCookie, s)
+	}
+	o.setCookie(w, oidcSessionCookie, value, o.sessionTTL)
+	log.Printf("OIDC login by %s", user)
+	http.Redirect(w, r, flow.Redirect, http.StatusFound)
+}
+
+// logoutHandler ends the session, and the provider's session too if it supports that
+func (o *oidcAuth) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	o.setCookie(w, oidcSessionCookie, "", -1)
+	if p, err := o.discover(); err == nil && len(p.EndSessionEndpoint) > 0 {
+		q := url.Values{"client_id": {o.clientID}}
+		if len(o.logoutURL) > 0 {
+			q.Set("post_logout_redirect_uri", o.logoutURL)
+		}
+		sep := "?"
+		if strings.Contains(p.EndSessionEndpoint, "?") {
+			sep = "&"
+		}
+		http.Redirect(w, r, p.EndSessionEndpoint+sep+q.Encode(), http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `<html><body><p>You have logged out of traildash.</p><p><a href="/">Log in again</a></p></body></html>`)
+}
+
+// allowed is whether groups include one of OIDC_ALLOWED_GROUPS
+func (o *oidcAuth) allowed(groups []string) bool {
+	if len(o.allowedGroups) < 1 {
+		return true
+	}
+	for _, g := range groups {
+		if o.allowedGroups[g] {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcGroups reads a groups claim, which is a list of names or a single name
+func oidcGroups(v interface{}) []string {
+	groups := []string{}
+	switch v := v.(type) {
+	case string:
+		groups = append(groups, v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	return groups
+}
+
+// exchange redeems an authorization code and returns the claims of the verified ID token,
+// adding claims from the userinfo endpoint when the token has no groups claim
+func (o *oidcAuth) exchange(code string, flow *oidcFlow) (map[string]interface{}, error) {
+	p, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL},
+		"code_verifier": {flow.Verifier},
+	}
+	postSecret := len(p.TokenAuthMethods) > 0
+	for _, m := range p.TokenAuthMethods {
+		if m == "client_secret_basic" {
+			postSecret = false
+		}
+	}
+	if postSecret || len(o.clientSecret) < 1 {
+		form.Set("client_id", o.clientID)
+		if len(o.clientSecret) > 0 {
+			form.Set("client_secret", o.clientSecret)
+		}
+	}
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !postSecret && len(o.clientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := o.getJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("token request: %s", err.Error())
+	} else if len(tokens.Error) > 0 {
+		return nil, fmt.Errorf("token request: %s %s", tokens.Error, tokens.Description)
+	} else if len(tokens.IDToken) < 1 {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := o.verifyIDToken(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(flow.Nonce)) != 1 {
+		return nil, fmt.Errorf("ID token nonce doesn't match")
+	}
+	if _, ok := claims[o.groupsClaim]; !ok && len(p.UserinfoEndpoint) > 0 && len(tokens.AccessToken) > 0 {
+		req, err := http.NewRequest("GET", p.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		info := map[string]interface{}{}
+		if err := o.getJSON(req, &info); err != nil {
+			return nil, fmt.Errorf("userinfo request: %s", err.Error())
+		}
+		if info["sub"] == claims["sub"] { // as the spec requires, so claims can't be mixed up
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+	return claims, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and expiry of an ID token and returns
+// its claims
+func (o *oidcAuth) verifyIDToken(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := jwtDecode(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwtVerify(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := jwtDecode(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.issuer {
+		return nil, fmt.Errorf("ID token issuer %q is not %s", iss, o.issuer)
+	}
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == o.clientID
+	case []interface{}:
+		for _, a := range aud {
+			audience = audience || a == o.clientID
+		}
+	}
+	if !audience {
+		return nil, fmt.Errorf("ID token is not for client %s", o.clientID)
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(iat), 0)) {
+		return nil, fmt.Errorf("ID token was issued in the future")
+	}
+	return claims, nil
+}
+
+// jwtVerify checks a JWS signature made with RSA or ECDSA.  Tokens which are unsigned or
+// signed with a shared secret ("none", "HS256") are refused.
+func jwtVerify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	var h crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	}
+	if h == 0 {
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, h, digest, sig) == nil {
+			return nil
+		} else if strings.HasPrefix(alg, "PS") && rsa.VerifyPSS(k, h, digest, sig, nil) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(sig) == 2*size &&
+			ecdsa.Verify(k, digest, new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid ID token signature")
+}
+
+func jwtDecode(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// discover fetches the provider's configuration once
+func (o *oidcAuth) discover() (*oidcProvider, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	req, err := http.NewRequest("GET", o.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	p := &oidcProvider{}
+	if err := o.getJSON(req, p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("provider says its issuer is %q, not %s", p.Issuer, o.issuer)
+	} else if len(p.AuthorizationEndpoint) < 1 || len(p.TokenEndpoint) < 1 || len(p.JwksURI) < 1 {
+		return nil, fmt.Errorf("provider configuration is missing endpoints")
+	}
+	o.provider = p
+	return p, nil
+}
+
+// key finds a signing key of the provider, fetching its keys again when it has rotated them
+func (o *oidcAuth) key(kid string) (crypto.PublicKey, error) {
+	p, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	} else if time.Since(o.keysFetched) < oidcKeysRefetch {
+		return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+	}
+	o.keysFetched = time.Now()
+
+	req, err := http.NewRequest("GET", p.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %s", err.Error())
+	}
+	o.keys = map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 == nil && err2 == nil && len(e) > 0 && len(e) <= 4 {
+				o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if curve, ok := curves[k.Crv]; ok && err1 == nil && err2 == nil {
+				pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+				if curve.IsOnCurve(pub.X, pub.Y) {
+					o.keys[k.Kid] = pub
+				}
+			}
+		}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+}
+
+// getJSON sends a request to the provider and decodes its JSON response
+func (o *oidcAuth) getJSON(req *http.Request, v interface{}) error {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, v); err != nil || (resp.StatusCode != 200 && resp.StatusCode != 400) {
+		return fmt.Errorf("%s from %s: %s", resp.Status, req.URL.Host, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// sign encodes a value as a cookie value with an HMAC, so it can't be forged or altered.  The
+// cookie's name is signed too, so one kind of cookie can't be passed off as another.
+func (o *oidcAuth) sign(name string, v interface{}) string {
+	b, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(name + "=" + payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks and decodes a value made by sign
+func (o *oidcAuth) verify(name, value string, v interface{}) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(name + "=" + parts[0]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	return err == nil && json.Unmarshal(b, v) == nil
+}
+
+func (o *oidcAuth) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // sent on the provider's redirect back, but not cross-site POSTs
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// randomToken is 32 random bytes, base64url-encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "traildash"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://traildash.test/auth/callback"
+)
+
+// oidcStandIn is an OpenID Connect provider serving discovery, JWKS and the token endpoint.
+// It issues a code for each authorization request the test makes and redeems it only with the
+// PKCE verifier of the challenge.
+type oidcStandIn struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	lock   sync.Mutex
+	codes  map[string]oidcStandInCode
+	claims func(issuer, nonce string) map[string]interface{} // claims of the next ID token
+	signer *rsa.PrivateKey                                   // signs ID tokens, the published key unless changed
+}
+
+// oidcStandInCode is what an authorization code was issued for
+type oidcStandInCode struct {
+	challenge string
+	nonce     string
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcStandIn{t: t, key: key, signer: key, codes: map[string]oidcStandInCode{}}
+	p.claims = func(issuer, nonce string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    issuer,
+			"aud":    testClientID,
+			"sub":    "user-1",
+			"email":  "alice@example.com",
+			"groups": []string{"engineering", "security"},
+			"nonce":  nonce,
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{map[string]string{
+			"kid": "key-1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+// authorize issues a code for the authorization request traildash redirected to
+func (p *oidcStandIn) authorize(location string) (code, state string) {
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, p.server.URL+"/authorize?") {
+		p.t.Fatalf("login redirected to %s, want the authorization endpoint", location)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL {
+		p.t.Errorf("authorization request %s", q.Encode())
+	}
+	if q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) != 43 {
+		p.t.Errorf("PKCE challenge %q with method %q, want S256", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+	if len(q.Get("nonce")) < 1 || len(q.Get("state")) < 1 {
+		p.t.Errorf("authorization request without a nonce or state")
+	}
+	code = randomToken()
+	p.lock.Lock()
+	p.codes[code] = oidcStandInCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.lock.Unlock()
+	return code, q.Get("state")
+}
+
+// token redeems a code once, for the client with the verifier of its PKCE challenge
+func (p *oidcStandIn) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(e string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": e})
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != testClientID || secret != testClientSecret {
+		fail("invalid_client")
+		return
+	}
+	r.ParseForm()
+	p.lock.Lock()
+	issued, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.lock.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != testRedirectURL {
+		fail("invalid_grant")
+		return
+	} else if base64.RawURLEncoding.EncodeToString(challenge[:]) != issued.challenge {
+		fail("invalid_grant") // the PKCE verifier doesn't match the challenge
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"id_token":     p.idToken(p.claims(p.server.URL, issued.nonce)),
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+	})
+}
+
+// idToken signs claims with RS256
+func (p *oidcStandIn) idToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testOIDCAuth(p *oidcStandIn) *oidcAuth {
+	return &oidcAuth{
+		issuer:        p.server.URL,
+		clientID:      testClientID,
+		clientSecret:  testClientSecret,
+		redirectURL:   testRedirectURL,
+		scopes:        "openid email profile",
+		userClaim:     "email",
+		groupsClaim:   "groups",
+		allowedGroups: map[string]bool{"security": true},
+		secret:        []byte("0123456789abcdef0123456789abcdef"),
+		sessionTTL:    time.Hour,
+		client:        p.server.Client(),
+	}
+}
+
+// responseCookie finds a cookie set by a response
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// login runs a login from /auth/login?rd= through the provider to the callback
+func login(t *testing.T, o *oidcAuth, p *oidcStandIn, rd string) *httptest.ResponseRecorder {
+	flow, code, state := startLogin(t, o, p, rd)
+	return callback(o, flow, code, state)
+}
+
+// startLogin visits /auth/login and the provider, returning the flow cookie, code and state
+func startLogin(t *testing.T, o *oidcAuth, p *oidcStandIn, rd string) (*http.Cookie, string, string) {
+	w := httptest.NewRecorder()
+	o.loginHandler(w, httptest.NewRequest("GET", "/auth/login?rd="+url.QueryEscape(rd), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	flow := responseCookie(w, oidcFlowCookie)
+	if flow == nil {
+		t.Fatal("login set no flow cookie")
+	}
+	code, state := p.authorize(w.Header().Get("Location"))
+	return flow, code, state
+}
+
+// callback returns from the provider to /auth/callback
+func callback(o *oidcAuth, flow *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/auth/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	r.AddCookie(flow)
+	w := httptest.NewRecorder()
+	o.callbackHandler(w, r)
+	return w
+}
+
+// sessionRequest makes a request to a wrapped handler with a session cookie, returning the
+// response and the identity the handler saw
+func sessionRequest(o *oidcAuth, path string, session *http.Cookie) (*httptest.ResponseRecorder, *webIdentity) {
+	var id *webIdentity
+	h := o.wrap(func(w http.ResponseWriter, r *http.Request) { id = requestIdentity(r) })
+	r := httptest.NewRequest("GET", path, nil)
+	if session != nil {
+		r.AddCookie(session)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w, id
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newOIDCStandIn(t)
+	defer p.server.Close()
+	o := testOIDCAuth(p)
+
+	w := login(t, o, p, "/#/dashboard/file/default.json")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/#/dashboard/file/default.json" {
+		t.Fatalf("callback: %d to %q, want a redirect to the page asked for: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	session := responseCookie(w, oidcSessionCookie)
+	if session == nil {
+		t.Fatal("no session cookie")
+	} else if !session.HttpOnly {
+		t.Errorf("session cookie readable by scripts")
+	}
+
+	resp, id := sessionRequest(o, "/api/events", session)
+	if resp.Code != 200 || id == nil {
+		t.Fatalf("session refused: %d", resp.Code)
+	}
+	if id.user != "alice@example.com" || strings.Join(id.groups, ",") != "engineering,security" {
+		t.Errorf("identity %+v", id)
+	}
+	if resp, _ := sessionRequest(o, "/api/events", nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("API request without a session: %d, want 401", resp.Code)
+	}
+	if resp, _ := sessionRequest(o, "/", nil); resp.Code != http.StatusFound || !strings.HasPrefix(resp.Header().Get("Location"), "/auth/login?rd=") {
+		t.Errorf("page without a session: %d to %q, want a redirect to log in", resp.Code, resp.Header().Get("Location"))
+	}
+}
+
+func TestOIDCRejectsTokens(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(p *oidcStandIn, claims map[string]interface{})
+	}{
+		{"issuer", func(p *oidcStandIn, claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" }},
+		{"audience", func(p *oidcStandIn, claims map[string]interface{}) { claims["aud"] = "another-client" }},
+		{"expired", func(p *oidcStandIn, claims map[string]interface{}) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		}},
+		{"no expiry", func(p *oidcStandIn, claims map[string]interface{}) { delete(claims, "exp") }},
+		{"nonce", func(p *oidcStandIn, claims map[string]interface{}) { claims["nonce"] = "replayed" }},
+		{"signature", func(p *oidcStandIn, claims map[string]interface{}) { p.signer = other }},
+	}
+	for _, tt := range tests {
+		p := newOIDCStandIn(t)
+		claims := p.claims
+		p.claims = func(issuer, nonce string) map[string]interface{} {
+			c := claims(issuer, nonce)
+			tt.change(p, c)
+			return c
+		}
+		w := login(t, testOIDCAuth(p), p, "/")
+		if w.Code != http.StatusBadGateway || responseCookie(w, oidcSessionCookie) != nil {
+			t.Errorf("%s: token accepted: %d %s", tt.name, w.Code, w.Body)
+		}
+		p.server.Close()
+	}
+}
+
+func TestOIDCPKCEAndState(t *testing.T) {
+	p := newOIDCStandIn(t)
+	defer p.server.Close()
+	o := testOIDCAuth(p)
+
+	// a code issued for another login's challenge, as if intercepted, can't be redeemed
+	flow, code, state := startLogin(t, o, p, "/")
+	_, other, _ := startLogin(t, o, p, "/")
+	p.codes[code], p.codes[other] = p.codes[other], p.codes[code]
+	if w := callback(o, flow, code, state); w.Code != http.StatusBadGateway || responseCookie(w, oidcSessionCookie) != nil {
+		t.Errorf("code redeemed with the wrong PKCE verifier: %d", w.Code)
+	}
+
+	flow, code, _ = startLogin(t, o, p, "/")
+	if w := callback(o, flow, code, "forged-state"); w.Code != http.StatusBadRequest || responseCookie(w, oidcSessionCookie) != nil {
+		t.Errorf("callback with the wrong state: %d, want 400", w.Code)
+	}
+
+	_, code, state = startLogin(t, o, p, "/")
+	if w := callback(o, &http.Cookie{Name: oidcFlowCookie, Value: "forged"}, code, state); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with a forged flow cookie: %d, want 400", w.Code)
+	}
+}
+
+func TestOIDCGroups(t *testing.T) {
+	p := newOIDCStandIn(t)
+	defer p.server.Close()
+	o := testOIDCAuth(p)
+	o.allowedGroups = map[string]bool{"admins": true}
+
+	w := login(t, o, p, "/")
+	if w.Code != http.StatusForbidden || responseCookie(w, oidcSessionCookie) != nil {
+		t.Errorf("user outside OIDC_ALLOWED_GROUPS: %d, want 403", w.Code)
+	}
+}
+
+func TestOIDCTamperedCookies(t *testing.T) {
+	p := newOIDCStandIn(t)
+	defer p.server.Close()
+	o := testOIDCAuth(p)
+	session := responseCookie(login(t, o, p, "/"), oidcSessionCookie)
+	if session == nil {
+		t.Fatal("no session cookie")
+	}
+
+	// another user's name with the original signature
+	parts := strings.Split(session.Value, ".")
+	forged, _ := json.Marshal(oidcSession{User: "mallory@example.com", Groups: []string{"security"}, Expires: time.Now().Add(time.Hour).Unix()})
+	tampered := *session
+	tampered.Value = base64.RawURLEncoding.EncodeToString(forged) + "." + parts[1]
+	if resp, id := sessionRequest(o, "/api/events", &tampered); resp.Code != http.StatusUnauthorized || id != nil {
+		t.Errorf("tampered session accepted: %d %+v", resp.Code, id)
+	}
+
+	// signed with another secret
+	resigned := *session
+	resigned.Value = (&oidcAuth{secret: []byte("another secret")}).sign(oidcSessionCookie, oidcSession{User: "alice@example.com", Expires: time.Now().Add(time.Hour).Unix()})
+	if resp, _ := sessionRequest(o, "/api/events", &resigned); resp.Code != http.StatusUnauthorized {
+		t.Errorf("session signed with another secret accepted: %d", resp.Code)
+	}
+
+	// a login flow cookie passed off as a session
+	flow := *session
+	flow.Value = o.sign(oidcFlowCookie, oidcSession{User: "alice@example.com", Expires: time.Now().Add(time.Hour).Unix()})
+	if resp, _ := sessionRequest(o, "/api/events", &flow); resp.Code != http.StatusUnauthorized {
+		t.Errorf("flow cookie accepted as a session: %d", resp.Code)
+	}
+
+	// an expired session
+	expired := *session
+	expired.Value = o.sign(oidcSessionCookie, oidcSession{User: "alice@example.com", Expires: time.Now().Add(-time.Minute).Unix()})
+	if resp, _ := sessionRequest(o, "/api/events", &expired); resp.Code != http.StatusUnauthorized {
+		t.Errorf("expired session accepted: %d", resp.Code)
+	}
+}
+
+func TestOIDCOpenRedirect(t *testing.T) {
+	tests := map[string]string{
+		"/":                          "/",
+		"/#/dashboard/file/x.json":   "/#/dashboard/file/x.json",
+		"/es/_search?q=a":            "/es/_search?q=a",
+		"":                           "/",
+		"//evil.example.com":         "/",
+		"/\\evil.example.com":        "/",
+		"/\t/evil.example.com":       "/",
+		"https://evil.example.com/":  "/",
+		"javascript:alert(1)":        "/",
+		"\\\\evil.example.com":       "/",
+		"/dashboard\r\nLocation: //": "/",
+	}
+	for rd, want := range tests {
+		if got := localRedirect(rd); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", rd, got, want)
+		}
+	}
+
+	// and through a whole login
+	p := newOIDCStandIn(t)
+	defer p.server.Close()
+	if w := login(t, testOIDCAuth(p), p, "/\\evil.example.com"); w.Header().Get("Location") != "/" {
+		t.Errorf("login redirected to %q", w.Header().Get("Location"))
+	}
+}
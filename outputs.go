@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// outputEvent is a CloudTrail record and its document in the configured OUTPUT_FORMAT
+type outputEvent struct {
+	Record *cloudtrailRecord
+	Doc    json.RawMessage
+}
+
+// output is somewhere events are stored, such as ElasticSearch or files
+type output interface {
+	// send stores the events of one CloudTrail file, calling done once they are safely stored
+	// (or with the first error)
+	send(events []*outputEvent, done func(error))
+}
+
+// esOutput indexes events into ElasticSearch, through the spool if there is one
+type esOutput struct {
+	c *config
+}
+
+func (o *esOutput) send(events []*outputEvent, done func(error)) {
+	items := make([]*bulkItem, 0, len(events))
+	for _, e := range events {
+		items = append(items, &bulkItem{Index: dailyIndex(e.Record), ID: e.Record.EventID, Doc: e.Doc})
+	}
+	if len(items) < 1 { // nothing to wait for, even while the indexer isn't running yet
+		done(nil)
+		return
+	} else if o.c.spool != nil { // the message can go as soon as the records are safely on disk
+		done(o.c.spool.write(items))
+		return
+	}
+	o.c.indexer.submit(items, done)
+}
+
+// parseOutputs creates the outputs listed in OUTPUTS, reading the settings of each
+func (c *config) parseOutputs() error {
+	names := os.Getenv("OUTPUTS")
+	if len(names) < 1 {
+		names = "elasticsearch"
+	}
+	for _, name := range strings.Split(names, ",") {
+		var o output
+		var err error
+		switch strings.TrimSpace(name) {
+		case "elasticsearch":
+			c.esEnabled = true
+			o = &esOutput{c: c}
+		case "file":
+			o, err = newFileOutputFromEnv(c)
+		case "splunk":
+			o, err = newSplunkOutputFromEnv(c)
+		case "syslog":
+			o, err = newSyslogOutputFromEnv(c)
+		case "webhook":
+			o, err = newWebhookOutputFromEnv(c)
+		case "kafka":
+			o, err = newKafkaOutputFromEnv(c)
+		case "parquet":
+			o, err = newParquetOutputFromEnv(c)
+		case "store":
+			if len(os.Getenv("STORE_DIR")) < 1 {
+				return fmt.Errorf("Must set STORE_DIR for the store output.")
+			}
+			if c.store, err = newEventStore(c, os.Getenv("STORE_DIR")); err != nil {
+				return fmt.Errorf("Error opening STORE_DIR: %s", err.Error())
+			}
+			o = c.store
+		default:
+			return fmt.Errorf("Invalid OUTPUTS.  Must be a comma separated list of 'elasticsearch', 'file', 'splunk', 'syslog', 'webhook', 'kafka', 'parquet' and 'store'.")
+		}
+		if err != nil {
+			return err
+		}
+		c.outputs = append(c.outputs, o)
+	}
+	return nil
+}
+
+// send passes the events of one file to every output, calling done once all have them
+func (c *config) send(events []*outputEvent, done func(error)) {
+	if len(c.outputs) == 1 {
+		c.outputs[0].send(events, done)
+		return
+	}
+	var lock sync.Mutex
+	remaining := len(c.outputs)
+	var first error
+	for _, o := range c.outputs {
+		o.send(events, func(err error) {
+			lock.Lock()
+			if err != nil && first == nil {
+				first = err
+			}
+			remaining--
+			finished := remaining == 0
+			lock.Unlock()
+			if finished {
+				done(first)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestESOutputSpoolWithoutES(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// with a spool, files are read before ElasticSearch is set up and the indexer runs
+	c := &config{}
+	c.indexer = newIndexer(c, defaultBulkMaxBytes, defaultBulkMaxDocs, defaultBulkFlushInterval)
+	if c.spool, err = newSpool(c, dir, defaultSpoolMaxBytes); err != nil {
+		t.Fatal(err)
+	}
+	o := &esOutput{c: c}
+
+	send := func(events []*outputEvent) error {
+		result := make(chan error, 1)
+		go o.send(events, func(err error) { result <- err })
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("send of %d events blocked without ElasticSearch", len(events))
+			return nil
+		}
+	}
+
+	// a file whose records were all filtered out
+	if err := send(nil); err != nil {
+		t.Errorf("empty batch: %s", err)
+	}
+	if err := send([]*outputEvent{storeEvent("event-1", "2024-01-02T03:04:05Z", "RunInstances")}); err != nil {
+		t.Errorf("spooled batch: %s", err)
+	}
+	segments, _ := ioutil.ReadDir(dir)
+	if len(segments) != 1 {
+		t.Errorf("%d spool segments, want 1", len(segments))
+	}
+}
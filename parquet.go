@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+)
+
+// A minimal Parquet writer: one row group per file, one gzip-compressed PLAIN data page per
+// column, and flat optional columns only.  That is all the archive needs.
+// See https://github.com/apache/parquet-format
+
+// Parquet physical types, converted types and enums
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetOptional  = 1
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetGzip      = 2
+	parquetDataPage  = 0
+	parquetCreatedBy = "traildash"
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn is a flat, optional column.  value returns nil for a null, or a string, int64
+// or bool matching the physical type.
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32 // -1 for none
+	value     func(r *cloudtrailRecord) interface{}
+}
+
+// writeParquet encodes rows as a complete Parquet file
+func writeParquet(w io.Writer, columns []parquetColumn, rows []*cloudtrailRecord) error {
+	out := &countingWriter{w: w}
+	if _, err := out.Write(parquetMagic); err != nil {
+		return err
+	}
+
+	chunks := make([]*thriftStruct, 0, len(columns))
+	var totalSize int64
+	for _, col := range columns {
+		offset := out.n
+		raw, nulls := encodeParquetPage(col, rows)
+		var page bytes.Buffer
+		gz := gzip.NewWriter(&page)
+		gz.Write(raw)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		header := &thriftStruct{}
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(raw)))
+		header.i32(3, int32(page.Len()))
+		dataHeader := &thriftStruct{}
+		dataHeader.i32(1, int32(len(rows)))
+		dataHeader.i32(2, parquetPlain)
+		dataHeader.i32(3, parquetRLE)
+		dataHeader.i32(4, parquetRLE)
+		header.structure(5, dataHeader)
+		h := header.bytes()
+		if _, err := out.Write(h); err != nil {
+			return err
+		}
+		if _, err := out.Write(page.Bytes()); err != nil {
+			return err
+		}
+
+		stats := &thriftStruct{}
+		stats.i64(3, int64(nulls))
+		meta := &thriftStruct{}
+		meta.i32(1, col.kind)
+		meta.i32List(2, []int32{parquetPlain, parquetRLE})
+		meta.stringList(3, []string{col.name})
+		meta.i32(4, parquetGzip)
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, int64(len(h)+len(raw)))
+		meta.i64(7, out.n-offset)
+		meta.i64(9, offset)
+		meta.structure(12, stats)
+		chunk := &thriftStruct{}
+		chunk.i64(2, offset)
+		chunk.structure(3, meta)
+		chunks = append(chunks, chunk)
+		totalSize += int64(len(h) + len(raw))
+	}
+
+	schema := []*thriftStruct{}
+	root := &thriftStruct{}
+	root.binary(4, []byte("schema"))
+	root.i32(5, int32(len(columns)))
+	schema = append(schema, root)
+	for _, col := range columns {
+		e := &thriftStruct{}
+		e.i32(1, col.kind)
+		e.i32(3, parquetOptional)
+		e.binary(4, []byte(col.name))
+		if col.converted >= 0 {
+			e.i32(6, col.converted)
+		}
+		schema = append(schema, e)
+	}
+	rowGroup := &thriftStruct{}
+	rowGroup.structList(1, chunks)
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(len(rows)))
+
+	meta := &thriftStruct{}
+	meta.i32(1, 1)
+	meta.structList(2, schema)
+	meta.i64(3, int64(len(rows)))
+	meta.structList(4, []*thriftStruct{rowGroup})
+	meta.binary(6, []byte(parquetCreatedBy+" "+version))
+	footer := meta.bytes()
+
+	if _, err := out.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	_, err := out.Write(parquetMagic)
+	return err
+}
+
+// encodeParquetPage encodes the definition levels and PLAIN values of a column, returning the
+// page and the number of nulls
+func encodeParquetPage(col parquetColumn, rows []*cloudtrailRecord) ([]byte, int) {
+	var values bytes.Buffer
+	defined := make([]bool, len(rows))
+	var bits, nbits uint
+	nulls := 0
+	for n, r := range rows {
+		v := col.value(r)
+		if v == nil {
+			nulls++
+			continue
+		}
+		defined[n] = true
+		switch col.kind {
+		case parquetByteArray:
+			s := v.(string)
+			binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+			values.WriteString(s)
+		case parquetInt64:
+			binary.Write(&values, binary.LittleEndian, v.(int64))
+		case parquetBoolean: // bit-packed, least significant bit first
+			if v.(bool) {
+				bits |= 1 << nbits
+			}
+			if nbits++; nbits == 8 {
+				values.WriteByte(byte(bits))
+				bits, nbits = 0, 0
+			}
+		}
+	}
+	if nbits > 0 {
+		values.WriteByte(byte(bits))
+	}
+
+	// definition levels are 0 (null) or 1, as RLE runs with a length prefix
+	var levels bytes.Buffer
+	for n := 0; n < len(defined); {
+		run := 1
+		for n+run < len(defined) && defined[n+run] == defined[n] {
+			run++
+		}
+		writeUvarint(&levels, uint64(run)<<1)
+		if defined[n] {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		n += run
+	}
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+	page.Write(values.Bytes())
+	return page.Bytes(), nulls
+}
+
+// thriftStruct encodes a struct with the Thrift compact protocol, which Parquet uses for its
+// metadata.  Fields must be added in increasing order.
+type thriftStruct struct {
+	buf  bytes.Buffer
+	last int16
+}
+
+// Thrift compact protocol types
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+func (t *thriftStruct) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftStruct) i32(id int16, v int32) {
+	t.field(id, thriftTypeI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftStruct) i64(id int16, v int64) {
+	t.field(id, thriftTypeI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftStruct) binary(id int16, v []byte) {
+	t.field(id, thriftTypeBinary)
+	writeUvarint(&t.buf, uint64(len(v)))
+	t.buf.Write(v)
+}
+
+func (t *thriftStruct) structure(id int16, v *thriftStruct) {
+	t.field(id, thriftTypeStruct)
+	t.buf.Write(v.bytes())
+}
+
+func (t *thriftStruct) listHeader(id int16, size int, kind byte) {
+	t.field(id, thriftTypeList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		writeUvarint(&t.buf, uint64(size))
+	}
+}
+
+func (t *thriftStruct) i32List(id int16, v []int32) {
+	t.listHeader(id, len(v), thriftTypeI32)
+	for _, i := range v {
+		writeUvarint(&t.buf, zigzag(int64(i)))
+	}
+}
+
+func (t *thriftStruct) stringList(id int16, v []string) {
+	t.listHeader(id, len(v), thriftTypeBinary)
+	for _, s := range v {
+		writeUvarint(&t.buf, uint64(len(s)))
+		t.buf.WriteString(s)
+	}
+}
+
+func (t *thriftStruct) structList(id int16, v []*thriftStruct) {
+	t.listHeader(id, len(v), thriftTypeStruct)
+	for _, s := range v {
+		t.buf.Write(s.bytes())
+	}
+}
+
+// bytes is the encoded struct, ending with its stop field
+func (t *thriftStruct) bytes() []byte {
+	return append(t.buf.Bytes()[:t.buf.Len():t.buf.Len()], 0)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+// countingWriter tracks the offset in the file being written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
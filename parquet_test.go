@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Field IDs and enum values from parquet.thrift, kept apart from the writer's so a mistake
+// in either shows up as a mismatch
+const (
+	pqFileVersion   = 1
+	pqFileSchema    = 2
+	pqFileNumRows   = 3
+	pqFileRowGroups = 4
+	pqFileCreatedBy = 6
+
+	pqSchemaType          = 1
+	pqSchemaRepetition    = 3
+	pqSchemaName          = 4
+	pqSchemaNumChildren   = 5
+	pqSchemaConvertedType = 6
+
+	pqRowGroupColumns = 1
+	pqRowGroupNumRows = 3
+
+	pqChunkMetaData = 3
+
+	pqMetaType            = 1
+	pqMetaPath            = 3
+	pqMetaCodec           = 4
+	pqMetaNumValues       = 5
+	pqMetaUncompressed    = 6
+	pqMetaCompressed      = 7
+	pqMetaDataPageOffset  = 9
+	pqMetaStatistics      = 12
+	pqStatisticsNullCount = 3
+
+	pqPageType         = 1
+	pqPageUncompressed = 2
+	pqPageCompressed   = 3
+	pqPageData         = 5
+	pqDataNumValues    = 1
+	pqDataEncoding     = 2
+	pqDataDefEncoding  = 3
+
+	pqBoolean   = 0
+	pqInt64     = 2
+	pqByteArray = 6
+	pqOptional  = 1
+	pqUTF8      = 0
+	pqTimestamp = 9
+	pqPlainEnc  = 0
+	pqRLEEnc    = 3
+	pqGzipCodec = 2
+)
+
+// thriftDecoder reads the Thrift compact protocol into generic values: structs become
+// map[int16]interface{}, lists []interface{}, integers int64 and binary []byte
+type thriftDecoder struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (d *thriftDecoder) byte() byte {
+	if d.pos >= len(d.b) {
+		d.err = fmt.Errorf("truncated at %d", d.pos)
+		return 0
+	}
+	d.pos++
+	return d.b[d.pos-1]
+}
+
+func (d *thriftDecoder) uvarint() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 64 && d.err == nil; shift += 7 {
+		b := d.byte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	return v
+}
+
+func (d *thriftDecoder) zigzag() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *thriftDecoder) value(kind byte) interface{} {
+	switch kind {
+	case 1, 2: // true and false
+		return kind == 1
+	case 3:
+		return int64(int8(d.byte()))
+	case 4, 5, 6:
+		return d.zigzag()
+	case 7:
+		if d.pos+8 > len(d.b) {
+			d.err = fmt.Errorf("truncated double at %d", d.pos)
+			return nil
+		}
+		d.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.b[d.pos-8:]))
+	case 8:
+		n := int(d.uvarint())
+		if n < 0 || d.pos+n > len(d.b) {
+			d.err = fmt.Errorf("truncated binary at %d", d.pos)
+			return nil
+		}
+		d.pos += n
+		return d.b[d.pos-n : d.pos]
+	case 9, 10:
+		header := d.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(d.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < n && d.err == nil; i++ {
+			if header&0x0f == 1 || header&0x0f == 2 { // booleans in lists are a byte each
+				list = append(list, d.byte() == 1)
+			} else {
+				list = append(list, d.value(header&0x0f))
+			}
+		}
+		return list
+	case 12:
+		return d.structure()
+	default:
+		d.err = fmt.Errorf("unexpected type %d at %d", kind, d.pos)
+		return nil
+	}
+}
+
+func (d *thriftDecoder) structure() map[int16]interface{} {
+	s := map[int16]interface{}{}
+	var last int16
+	for d.err == nil {
+		header := d.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(d.zigzag())
+		}
+		s[id] = d.value(header & 0x0f)
+		last = id
+	}
+	return s
+}
+
+// parquetTable is a decoded file: its metadata and each column's values, nil for nulls
+type parquetTable struct {
+	meta    map[int16]interface{}
+	schema  []map[int16]interface{}
+	chunks  []map[int16]interface{} // column metadata
+	columns [][]interface{}
+}
+
+// readParquetFile decodes a file of flat optional columns with gzip-compressed PLAIN v1 data
+// pages, checking every size and offset along the way
+func readParquetFile(t *testing.T, b []byte) *parquetTable {
+	if len(b) < 12 || string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatalf("no PAR1 magic around %d bytes", len(b))
+	}
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := &thriftDecoder{b: b[len(b)-8-footerLen : len(b)-8]}
+	p := &parquetTable{meta: footer.structure()}
+	if footer.err != nil || footer.pos != footerLen {
+		t.Fatalf("footer of %d bytes decoded to %d: %v", footerLen, footer.pos, footer.err)
+	}
+	for _, e := range p.meta[pqFileSchema].([]interface{}) {
+		p.schema = append(p.schema, e.(map[int16]interface{}))
+	}
+	rowGroups := p.meta[pqFileRowGroups].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	numRows := int(p.meta[pqFileNumRows].(int64))
+	for _, c := range rowGroups[0].(map[int16]interface{})[pqRowGroupColumns].([]interface{}) {
+		meta := c.(map[int16]interface{})[pqChunkMetaData].(map[int16]interface{})
+		p.chunks = append(p.chunks, meta)
+
+		start, ok := meta[pqMetaDataPageOffset].(int64)
+		offset := int(start)
+		if !ok || offset < 4 || offset >= len(b) {
+			t.Fatalf("column chunk %v has no data page", meta)
+		}
+		d := &thriftDecoder{b: b[offset:]}
+		page := d.structure()
+		data, _ := page[pqPageData].(map[int16]interface{})
+		if d.err != nil || page[pqPageType] != int64(0) || data == nil {
+			t.Fatalf("page at %d is %v: %v", offset, page, d.err)
+		}
+		compressed := b[offset+d.pos : offset+d.pos+int(page[pqPageCompressed].(int64))]
+		if int64(d.pos+len(compressed)) != meta[pqMetaCompressed] {
+			t.Errorf("column chunk of %d bytes, metadata says %d", d.pos+len(compressed), meta[pqMetaCompressed])
+		}
+		if meta[pqMetaCodec] != int64(pqGzipCodec) || data[pqDataEncoding] != int64(pqPlainEnc) || data[pqDataDefEncoding] != int64(pqRLEEnc) {
+			t.Fatalf("codec %v, encoding %v and definition level encoding %v", meta[pqMetaCodec], data[pqDataEncoding], data[pqDataDefEncoding])
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(raw)) != page[pqPageUncompressed] || int64(d.pos+len(raw)) != meta[pqMetaUncompressed] {
+			t.Errorf("page of %d bytes, header says %v and metadata %v", len(raw), page[pqPageUncompressed], meta[pqMetaUncompressed])
+		}
+		if data[pqDataNumValues] != int64(numRows) || meta[pqMetaNumValues] != int64(numRows) {
+			t.Errorf("%v and %v values, want %d", data[pqDataNumValues], meta[pqMetaNumValues], numRows)
+		}
+		kind, _ := meta[pqMetaType].(int64)
+		p.columns = append(p.columns, decodePlainPage(t, raw, kind, numRows))
+	}
+	return p
+}
+
+// decodePlainPage reads the RLE/bit-packed definition levels and PLAIN values of a page
+func decodePlainPage(t *testing.T, raw []byte, kind int64, rows int) []interface{} {
+	levelsLen := int(binary.LittleEndian.Uint32(raw))
+	levels := &thriftDecoder{b: raw[4 : 4+levelsLen]}
+	defined := []bool{}
+	for levels.pos < len(levels.b) && levels.err == nil {
+		header := levels.uvarint()
+		if header&1 == 0 { // a run of one value
+			v := levels.byte()
+			for n := uint64(0); n < header>>1; n++ {
+				defined = append(defined, v == 1)
+			}
+		} else { // groups of 8 bit-packed values
+			for n := uint64(0); n < header>>1; n++ {
+				v := levels.byte()
+				for bit := uint(0); bit < 8; bit++ {
+					defined = append(defined, v>>bit&1 == 1)
+				}
+			}
+		}
+	}
+	if levels.err != nil || len(defined) < rows {
+		t.Fatalf("%d definition levels for %d rows: %v", len(defined), rows, levels.err)
+	}
+
+	values := raw[4+levelsLen:]
+	column := make([]interface{}, rows)
+	bit := uint(0)
+	for n := 0; n < rows; n++ {
+		if !defined[n] {
+			continue
+		}
+		switch kind {
+		case pqByteArray:
+			size := int(binary.LittleEndian.Uint32(values))
+			column[n] = string(values[4 : 4+size])
+			values = values[4+size:]
+		case pqInt64:
+			column[n] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case pqBoolean:
+			column[n] = values[bit/8]>>(bit%8)&1 == 1
+			bit++
+		default:
+			t.Fatalf("unexpected physical type %d", kind)
+		}
+	}
+	if kind == pqBoolean {
+		values = values[(bit+7)/8:]
+	}
+	if len(values) > 0 {
+		t.Errorf("%d bytes left after %d rows", len(values), rows)
+	}
+	return column
+}
+
+// parquetRows are records with nulls, unicode and booleans spread over more than a byte
+func parquetRows() []*cloudtrailRecord {
+	rows := []*cloudtrailRecord{}
+	for n := 0; n < 21; n++ {
+		r := &cloudtrailRecord{
+			EventTime:          fmt.Sprintf("2024-01-02T03:04:%02dZ", n),
+			EventID:            fmt.Sprintf("event-%d", n),
+			EventSource:        "s3.amazonaws.com",
+			RecipientAccountId: "123456789012",
+		}
+		if n%4 != 0 {
+			r.EventName = "GetObject"
+		}
+		if n%3 != 0 {
+			readOnly := n%2 == 0
+			r.ReadOnly = &readOnly
+		}
+		rows = append(rows, r)
+	}
+	rows[1].UserAgent = "Mozilla/5.0 (ünïcode ✓)"
+	rows[2].ErrorCode, rows[2].ErrorMessage = "AccessDenied", "Access Denied"
+	rows[3].UserIdentity = map[string]interface{}{
+		"type": "AssumedRole",
+		"arn":  "arn:aws:sts::123456789012:assumed-role/admin/alice",
+		"sessionContext": map[string]interface{}{
+			"sessionIssuer": map[string]interface{}{"userName": "admin"},
+			"attributes":    map[string]interface{}{"mfaAuthenticated": "true"},
+		},
+	}
+	rows[4].RequestParameters = map[string]interface{}{"bucketName": "trail-bucket"}
+	rows[5].Provenance = &provenance{S3Bucket: "trail-bucket", S3ObjectKey: "AWSLogs/1.json.gz"}
+	return rows
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	rows := parquetRows()
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, parquetColumns, rows); err != nil {
+		t.Fatal(err)
+	}
+	p := readParquetFile(t, buf.Bytes())
+
+	if p.meta[pqFileVersion] != int64(1) || p.meta[pqFileNumRows] != int64(len(rows)) {
+		t.Errorf("version %v with %v rows, want version 1 with %d", p.meta[pqFileVersion], p.meta[pqFileNumRows], len(rows))
+	}
+	rowGroup := p.meta[pqFileRowGroups].([]interface{})[0].(map[int16]interface{})
+	if rowGroup[pqRowGroupNumRows] != int64(len(rows)) {
+		t.Errorf("row group of %v rows, want %d", rowGroup[pqRowGroupNumRows], len(rows))
+	}
+	if createdBy, _ := p.meta[pqFileCreatedBy].([]byte); !strings.HasPrefix(string(createdBy), "traildash ") {
+		t.Errorf("created by %q", createdBy)
+	}
+
+	// a root with a flat optional column for each
+	if len(p.schema) != len(parquetColumns)+1 || string(p.schema[0][pqSchemaName].([]byte)) != "schema" ||
+		p.schema[0][pqSchemaNumChildren] != int64(len(parquetColumns)) {
+		t.Fatalf("schema %v", p.schema)
+	}
+	want := map[string][2]int64{ // physical and converted type, -1 for none
+		"event_time":           {pqInt64, pqTimestamp},
+		"event_name":           {pqByteArray, pqUTF8},
+		"read_only":            {pqBoolean, -1},
+		"user_identity_arn":    {pqByteArray, pqUTF8},
+		"recipient_account_id": {pqByteArray, pqUTF8},
+	}
+	for i, col := range parquetColumns {
+		e := p.schema[i+1]
+		if name := string(e[pqSchemaName].([]byte)); name != col.name {
+			t.Errorf("column %d is %s, want %s", i, name, col.name)
+		}
+		if e[pqSchemaRepetition] != int64(pqOptional) {
+			t.Errorf("%s has repetition %v, want optional", col.name, e[pqSchemaRepetition])
+		}
+		if types, ok := want[col.name]; ok {
+			converted, hasConverted := e[pqSchemaConvertedType]
+			if e[pqSchemaType] != types[0] || (types[1] < 0 && hasConverted) || (types[1] >= 0 && converted != types[1]) {
+				t.Errorf("%s has type %v and converted type %v, want %v", col.name, e[pqSchemaType], converted, types)
+			}
+		}
+		meta := p.chunks[i]
+		if path := meta[pqMetaPath].([]interface{}); len(path) != 1 || string(path[0].([]byte)) != col.name {
+			t.Errorf("column chunk %d has path %q, want %s", i, path, col.name)
+		}
+		if meta[pqMetaType] != e[pqSchemaType] {
+			t.Errorf("%s chunk has type %v, schema %v", col.name, meta[pqMetaType], e[pqSchemaType])
+		}
+	}
+
+	// every value, and the null count of every column
+	for i, col := range parquetColumns {
+		nulls := int64(0)
+		for n, r := range rows {
+			v := col.value(r)
+			if v == nil {
+				nulls++
+			}
+			if got := p.columns[i][n]; !reflect.DeepEqual(got, v) {
+				t.Errorf("%s of row %d is %#v, want %#v", col.name, n, got, v)
+			}
+		}
+		stats := p.chunks[i][pqMetaStatistics].(map[int16]interface{})
+		if stats[pqStatisticsNullCount] != nulls {
+			t.Errorf("%s has null count %v, want %d", col.name, stats[pqStatisticsNullCount], nulls)
+		}
+	}
+
+	// and a few read back without the column definitions
+	column := func(name string) []interface{} {
+		for i, col := range parquetColumns {
+			if col.name == name {
+				return p.columns[i]
+			}
+		}
+		t.Fatalf("no %s column", name)
+		return nil
+	}
+	spot := []struct {
+		column string
+		row    int
+		value  interface{}
+	}{
+		{"event_time", 7, int64(1704164647000)},
+		{"event_name", 0, nil},
+		{"event_name", 1, "GetObject"},
+		{"read_only", 0, nil},
+		{"read_only", 8, true},
+		{"read_only", 19, false},
+		{"user_agent", 1, "Mozilla/5.0 (ünïcode ✓)"},
+		{"error_code", 2, "AccessDenied"},
+		{"principal_name", 3, "admin"},
+		{"user_identity_session_issuer_user_name", 3, "admin"},
+		{"user_identity_mfa_authenticated", 3, "true"},
+		{"request_parameters", 4, `{"bucketName":"trail-bucket"}`},
+		{"s3_object_key", 5, "AWSLogs/1.json.gz"},
+		{"s3_object_key", 6, nil},
+	}
+	for _, tt := range spot {
+		if got := column(tt.column)[tt.row]; !reflect.DeepEqual(got, tt.value) {
+			t.Errorf("%s of row %d is %#v, want %#v", tt.column, tt.row, got, tt.value)
+		}
+	}
+}
+
+func TestParquetDefinitionLevels(t *testing.T) {
+	// runs of nulls and values, including ones long enough for a two byte run header
+	rows := []*cloudtrailRecord{}
+	for n := 0; n < 300; n++ {
+		r := &cloudtrailRecord{EventTime: "2024-01-02T03:04:05Z"}
+		if n >= 10 && n < 200 {
+			r.EventName = fmt.Sprintf("e%d", n)
+		}
+		rows = append(rows, r)
+	}
+	columns := []parquetColumn{parquetString("event_name", func(r *cloudtrailRecord) string { return r.EventName })}
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	p := readParquetFile(t, buf.Bytes())
+	for n, v := range p.columns[0] {
+		if (n >= 10 && n < 200) != (v != nil) || (v != nil && v != fmt.Sprintf("e%d", n)) {
+			t.Errorf("row %d is %#v", n, v)
+		}
+	}
+}
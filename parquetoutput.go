@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	defaultParquetMaxRows = 100000
+	defaultParquetMaxAge  = 5 * time.Minute
+	parquetNullPartition  = "__HIVE_DEFAULT_PARTITION__"
+)
+
+// parquetOutput archives events as Parquet files partitioned Hive-style by account, region and
+// date (account=123456789012/region=us-east-1/date=2015-10-21/), for Athena or DuckDB.  Parquet
+// files can't be appended to, so rows are held in memory until a partition has PARQUET_MAX_ROWS
+// of them or is PARQUET_MAX_AGE old, and a CloudTrail file is only acknowledged once every
+// partition it went to has been written.
+type parquetOutput struct {
+	c          *config
+	dir        string // local directory, or
+	bucket     string // S3 bucket
+	prefix     string // and key prefix
+	maxRows    int
+	maxAge     time.Duration
+	lock       sync.Mutex
+	partitions map[string]*parquetPartition
+	waiting    int // files waiting to be acknowledged
+	seq        int
+}
+
+// parquetPartition is the buffered rows of one partition and the files they came from
+type parquetPartition struct {
+	rows    []*cloudtrailRecord
+	batches []*parquetBatch
+	opened  time.Time
+}
+
+// parquetBatch is one CloudTrail file, acknowledged once all of its partitions are written
+type parquetBatch struct {
+	remaining int
+	err       error
+	done      func(error)
+}
+
+// parquetColumns is the archive schema.  Columns are only ever added to the end, so tables
+// defined over older files keep working.
+var parquetColumns = []parquetColumn{
+	{"event_time", parquetInt64, parquetTimestampMillis, func(r *cloudtrailRecord) interface{} {
+		return r.eventTime().UnixNano() / int64(time.Millisecond)
+	}},
+	parquetString("event_version", func(r *cloudtrailRecord) string { return r.EventVersion }),
+	parquetString("event_id", func(r *cloudtrailRecord) string { return r.EventID }),
+	parquetString("event_type", func(r *cloudtrailRecord) string { return r.EventType }),
+	parquetString("event_category", func(r *cloudtrailRecord) string { return r.EventCategory }),
+	parquetString("event_source", func(r *cloudtrailRecord) string { return r.EventSource }),
+	parquetString("event_name", func(r *cloudtrailRecord) string { return r.EventName }),
+	parquetString("aws_region", func(r *cloudtrailRecord) string { return r.AwsRegion }),
+	parquetString("recipient_account_id", func(r *cloudtrailRecord) string { return r.RecipientAccountId }),
+	parquetString("source_ip_address", func(r *cloudtrailRecord) string { return r.SourceIPAddress }),
+	parquetString("user_agent", func(r *cloudtrailRecord) string { return r.UserAgent }),
+	parquetString("request_id", func(r *cloudtrailRecord) string { return r.RequestID }),
+	parquetString("shared_event_id", func(r *cloudtrailRecord) string { return r.SharedEventID }),
+	parquetString("error_code", func(r *cloudtrailRecord) string { return r.ErrorCode }),
+	parquetString("error_message", func(r *cloudtrailRecord) string { return r.ErrorMessage }),
+	{"read_only", parquetBoolean, -1, func(r *cloudtrailRecord) interface{} {
+		if r.ReadOnly == nil {
+			return nil
+		}
+		return *r.ReadOnly
+	}},
+	parquetString("principal_name", func(r *cloudtrailRecord) string { return principalName(r.UserIdentity) }),
+	parquetIdentity("user_identity_type", "type"),
+	parquetIdentity("user_identity_principal_id", "principalId"),
+	parquetIdentity("user_identity_arn", "arn"),
+	parquetIdentity("user_identity_account_id", "accountId"),
+	parquetIdentity("user_identity_access_key_id", "accessKeyId"),
+	parquetIdentity("user_identity_user_name", "userName"),
+	parquetIdentity("user_identity_invoked_by", "invokedBy"),
+	parquetIdentity("user_identity_session_issuer_arn", "sessionContext", "sessionIssuer", "arn"),
+	parquetIdentity("user_identity_session_issuer_user_name", "sessionContext", "sessionIssuer", "userName"),
+	parquetIdentity("user_identity_mfa_authenticated", "sessionContext", "attributes", "mfaAuthenticated"),
+	parquetString("request_parameters", func(r *cloudtrailRecord) string { return parquetJSON(r.RequestParameters) }),
+	parquetString("insight_details", func(r *cloudtrailRecord) string {
+		if r.InsightDetails == nil {
+			return ""
+		}
+		return parquetJSON(r.InsightDetails)
+	}),
+	parquetString("s3_bucket", func(r *cloudtrailRecord) string { return r.provenance().S3Bucket }),
+	parquetString("s3_object_key", func(r *cloudtrailRecord) string { return r.provenance().S3ObjectKey }),
+	parquetString("ingest_time", func(r *cloudtrailRecord) string { return r.provenance().IngestTime }),
+}
+
+// parquetString is a UTF8 column which is null when empty
+func parquetString(name string, value func(r *cloudtrailRecord) string) parquetColumn {
+	return parquetColumn{name, parquetByteArray, parquetUTF8, func(r *cloudtrailRecord) interface{} {
+		if s := value(r); len(s) > 0 {
+			return s
+		}
+		return nil
+	}}
+}
+
+// parquetIdentity is a column of a userIdentity field, following a path of nested objects
+func parquetIdentity(name string, path ...string) parquetColumn {
+	return parquetString(name, func(r *cloudtrailRecord) string {
+		var v interface{} = r.UserIdentity
+		for _, key := range path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return ""
+			}
+			v = m[key]
+		}
+		switch v := v.(type) {
+		case string:
+			return v
+		case nil:
+			return ""
+		default:
+			return parquetJSON(v)
+		}
+	})
+}
+
+// parquetJSON renders a value as a JSON string column, empty for nothing
+func parquetJSON(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok && len(m) < 1 {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return ""
+	}
+	return string(b)
+}
+
+// provenance is the record's provenance, or an empty one
+func (r *cloudtrailRecord) provenance() *provenance {
+	if r.Provenance == nil {
+		return &provenance{}
+	}
+	return r.Provenance
+}
+
+func newParquetOutputFromEnv(c *config) (*parquetOutput, error) {
+	o := &parquetOutput{
+		c:          c,
+		dir:        os.Getenv("PARQUET_DIR"),
+		maxRows:    defaultParquetMaxRows,
+		maxAge:     defaultParquetMaxAge,
+		partitions: map[string]*parquetPartition{},
+	}
+	if s3url := os.Getenv("PARQUET_S3_PREFIX"); len(s3url) > 0 {
+		if !strings.HasPrefix(s3url, "s3://") || len(o.dir) > 0 {
+			return nil, fmt.Errorf("Invalid PARQUET_S3_PREFIX.  Must be an s3://bucket/prefix URL, and PARQUET_DIR must not be set.")
+		}
+		parts := strings.SplitN(strings.TrimPrefix(s3url, "s3://"), "/", 2)
+		o.bucket = parts[0]
+		if len(parts) > 1 {
+			o.prefix = strings.Trim(parts[1], "/")
+		}
+		if len(o.bucket) < 1 {
+			return nil, fmt.Errorf("Invalid PARQUET_S3_PREFIX.  Must be an s3://bucket/prefix URL, and PARQUET_DIR must not be set.")
+		}
+	} else if len(o.dir) < 1 {
+		return nil, fmt.Errorf("Must set PARQUET_DIR or PARQUET_S3_PREFIX for the parquet output.")
+	}
+	if len(os.Getenv("PARQUET_MAX_ROWS")) > 0 {
+		n, err := strconv.Atoi(os.Getenv("PARQUET_MAX_ROWS"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid PARQUET_MAX_ROWS.  Must be a positive number of rows.")
+		}
+		o.maxRows = n
+	}
+	if len(os.Getenv("PARQUET_MAX_AGE")) > 0 {
+		d, err := time.ParseDuration(os.Getenv("PARQUET_MAX_AGE"))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid PARQUET_MAX_AGE.  Must be a duration such as '5m'.")
+		}
+		o.maxAge = d
+	}
+	if len(o.dir) > 0 {
+		if err := os.MkdirAll(o.dir, 0755); err != nil {
+			return nil, fmt.Errorf("Error creating PARQUET_DIR: %s", err.Error())
+		}
+		if err := o.recover(); err != nil {
+			return nil, fmt.Errorf("Error cleaning up PARQUET_DIR: %s", err.Error())
+		}
+	}
+	go o.flushLoop()
+	return o, nil
+}
+
+func (o *parquetOutput) send(events []*outputEvent, done func(error)) {
+	if len(events) < 1 {
+		done(nil)
+		return
+	}
+	batch := &parquetBatch{done: done}
+	o.lock.Lock()
+
+	full := []string{}
+	for _, e := range events {
+		key := parquetPartitionKey(e.Record)
+		p, ok := o.partitions[key]
+		if !ok {
+			p = &parquetPartition{opened: time.Now()}
+			o.partitions[key] = p
+		}
+		if len(p.batches) < 1 || p.batches[len(p.batches)-1] != batch {
+			p.batches = append(p.batches, batch)
+			batch.remaining++
+		}
+		p.rows = append(p.rows, e.Record)
+		if len(p.rows) == o.maxRows {
+			full = append(full, key)
+		}
+	}
+	o.waiting++
+
+	complete := []*parquetBatch{}
+	for _, key := range full {
+		complete = append(complete, o.flush(key)...)
+	}
+	// SQS messages stay in flight until acknowledged, so don't hold on to too many of them
+	if o.waiting >= maxPendingFiles {
+		for key := range o.partitions {
+			complete = append(complete, o.flush(key)...)
+		}
+	}
+	o.lock.Unlock()
+	parquetAcknowledge(complete)
+}
+
+// parquetPartitionKey is the Hive-style partition path of a record
+func parquetPartitionKey(r *cloudtrailRecord) string {
+	return "account=" + parquetPartitionValue(r.RecipientAccountId) +
+		"/region=" + parquetPartitionValue(r.AwsRegion) +
+		"/date=" + r.eventTime().Format("2006-01-02")
+}
+
+// parquetPartitionValue keeps partition values to characters which are safe in paths and keys
+func parquetPartitionValue(s string) string {
+	if len(s) < 1 {
+		return parquetNullPartition
+	}
+	return strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+// flush writes out the rows of a partition and returns the files which are now complete, to
+// be acknowledged once the lock is released
+func (o *parquetOutput) flush(key string) []*parquetBatch {
+	p := o.partitions[key]
+	delete(o.partitions, key)
+	err := o.write(key, p.rows)
+	if err != nil {
+		log.Printf("Error writing parquet output %s: %s", key, err.Error())
+		err = fmt.Errorf("Error writing parquet output: %s", err.Error())
+	}
+	complete := []*parquetBatch{}
+	for _, b := range p.batches {
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+		if b.remaining--; b.remaining == 0 {
+			o.waiting--
+			complete = append(complete, b)
+		}
+	}
+	return complete
+}
+
+// parquetAcknowledge calls back the files which are complete.  The callbacks may block, so
+// they are called without holding the lock.
+func parquetAcknowledge(batches []*parquetBatch) {
+	for _, b := range batches {
+		b.done(b.err)
+	}
+}
+
+// write encodes rows as a new file in a partition, in PARQUET_DIR or under PARQUET_S3_PREFIX
+func (o *parquetOutput) write(key string, rows []*cloudtrailRecord) error {
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, parquetColumns, rows); err != nil {
+		return err
+	}
+	o.seq++
+	name := fmt.Sprintf("cloudtrail-%s-%d-%d.parquet", time.Now().UTC().Format("20060102T150405Z"), os.Getpid(), o.seq)
+
+	if len(o.bucket) > 0 {
+		k := key + "/" + name
+		if len(o.prefix) > 0 {
+			k = o.prefix + "/" + k
+		}
+		_, err := s3.New(&o.c.awsConfig).PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(k),
+			Body:   bytes.NewReader(buf.Bytes()),
+		})
+		if err == nil {
+			o.c.debug("Wrote s3://%s/%s (%d rows)", o.bucket, k, len(rows))
+		}
+		return err
+	}
+
+	dir := filepath.Join(o.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	syncDir(dir)
+	o.c.debug("Wrote %s (%d rows)", path, len(rows))
+	return nil
+}
+
+// flushLoop writes out partitions which have been buffered longer than the maximum age
+func (o *parquetOutput) flushLoop() {
+	for range time.Tick(fileRotateCheck) {
+		complete := []*parquetBatch{}
+		o.lock.Lock()
+		for key, p := range o.partitions {
+			if time.Since(p.opened) >= o.maxAge {
+				complete = append(complete, o.flush(key)...)
+			}
+		}
+		o.lock.Unlock()
+		parquetAcknowledge(complete)
+	}
+}
+
+// recover removes files left half-written by a crash.  Their events were never acknowledged,
+// so they will be delivered again.
+func (o *parquetOutput) recover() error {
+	return filepath.Walk(o.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".parquet.tmp") {
+			log.Printf("Removing unfinished %s", path)
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
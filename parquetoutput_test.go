@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParquetAcknowledgeUnlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o := &parquetOutput{c: &config{}, dir: dir, maxRows: 1, maxAge: time.Hour, partitions: map[string]*parquetPartition{}}
+
+	// a callback which sends more events, as a busy worker can, must not deadlock
+	finished := make(chan error, 2)
+	events := []*outputEvent{{Record: &cloudtrailRecord{EventID: "1", EventTime: "2024-01-02T03:04:05Z"}}}
+	go o.send(events, func(err error) {
+		o.send(events, func(err error) { finished <- err })
+		finished <- err
+	})
+	for n := 0; n < 2; n++ {
+		select {
+		case err := <-finished:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("done callback called with the lock held")
+		}
+	}
+	if o.waiting != 0 {
+		t.Errorf("%d files still waiting to be acknowledged", o.waiting)
+	}
+}
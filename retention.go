@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const retentionInterval = 1 * time.Hour
+
+// retentionPolicy removes daily indices whose events are all older than a number of days
+type retentionPolicy struct {
+	days   int
+	action string // "delete" or "close"
+	dryRun bool
+}
+
+// retentionLoop enforces the retention policy at startup and then every hour
+func (c *config) retentionLoop() {
+	for {
+		if c.esEnabled {
+			for _, alias := range []string{esIndex, esInsightIndex} {
+				if err := c.enforceRetention(alias, time.Now()); err != nil {
+					log.Printf("Error enforcing retention of %s indices: %s", alias, err.Error())
+				}
+			}
+		}
+		if c.store != nil {
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			c.store.expire(today.AddDate(0, 0, -c.retention.days), c.retention.dryRun)
+		}
+		time.Sleep(retentionInterval)
+	}
+}
+
+// enforceRetention deletes or closes the daily indices of an alias from before the cutoff day
+func (c *config) enforceRetention(alias string, now time.Time) error {
+	indices, err := c.dailyIndices(alias)
+	if err != nil {
+		return err
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	cutoff := today.AddDate(0, 0, -c.retention.days)
+
+	expired := []string{}
+	for index, status := range indices {
+		day, err := time.Parse(esIndexDateFormat, strings.TrimPrefix(index, alias+"-"))
+		if err != nil || !day.Before(cutoff) {
+			continue
+		} else if c.retention.action == "close" && status == "close" {
+			continue
+		}
+		expired = append(expired, index)
+	}
+	if len(expired) < 1 {
+		c.debug("No %s indices older than %d days", alias, c.retention.days)
+		return nil
+	}
+	sort.Strings(expired)
+
+	for _, index := range expired {
+		if c.retention.dryRun {
+			log.Printf("Retention dry run: would %s ElasticSearch index %s (older than %d days)", c.retention.action, index, c.retention.days)
+			continue
+		}
+		method, path := "DELETE", "/"+index
+		if c.retention.action == "close" {
+			method, path = "POST", "/"+index+"/_close"
+		}
+		resp, err := c.es.request(method, path, nil)
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("Error response from Elasticsearch to %s %s: %s %s", method, path, resp.Status, string(body))
+		}
+		log.Printf("Retention: %s ElasticSearch index %s (older than %d days)", pastTense(c.retention.action), index, c.retention.days)
+	}
+	return nil
+}
+
+// dailyIndices lists the daily indices of an alias, open or closed, with their status
+func (c *config) dailyIndices(alias string) (map[string]string, error) {
+	resp, err := c.es.request("GET", "/_cat/indices/"+alias+"-*?h=index,status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	} else if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Error response from Elasticsearch: %s", resp.Status)
+	}
+
+	indices := map[string]string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], alias+"-") {
+			continue
+		}
+		indices[fields[0]] = fields[1]
+	}
+	return indices, scanner.Err()
+}
+
+func pastTense(action string) string {
+	if action == "close" {
+		return "closed"
+	}
+	return "deleted"
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webRoles limits which events users of the web interface can see.  Each role grants its users
+// and groups the events of some AWS accounts, optionally only from some services.  People with
+// no role can't see any events.
+type webRoles struct {
+	Roles         []*webRole `json:"roles"`
+	accountFields []string   // document fields holding RecipientAccountId
+	sourceFields  []string   // document fields holding EventSource
+}
+
+// webRole grants access to events.  An account of "*" means every account.
+type webRole struct {
+	Name         string   `json:"name"`
+	Users        []string `json:"users"`
+	Groups       []string `json:"groups"`
+	Accounts     []string `json:"accounts"`
+	EventSources []string `json:"eventSources"`
+}
+
+// dataScope is the roles a request was granted.  A nil scope means every event.
+type dataScope struct {
+	roles []*webRole
+}
+
+type dataScopeKey struct{}
+
+// scopeFields are the fields of each OUTPUT_FORMAT that scopes filter on.  OCSF puts the event
+// source in a different field for Authentication events.
+var scopeFields = map[string][2][]string{
+	"cloudtrail": {{"RecipientAccountId"}, {"EventSource"}},
+	"ecs":        {{"cloud.account.id"}, {"event.provider"}},
+	"ocsf":       {{"cloud.account.uid"}, {"api.service.name", "dst_endpoint.svc_name", "service.name"}},
+}
+
+// scopedSearchKeys are the parts of a search body scoped users may send.  Anything else, such as
+// "suggest", "runtime_mappings" or "script_fields", could read outside the query and isn't allowed.
+var scopedSearchKeys = map[string]bool{
+	"query": true, "from": true, "size": true, "sort": true, "_source": true, "fields": true,
+	"stored_fields": true, "docvalue_fields": true, "facets": true, "aggs": true, "aggregations": true,
+	"post_filter": true, "filter": true, "highlight": true, "timeout": true, "track_total_hits": true,
+	"track_scores": true, "version": true, "min_score": true, "search_after": true,
+	"terminate_after": true, "explain": true,
+}
+
+// scopedAggregations are the aggregations scoped users may run, which only count or summarize the
+// documents the query matched.  Others, such as "global" or "significant_terms", report on
+// documents outside it.
+var scopedAggregations = map[string]bool{
+	"terms": true, "histogram": true, "date_histogram": true, "range": true, "date_range": true,
+	"ip_range": true, "filter": true, "filters": true, "missing": true, "avg": true, "sum": true,
+	"min": true, "max": true, "stats": true, "extended_stats": true, "value_count": true,
+	"cardinality": true, "percentiles": true, "percentile_ranks": true, "top_hits": true,
+	"derivative": true, "cumulative_sum": true, "moving_avg": true, "serial_diff": true,
+	"avg_bucket": true, "sum_bucket": true, "min_bucket": true, "max_bucket": true,
+}
+
+// scopedFacets are the ElasticSearch 1.x facets scoped users may run, for Kibana 3
+var scopedFacets = map[string]bool{
+	"terms": true, "terms_stats": true, "histogram": true, "date_histogram": true, "range": true,
+	"statistical": true, "query": true, "filter": true,
+}
+
+// unscopedQueries are queries which read documents other than the ones they match: wrapped or
+// templated queries the checks can't see into, stored shapes and percolation
+var unscopedQueries = map[string]bool{
+	"wrapper": true, "template": true, "indexed_shape": true, "percolate": true,
+}
+
+// moreLikeThisQueries are the names of more_like_this, which can compare with stored documents
+var moreLikeThisQueries = map[string]bool{
+	"more_like_this": true, "mlt": true, "more_like_this_field": true, "mlt_field": true,
+}
+
+// scopedMultiSearchHeaderKeys are the parts of a multi search header scoped users may send.
+// Anything else, such as "indices", could name indices the scope check doesn't see.
+var scopedMultiSearchHeaderKeys = map[string]bool{
+	"index": true, "type": true, "search_type": true, "preference": true, "routing": true, "request_cache": true,
+}
+
+// scopedMetadataEndpoints are ElasticSearch endpoints Kibana needs which reveal no events
+var scopedMetadataEndpoints = map[string]bool{
+	"_mapping": true, "_mappings": true, "_aliases": true, "_alias": true, "_nodes": true,
+}
+
+// loadWebRoles reads and validates a JSON roles file
+func loadWebRoles(file string, c *config) (*webRoles, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	roles := webRoles{}
+	if err := json.Unmarshal(b, &roles); err != nil {
+		return nil, fmt.Errorf("JSON error in %s: %s", file, err.Error())
+	}
+	if len(roles.Roles) < 1 {
+		return nil, fmt.Errorf("no roles in %s", file)
+	}
+	for i, r := range roles.Roles {
+		if len(r.Name) < 1 {
+			r.Name = fmt.Sprintf("role%d", i+1)
+		}
+		if len(r.Users) < 1 && len(r.Groups) < 1 {
+			return nil, fmt.Errorf("Invalid role %s.  Must list users or groups.", r.Name)
+		} else if len(r.Accounts) < 1 {
+			return nil, fmt.Errorf("Invalid role %s.  Must list accounts, or \"*\" for every account.", r.Name)
+		}
+	}
+	fields, ok := scopeFields[c.formatName]
+	if !ok {
+		return nil, fmt.Errorf("Roles don't support OUTPUT_FORMAT %s", c.formatName)
+	}
+	roles.accountFields, roles.sourceFields = fields[0], fields[1]
+	return &roles, nil
+}
+
+// allAccounts is whether a role grants every account
+func (r *webRole) allAccounts() bool {
+	for _, a := range r.Accounts {
+		if a == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// scope finds the roles of a user, returning false if they have none
+func (roles *webRoles) scope(id *webIdentity) (*dataScope, bool) {
+	if id == nil {
+		return nil, false
+	}
+	groups := map[string]bool{}
+	for _, g := range id.groups {
+		groups[g] = true
+	}
+	s := &dataScope{}
+	for _, r := range roles.Roles {
+		matched := false
+		for _, u := range r.Users {
+			matched = matched || u == id.user
+		}
+		for _, g := range r.Groups {
+			matched = matched || groups[g]
+		}
+		if !matched {
+			continue
+		} else if r.allAccounts() && len(r.EventSources) < 1 {
+			return nil, true
+		}
+		s.roles = append(s.roles, r)
+	}
+	return s, len(s.roles) > 0
+}
+
+// wrap works out the scope of each request for a handler, refusing people with no role
+func (roles *webRoles) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if roles == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := roles.scope(requestIdentity(r))
+		if !ok {
+			http.Error(w, "No role grants you access to any events", http.StatusForbidden)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), dataScopeKey{}, s)))
+	}
+}
+
+// requestScope is the scope a request was granted, or nil for every event
+func requestScope(r *http.Request) *dataScope {
+	s, _ := r.Context().Value(dataScopeKey{}).(*dataScope)
+	return s
+}
+
+// esFilter is an ElasticSearch filter matching the events of the scope
+func (roles *webRoles) esFilter(s *dataScope) map[string]interface{} {
+	anyOf := func(fields, values []string) map[string]interface{} {
+		if len(fields) == 1 {
+			return map[string]interface{}{"terms": map[string]interface{}{fields[0]: values}}
+		}
+		should := []interface{}{}
+		for _, f := range fields {
+			should = append(should, map[string]interface{}{"terms": map[string]interface{}{f: values}})
+		}
+		return map[string]interface{}{"bool": map[string]interface{}{"should": should}}
+	}
+	grants := []interface{}{}
+	for _, r := range s.roles {
+		must := []interface{}{}
+		if !r.allAccounts() {
+			must = append(must, anyOf(roles.accountFields, r.Accounts))
+		}
+		if len(r.EventSources) > 0 {
+			must = append(must, anyOf(roles.sourceFields, r.EventSources))
+		}
+		grants = append(grants, map[string]interface{}{"bool": map[string]interface{}{"must": must}})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"should": grants}}
+}
+
+// scopeESRequest checks a scoped request to the ElasticSearch proxy, returning its body with
+// every search limited to the scope.  Only searches, Kibana's dashboards and metadata are
+// allowed, so documents can't be fetched some other way.
+func (roles *webRoles) scopeESRequest(r *http.Request, s *dataScope, v esVersion) (io.Reader, error) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/es"), "/")
+	segments := strings.Split(path, "/")
+	endpoint := segments[len(segments)-1]
+
+	switch {
+	case endpoint == "_search" || endpoint == "_msearch":
+		query := r.URL.Query()
+		if len(query.Get("q")) > 0 || len(query.Get("source")) > 0 {
+			return nil, fmt.Errorf("searches must send their query in the body")
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint == "_msearch" {
+			body, err = roles.scopeMultiSearch(body, segments[0], s, v)
+		} else if segments[0] != "kibana-int" { // dashboards are not events
+			body, err = roles.scopeSearch(body, s, v)
+		}
+		return bytes.NewReader(body), err
+	case segments[0] == "kibana-int" && !strings.Contains(path, "/_"): // dashboard documents
+		// dashboards are shared with people who can see everything, so scoped users can't change them
+		if r.Method != "GET" {
+			return nil, fmt.Errorf("dashboards can only be read for your role")
+		}
+		return r.Body, nil
+	case r.Method == "GET" && (path == "" || path == "_cluster/health" || scopedMetadataEndpoints[endpoint]):
+		return r.Body, nil
+	}
+	return nil, fmt.Errorf("only searches are allowed for your role")
+}
+
+// scopeSearch adds the scope's filter to the query of a search body
+func (roles *webRoles) scopeSearch(body []byte, s *dataScope, v esVersion) ([]byte, error) {
+	search := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&search); err != nil {
+			return nil, fmt.Errorf("invalid search body: %s", err.Error())
+		}
+	}
+	for key := range search {
+		if !scopedSearchKeys[key] {
+			return nil, fmt.Errorf("%q is not allowed in searches for your role", key)
+		}
+	}
+	if err := checkScopedQuery(search); err != nil {
+		return nil, err
+	}
+
+	filter := roles.esFilter(s)
+	query, ok := search["query"]
+	if !v.boolFilter() {
+		filtered := map[string]interface{}{"filter": filter}
+		if ok {
+			filtered["query"] = query
+		}
+		search["query"] = map[string]interface{}{"filtered": filtered}
+	} else {
+		clauses := map[string]interface{}{"filter": []interface{}{filter}}
+		if ok {
+			clauses["must"] = []interface{}{query}
+		}
+		search["query"] = map[string]interface{}{"bool": clauses}
+	}
+	return json.Marshal(search)
+}
+
+// scopeMultiSearch scopes each search of an _msearch body, except those only of dashboards
+func (roles *webRoles) scopeMultiSearch(body []byte, index string, s *dataScope, v esVersion) ([]byte, error) {
+	lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+	if len(lines)%2 != 0 {
+		return nil, fmt.Errorf("invalid multi search body: each search needs a header and a body")
+	}
+	var out bytes.Buffer
+	for n := 0; n < len(lines); n += 2 {
+		header := map[string]interface{}{}
+		if len(strings.TrimSpace(lines[n])) > 0 {
+			if err := json.Unmarshal([]byte(lines[n]), &header); err != nil {
+				return nil, fmt.Errorf("invalid multi search header: %s", err.Error())
+			}
+		}
+		for key := range header {
+			if !scopedMultiSearchHeaderKeys[key] {
+				return nil, fmt.Errorf("%q is not allowed in multi search headers for your role", key)
+			}
+		}
+		indices, err := multiSearchIndices(header, index)
+		if err != nil {
+			return nil, err
+		}
+		search := []byte(lines[n+1])
+		if len(indices) != 1 || indices[0] != "kibana-int" { // dashboards are not events
+			if search, err = roles.scopeSearch(search, s, v); err != nil {
+				return nil, err
+			}
+		}
+		out.WriteString(lines[n] + "\n")
+		out.Write(search)
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+// multiSearchIndices lists the indices a multi search header names, as a string or array of
+// comma separated names, or else the indices of the URL
+func multiSearchIndices(header map[string]interface{}, index string) ([]string, error) {
+	i, ok := header["index"]
+	if !ok {
+		return strings.Split(index, ","), nil
+	}
+	indices := []string{}
+	switch i := i.(type) {
+	case string:
+		indices = strings.Split(i, ",")
+	case []interface{}:
+		for _, name := range i {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid multi search index %v", name)
+			}
+			indices = append(indices, strings.Split(s, ",")...)
+		}
+	default:
+		return nil, fmt.Errorf("invalid multi search index %v", i)
+	}
+	return indices, nil
+}
+
+// checkScopedQuery refuses the parts of a search which read documents outside its query:
+// scripts, lookups of stored documents, and aggregations or facets other than scopedAggregations
+// and scopedFacets
+func checkScopedQuery(v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			var err error
+			switch {
+			case key == "script" || key == "scripted_metric" || strings.HasPrefix(key, "script_") || strings.HasSuffix(key, "_script"):
+				return fmt.Errorf("scripts are not allowed for your role")
+			case unscopedQueries[key]:
+				return fmt.Errorf("%q queries are not allowed for your role", key)
+			case key == "terms" && termsLookup(child):
+				return fmt.Errorf("terms lookups are not allowed for your role")
+			case moreLikeThisQueries[key] && likesDocuments(child):
+				return fmt.Errorf("more_like_this queries can only compare with text for your role")
+			case key == "aggs" || key == "aggregations":
+				err = checkAggregations(child)
+			case key == "facets":
+				err = checkFacets(child)
+			default:
+				err = checkScopedQuery(child)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if err := checkScopedQuery(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkAggregations allows only scopedAggregations, and terms aggregations only of terms found in
+// the matched documents
+func checkAggregations(v interface{}) error {
+	aggs, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid aggregations")
+	}
+	for name, a := range aggs {
+		agg, ok := a.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid aggregation %q", name)
+		}
+		for kind, params := range agg {
+			var err error
+			switch {
+			case kind == "aggs" || kind == "aggregations":
+				err = checkAggregations(params)
+			case kind == "meta":
+			case !scopedAggregations[kind]:
+				return fmt.Errorf("%q aggregations are not allowed for your role", kind)
+			case kind == "terms" && !positiveMinDocCount(params):
+				// a min_doc_count of 0 lists terms of every document in the index
+				return fmt.Errorf("terms aggregations with a min_doc_count below 1 are not allowed for your role")
+			default:
+				err = checkScopedQuery(params)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFacets allows only scopedFacets, without "all_terms" which lists terms of every document
+func checkFacets(v interface{}) error {
+	facets, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid facets")
+	}
+	for name, f := range facets {
+		facet, ok := f.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid facet %q", name)
+		}
+		for kind, params := range facet {
+			if kind != "facet_filter" && !scopedFacets[kind] {
+				return fmt.Errorf("%q is not allowed in facets for your role", kind)
+			}
+			if p, ok := params.(map[string]interface{}); ok && p["all_terms"] != nil && p["all_terms"] != false {
+				return fmt.Errorf("all_terms facets are not allowed for your role")
+			}
+			if err := checkScopedQuery(params); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// termsLookup is whether a terms query takes its terms from a stored document, as
+// {"terms": {"field": {"index": ..., "id": ..., "path": ...}}}
+func termsLookup(v interface{}) bool {
+	terms, _ := v.(map[string]interface{})
+	for _, values := range terms {
+		if _, ok := values.(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// likesDocuments is whether a more_like_this query compares with stored or given documents
+// rather than text
+func likesDocuments(v interface{}) bool {
+	mlt, _ := v.(map[string]interface{})
+	if mlt["ids"] != nil || mlt["docs"] != nil {
+		return true
+	}
+	for _, key := range []string{"like", "unlike"} {
+		items, ok := mlt[key].([]interface{})
+		if !ok {
+			items = []interface{}{mlt[key]}
+		}
+		for _, item := range items {
+			if _, ok := item.(map[string]interface{}); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// positiveMinDocCount is whether an aggregation's min_doc_count, if it has one, is at least 1
+func positiveMinDocCount(v interface{}) bool {
+	params, _ := v.(map[string]interface{})
+	n, ok := params["min_doc_count"]
+	if !ok {
+		return true
+	}
+	count, err := strconv.ParseFloat(fmt.Sprint(n), 64)
+	return err == nil && count >= 1
+}
+
+// logScopeRefusal notes a scoped request which was refused
+func logScopeRefusal(r *http.Request, err error) {
+	user := "unknown"
+	if id := requestIdentity(r); id != nil {
+		user = id.user
+	}
+	log.Printf("Refused ES %s request %s for %s: %s", r.Method, r.RequestURI, user, err.Error())
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testRoles() *webRoles {
+	fields := scopeFields["cloudtrail"]
+	return &webRoles{
+		Roles: []*webRole{
+			{Name: "payments", Groups: []string{"payments"}, Accounts: []string{"111111111111"}},
+			{Name: "s3", Users: []string{"bob"}, Accounts: []string{"*"}, EventSources: []string{"s3.amazonaws.com"}},
+			{Name: "security", Groups: []string{"security"}, Accounts: []string{"*"}},
+		},
+		accountFields: fields[0],
+		sourceFields:  fields[1],
+	}
+}
+
+// scopeRequest runs a proxy request through the scope of the payments role, returning the
+// body which would be sent on
+func scopeRequest(t *testing.T, method, path, body string, v esVersion) (string, error) {
+	roles := testRoles()
+	s, _ := roles.scope(&webIdentity{user: "alice", groups: []string{"payments"}})
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	scoped, err := roles.scopeESRequest(r, s, v)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+// searchFilter finds the filter a search was scoped with, or nil
+func searchFilter(t *testing.T, body string) interface{} {
+	search := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &search); err != nil {
+		t.Fatalf("invalid scoped search %q: %s", body, err)
+	}
+	if filters, ok := getPath(search, "query", "bool", "filter").([]interface{}); ok && len(filters) == 1 {
+		return filters[0]
+	}
+	return getPath(search, "query", "filtered", "filter")
+}
+
+// paymentsFilter is the filter of the payments role, as decoded JSON
+func paymentsFilter() interface{} {
+	roles := testRoles()
+	b, _ := json.Marshal(roles.esFilter(&dataScope{roles: roles.Roles[:1]}))
+	var filter interface{}
+	json.Unmarshal(b, &filter)
+	return filter
+}
+
+func TestRoleScope(t *testing.T) {
+	roles := testRoles()
+	tests := []struct {
+		id    *webIdentity
+		roles []string // nil for every event
+		ok    bool
+	}{
+		{&webIdentity{user: "alice", groups: []string{"payments"}}, []string{"payments"}, true},
+		{&webIdentity{user: "bob"}, []string{"s3"}, true},
+		{&webIdentity{user: "bob", groups: []string{"payments"}}, []string{"payments", "s3"}, true},
+		{&webIdentity{user: "carol", groups: []string{"security", "payments"}}, nil, true},
+		{&webIdentity{user: "dave", groups: []string{"marketing"}}, []string{}, false},
+		{nil, nil, false},
+	}
+	for _, tt := range tests {
+		s, ok := roles.scope(tt.id)
+		if ok != tt.ok {
+			t.Errorf("%+v: allowed %v, want %v", tt.id, ok, tt.ok)
+			continue
+		}
+		if tt.roles == nil {
+			if s != nil {
+				t.Errorf("%+v: scoped to %d roles, want every event", tt.id, len(s.roles))
+			}
+			continue
+		}
+		names := []string{}
+		for _, r := range s.roles {
+			names = append(names, r.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.roles, ",") {
+			t.Errorf("%+v: roles %v, want %v", tt.id, names, tt.roles)
+		}
+	}
+
+	filter := roles.esFilter(&dataScope{roles: roles.Roles[:2]})
+	b, _ := json.Marshal(filter)
+	want := `{"bool":{"should":[{"bool":{"must":[{"terms":{"RecipientAccountId":["111111111111"]}}]}},` +
+		`{"bool":{"must":[{"terms":{"EventSource":["s3.amazonaws.com"]}}]}}]}}`
+	if string(b) != want {
+		t.Errorf("filter %s, want %s", b, want)
+	}
+}
+
+func TestScopeSearch(t *testing.T) {
+	es7 := esVersion{Major: 7, Minor: 17}
+	filter := paymentsFilter()
+
+	// the query is kept, with the role's filter added
+	body, err := scopeRequest(t, "POST", "/es/cloudtrail-*/_search", `{"query":{"match":{"EventName":"RunInstances"}},"size":10}`, es7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := searchFilter(t, body); !reflect.DeepEqual(got, filter) {
+		t.Errorf("scoped with %v, want %v", got, filter)
+	}
+	search := map[string]interface{}{}
+	json.Unmarshal([]byte(body), &search)
+	if must, _ := getPath(search, "query", "bool", "must").([]interface{}); len(must) != 1 {
+		t.Errorf("original query lost: %s", body)
+	}
+
+	// ElasticSearch 1.x has no bool filter clause
+	body, err = scopeRequest(t, "POST", "/es/cloudtrail/_search", `{"query":{"match_all":{}}}`, esVersion{Major: 1, Minor: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"filtered"`) {
+		t.Errorf("1.x search not scoped with a filtered query: %s", body)
+	}
+	if got := searchFilter(t, body); !reflect.DeepEqual(got, filter) {
+		t.Errorf("1.x search scoped with %v, want %v", got, filter)
+	}
+
+	// an empty body still searches everything, so is scoped too
+	if body, err := scopeRequest(t, "GET", "/es/cloudtrail/_search", "", es7); err != nil || searchFilter(t, body) == nil {
+		t.Errorf("empty search not scoped: %s %v", body, err)
+	}
+
+	// dashboards aren't events
+	dashboards := `{"query":{"match_all":{}}}`
+	if body, err := scopeRequest(t, "POST", "/es/kibana-int/dashboard/_search", dashboards, es7); err != nil || body != dashboards {
+		t.Errorf("dashboard search changed: %s %v", body, err)
+	}
+
+	// searches Kibana makes are still allowed
+	for _, search := range []string{
+		`{"aggs":{"n":{"terms":{"field":"EventName","size":5,"min_doc_count":1},"aggs":{"t":{"date_histogram":{"field":"EventTime","interval":"1h"}}}}}}`,
+		`{"aggregations":{"r":{"filter":{"term":{"ErrorCode":"AccessDenied"}},"aggs":{"c":{"cardinality":{"field":"SourceIPAddress"}}}}}}`,
+		`{"facets":{"t":{"terms":{"field":"EventName","size":10},"facet_filter":{"fquery":{"query":{"match_all":{}}}}},"q":{"query":{"query_string":{"query":"*"}}}}}`,
+		`{"facets":{"t":{"terms":{"field":"EventName","all_terms":false}}}}`,
+		`{"query":{"terms":{"EventName":["RunInstances","StopInstances"]}}}`,
+		`{"query":{"more_like_this":{"fields":["ErrorMessage"],"like":"access denied"}}}`,
+	} {
+		if _, err := scopeRequest(t, "POST", "/es/cloudtrail/_search", search, es7); err != nil {
+			t.Errorf("%s refused: %s", search, err)
+		}
+	}
+
+	denied := []struct {
+		path string
+		body string
+	}{
+		{"/es/cloudtrail/_search?q=EventName:RunInstances", ""},
+		{"/es/cloudtrail/_search?source=%7B%7D", ""},
+		{"/es/cloudtrail/_search", `{"suggest":{"s":{"text":"a","term":{"field":"EventName"}}}}`},
+		{"/es/cloudtrail/_search", `{"runtime_mappings":{}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"everything":{"global":{},"aggs":{"n":{"terms":{"field":"EventName"}}}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"a":{"terms":{"field":"EventName"},"aggs":{"b":{"global":{}}}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"s":{"significant_terms":{"field":"EventName","background_filter":{"match_all":{}}}}}}`},
+		{"/es/cloudtrail/_search", `{"facets":{"f":{"terms":{"field":"EventName"},"global":true}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"n":{"terms":{"field":"EventName","min_doc_count":0}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"n":{"terms":{"field":"EventName","min_doc_count":"0"}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"s":{"significant_terms":{"field":"EventName"}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"s":{"significant_text":{"field":"ErrorMessage"}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"n":{"nested":{"path":"Resources"}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"s":{"sum":{"script":"doc['n'].value"}}}}`},
+		{"/es/cloudtrail/_search", `{"aggs":{"s":{"scripted_metric":{"map_script":"state.n = 1"}}}}`},
+		{"/es/cloudtrail/_search", `{"facets":{"f":{"terms":{"field":"EventName","all_terms":true}}}}`},
+		{"/es/cloudtrail/_search", `{"facets":{"f":{"terms":{"field":"EventName"},"nested":"Resources"}}}`},
+		{"/es/cloudtrail/_search", `{"facets":{"f":{"terms":{"script_field":"_source.EventName"}}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"terms":{"EventName":{"index":"cloudtrail","id":"abc123","path":"EventName"}}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"bool":{"filter":[{"terms":{"EventName":{"index":"cloudtrail","type":"event","id":"abc123","path":"EventName"}}}]}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"more_like_this":{"fields":["ErrorMessage"],"like":[{"_index":"cloudtrail","_id":"abc123"}]}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"more_like_this":{"fields":["ErrorMessage"],"like":"a","unlike":{"_id":"abc123"}}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"mlt":{"fields":["ErrorMessage"],"ids":["abc123"]}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"more_like_this":{"docs":[{"_index":"cloudtrail","_id":"abc123"}]}}}`},
+		{"/es/cloudtrail/_search", `{"script_fields":{"s":{"script":"_source.EventName"}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"script":{"script":"doc['n'].value > 1"}}}`},
+		{"/es/cloudtrail/_search", `{"sort":{"_script":{"script":"doc['n'].value","type":"number"}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"function_score":{"script_score":{"script":"1"}}}}`},
+		{"/es/cloudtrail/_search", `{"query":{"wrapper":{"query":"e30="}}}`},
+		{"/es/cloudtrail/_search", `not json`},
+	}
+	for _, tt := range denied {
+		if body, err := scopeRequest(t, "POST", tt.path, tt.body, es7); err == nil {
+			t.Errorf("%s %s allowed: %s", tt.path, tt.body, body)
+		}
+	}
+}
+
+func TestScopeMultiSearch(t *testing.T) {
+	es7 := esVersion{Major: 7, Minor: 17}
+	filter := paymentsFilter()
+	search := `{"query":{"match_all":{}}}`
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		scoped bool
+		err    bool
+	}{
+		{"index", "/es/_msearch", `{"index":"cloudtrail-2024.01.02"}`, true, false},
+		{"dashboards", "/es/_msearch", `{"index":"kibana-int"}`, false, false},
+		{"dashboards and events", "/es/_msearch", `{"index":"kibana-int,cloudtrail"}`, true, false},
+		{"array of dashboards", "/es/_msearch", `{"index":["kibana-int"]}`, false, false},
+		{"array with events", "/es/kibana-int/_msearch", `{"index":["kibana-int","cloudtrail"]}`, true, false},
+		{"empty array", "/es/kibana-int/_msearch", `{"index":[]}`, true, false},
+		{"header index over URL", "/es/kibana-int/_msearch", `{"index":"cloudtrail"}`, true, false},
+		{"URL dashboards", "/es/kibana-int/_msearch", `{}`, false, false},
+		{"URL dashboards, no header", "/es/kibana-int/_msearch", ``, false, false},
+		{"URL events", "/es/cloudtrail/_msearch", `{}`, true, false},
+		{"URL dashboards and events", "/es/kibana-int,cloudtrail/_msearch", `{}`, true, false},
+		{"no index", "/es/_msearch", `{}`, true, false},
+		{"allowed options", "/es/_msearch", `{"index":"cloudtrail","type":"event","search_type":"query_then_fetch","preference":"_local","routing":"a","request_cache":true}`, true, false},
+		{"indices", "/es/kibana-int/_msearch", `{"indices":"cloudtrail"}`, false, true},
+		{"indices array", "/es/kibana-int/_msearch", `{"index":"kibana-int","indices":["cloudtrail"]}`, false, true},
+		{"other option", "/es/_msearch", `{"index":"cloudtrail","allow_partial_search_results":true}`, false, true},
+		{"non-string index", "/es/_msearch", `{"index":7}`, false, true},
+		{"non-string index in array", "/es/_msearch", `{"index":["kibana-int",7]}`, false, true},
+		{"invalid header", "/es/_msearch", `{"index":`, false, true},
+	}
+	for _, tt := range tests {
+		body, err := scopeRequest(t, "POST", tt.path, tt.header+"\n"+search+"\n", es7)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want an error %v", tt.name, err, tt.err)
+			continue
+		} else if tt.err {
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+		if len(lines) != 2 || lines[0] != tt.header {
+			t.Errorf("%s: body %q", tt.name, body)
+			continue
+		}
+		got := searchFilter(t, lines[1])
+		if tt.scoped && !reflect.DeepEqual(got, filter) {
+			t.Errorf("%s: not scoped: %s", tt.name, lines[1])
+		} else if !tt.scoped && lines[1] != search {
+			t.Errorf("%s: dashboard search changed: %s", tt.name, lines[1])
+		}
+	}
+
+	// every search of a request is checked, not just the first
+	body := `{"index":"kibana-int"}` + "\n" + search + "\n" + `{"index":"cloudtrail"}` + "\n" + search + "\n"
+	scoped, err := scopeRequest(t, "POST", "/es/_msearch", body, es7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(scoped, "\n"), "\n")
+	if len(lines) != 4 || lines[1] != search || !reflect.DeepEqual(searchFilter(t, lines[3]), filter) {
+		t.Errorf("second search not scoped: %s", scoped)
+	}
+	if _, err := scopeRequest(t, "POST", "/es/_msearch", body+`{"indices":"cloudtrail"}`+"\n"+search+"\n", es7); err == nil {
+		t.Errorf("indices in a later header allowed")
+	}
+	if _, err := scopeRequest(t, "POST", "/es/_msearch", `{"index":"cloudtrail"}`+"\n", es7); err == nil {
+		t.Errorf("header without a search allowed")
+	}
+}
+
+func TestScopePaths(t *testing.T) {
+	es7 := esVersion{Major: 7, Minor: 17}
+	allowed := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/es/"},
+		{"GET", "/es/_cluster/health"},
+		{"GET", "/es/cloudtrail/_mapping"},
+		{"GET", "/es/_aliases"},
+		{"GET", "/es/_nodes"},
+		{"GET", "/es/kibana-int/dashboard/default.json"},
+	}
+	for _, tt := range allowed {
+		if _, err := scopeRequest(t, tt.method, tt.path, "", es7); err != nil {
+			t.Errorf("%s %s refused: %s", tt.method, tt.path, err)
+		}
+	}
+
+	denied := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/es/cloudtrail/event/abc123"},
+		{"GET", "/es/cloudtrail-2024.01.02/_doc/abc123"},
+		{"GET", "/es/cloudtrail/_mget"},
+		{"GET", "/es/cloudtrail/_count"},
+		{"GET", "/es/_cat/indices"},
+		{"GET", "/es/cloudtrail/_explain/abc123"},
+		{"POST", "/es/_bulk"},
+		{"POST", "/es/cloudtrail/_mapping"},
+		{"POST", "/es/cloudtrail/_update_by_query"},
+		{"POST", "/es/kibana-int/_delete_by_query"},
+		{"POST", "/es/_sql"},
+		{"PUT", "/es/cloudtrail/event/abc123"},
+		{"PUT", "/es/kibana-int/dashboard/mine"},
+		{"POST", "/es/kibana-int/dashboard/mine"},
+		{"DELETE", "/es/kibana-int/dashboard/default.json"},
+	}
+	for _, tt := range denied {
+		if _, err := scopeRequest(t, tt.method, tt.path, "", es7); err == nil {
+			t.Errorf("%s %s allowed", tt.method, tt.path)
+		}
+	}
+}
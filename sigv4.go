@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigv4Algorithm  = "AWS4-HMAC-SHA256"
+	sigv4TimeFormat = "20060102T150405Z"
+)
+
+// sigv4Signer signs requests with AWS Signature Version 4, as required by Amazon
+// Elasticsearch/OpenSearch Service domains with IAM access policies
+type sigv4Signer struct {
+	region  string
+	service string
+	creds   *credentials.Credentials
+	now     func() time.Time
+}
+
+// newSigV4Signer uses the same credentials as the SQS and S3 clients
+func newSigV4Signer(awsConfig *aws.Config, region, service string) *sigv4Signer {
+	creds := awsConfig.Credentials
+	if creds == nil {
+		creds = aws.DefaultConfig.Credentials
+	}
+	return &sigv4Signer{region: region, service: service, creds: creds, now: time.Now}
+}
+
+// sign adds the SigV4 headers to a request, reading the body to hash it
+func (s *sigv4Signer) sign(req *http.Request) error {
+	v, err := s.creds.Get()
+	if err != nil {
+		return fmt.Errorf("Error getting AWS credentials to sign ElasticSearch request: %s", err.Error())
+	}
+
+	body := []byte{}
+	if req.Body != nil {
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	payloadHash := sha256Hex(body)
+
+	t := s.now().UTC()
+	amzDate := t.Format(sigv4TimeFormat)
+	scope := strings.Join([]string{amzDate[:8], s.region, s.service, "aws4_request"}, "/")
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(v.SessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", v.SessionToken)
+	} else {
+		req.Header.Del("X-Amz-Security-Token")
+	}
+
+	signedHeaders, signature := sigv4Signature(req, amzDate, s.region, s.service, v.SecretAccessKey, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigv4Algorithm, v.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigv4Signature signs the canonical form of a request whose X-Amz-* headers are already set,
+// returning the signed header names and the signature
+func sigv4Signature(req *http.Request, amzDate, region, service, secret, payloadHash string) (string, string) {
+	scope := strings.Join([]string{amzDate[:8], region, service, "aws4_request"}, "/")
+	headers, signedHeaders := sigv4CanonicalHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		sigv4Escape(req.URL.EscapedPath(), false),
+		sigv4CanonicalQuery(req),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{sigv4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secret), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// sigv4CanonicalHeaders signs the host, content type and every x-amz-* header
+func sigv4CanonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if len(host) < 1 {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" {
+			values[k] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	headers := ""
+	for _, k := range names {
+		headers += k + ":" + values[k] + "\n"
+	}
+	return headers, strings.Join(names, ";")
+}
+
+// sigv4CanonicalQuery escapes the query string, sorted by name and then value
+func sigv4CanonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := []string{}
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, sigv4Escape(k, true)+"="+sigv4Escape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// sigv4Escape URI-encodes everything except unreserved characters (and optionally "/")
+func sigv4Escape(s string, escapeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !escapeSlash) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
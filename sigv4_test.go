@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// sigv4Verifier is a stand-in for an Amazon OpenSearch Service endpoint: it recomputes the
+// signature of each request the way AWS does and refuses any that don't match
+type sigv4Verifier struct {
+	t            *testing.T
+	region       string
+	service      string
+	secret       string
+	sessionToken string
+	lastURI      string // canonical URI of the last request
+}
+
+func (v *sigv4Verifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := v.verify(r); err != "" {
+		v.t.Logf("stand-in refused %s %s: %s", r.Method, r.URL, err)
+		http.Error(w, err, http.StatusForbidden)
+		return
+	}
+	w.Write([]byte(`{"ok":true}`))
+}
+
+func (v *sigv4Verifier) verify(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return "not signed"
+	}
+	fields := map[string]string{}
+	for _, f := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	scope := amzDate[:8] + "/" + v.region + "/" + v.service + "/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return "wrong credential scope " + fields["Credential"]
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if len(v.sessionToken) > 0 {
+		if r.Header.Get("X-Amz-Security-Token") != v.sessionToken {
+			return "missing session token"
+		} else if !strings.Contains(";"+fields["SignedHeaders"]+";", ";x-amz-security-token;") {
+			return "session token not signed"
+		}
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	payload := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payload[:]) {
+		return "payload hash mismatch"
+	}
+
+	// every service but S3 encodes the already-encoded path again
+	v.lastURI = awsEncode(r.URL.EscapedPath(), false)
+	query := r.URL.Query()
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := []string{}
+	for _, k := range keys {
+		sort.Strings(query[k])
+		for _, value := range query[k] {
+			params = append(params, awsEncode(k, true)+"="+awsEncode(value, true))
+		}
+	}
+	headers := ""
+	for _, h := range signed {
+		value := r.Header.Get(h)
+		if h == "host" {
+			value = r.Host
+		}
+		headers += h + ":" + strings.Join(strings.Fields(value), " ") + "\n"
+	}
+	canonical := strings.Join([]string{r.Method, v.lastURI, strings.Join(params, "&"), headers,
+		fields["SignedHeaders"], hex.EncodeToString(payload[:])}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + v.secret)
+	for _, part := range []string{amzDate[:8], v.region, v.service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if fields["Signature"] != hex.EncodeToString(key) {
+		return "signature mismatch"
+	}
+	return ""
+}
+
+// awsEncode is the URI encoding of the SigV4 documentation, built on the standard library's
+func awsEncode(s string, encodeSlash bool) string {
+	e := strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(url.QueryEscape(s))
+	if !encodeSlash {
+		e = strings.Replace(e, "%2F", "/", -1)
+	}
+	return e
+}
+
+func testSigner(service, token string) *sigv4Signer {
+	return &sigv4Signer{
+		region:  "us-east-1",
+		service: service,
+		creds:   credentials.NewStaticCredentials(testAccessKey, testSecretKey, token),
+		now:     func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+}
+
+func TestSigV4AgainstStandIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		token   string
+		method  string
+		path    string
+		body    string
+		uri     string // expected canonical URI
+	}{
+		{"search", "es", "", "POST", "/cloudtrail/_search?size=10&from=0", `{"query":{"match_all":{}}}`, "/cloudtrail/_search"},
+		{"double-encoded path", "es", "", "GET", "/cloudtrail/_doc/a:b%20c", "", "/cloudtrail/_doc/a%3Ab%2520c"},
+		{"session token", "es", "FwoGZXIvYXdzEXAMPLETOKEN//+=", "PUT", "/_template/cloudtrail", `{"index_patterns":["cloudtrail-*"]}`, "/_template/cloudtrail"},
+		{"serverless", "aoss", "", "POST", "/_bulk", "{}\n{}\n", "/_bulk"},
+	}
+	for _, tt := range tests {
+		v := &sigv4Verifier{t: t, region: "us-east-1", service: tt.service, secret: testSecretKey, sessionToken: tt.token}
+		server := httptest.NewServer(v)
+		req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := testSigner(tt.service, tt.token).sign(req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: stand-in refused the signature: %s", tt.name, resp.Status)
+		}
+		if v.lastURI != tt.uri {
+			t.Errorf("%s: canonical URI %s, want %s", tt.name, v.lastURI, tt.uri)
+		}
+		server.Close()
+	}
+}
+
+func TestSigV4RejectsMismatch(t *testing.T) {
+	v := &sigv4Verifier{t: t, region: "us-east-1", service: "es", secret: testSecretKey}
+	server := httptest.NewServer(v)
+	defer server.Close()
+
+	// the body is changed after signing
+	req, _ := http.NewRequest("POST", server.URL+"/_search", strings.NewReader(`{"size":1}`))
+	if err := testSigner("es", "").sign(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"size":2}`)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("altered body accepted: %s", resp.Status)
+	}
+
+	// signed with another secret key
+	wrong := testSigner("es", "")
+	wrong.creds = credentials.NewStaticCredentials(testAccessKey, "not-the-secret", "")
+	req, _ = http.NewRequest("GET", server.URL+"/", nil)
+	wrong.sign(req)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong secret accepted: %s", resp.Status)
+	}
+}
+
+// TestSigV4TestSuite checks the signature of the "get-vanilla" and
+// "get-vanilla-query-order-key-case" requests of the AWS SigV4 test suite
+func TestSigV4TestSuite(t *testing.T) {
+	tests := []struct {
+		url       string
+		signature string
+	}{
+		{"https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		req.Header.Set("X-Amz-Date", "20150830T123600Z")
+		signed, signature := sigv4Signature(req, "20150830T123600Z", "us-east-1", "service", testSecretKey, sha256Hex(nil))
+		if signed != "host;x-amz-date" {
+			t.Errorf("%s: signed headers %s", tt.url, signed)
+		}
+		if signature != tt.signature {
+			t.Errorf("%s: signature %s, want %s", tt.url, signature, tt.signature)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	splunkAttempts      = 5
+	splunkRetryDelay    = 1 * time.Second
+	splunkMaxBatchBytes = 1024 * 1024
+	splunkAckPoll       = 1 * time.Second
+	splunkAckTimeout    = 2 * time.Minute
+)
+
+// splunkOutput sends events to a Splunk HTTP Event Collector
+type splunkOutput struct {
+	c          *config
+	url        string
+	token      string
+	index      string
+	sourcetype string
+	host       string
+	ack        bool
+	channel    string
+	client     *http.Client
+	retryDelay time.Duration
+	ackPoll    time.Duration
+	ackTimeout time.Duration
+	pending    chan struct{} // limits files being sent at once
+}
+
+// splunkEvent is the HEC envelope of one event
+type splunkEvent struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Sourcetype string          `json:"sourcetype"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// splunkResponse is the body HEC returns for events and acknowledgement requests
+type splunkResponse struct {
+	Text  string          `json:"text"`
+	Code  int             `json:"code"`
+	AckID *int64          `json:"ackId"`
+	Acks  map[string]bool `json:"acks"`
+}
+
+func newSplunkOutputFromEnv(c *config) (*splunkOutput, error) {
+	o := &splunkOutput{
+		c:          c,
+		url:        strings.TrimSuffix(os.Getenv("SPLUNK_HEC_URL"), "/"),
+		token:      os.Getenv("SPLUNK_HEC_TOKEN"),
+		index:      os.Getenv("SPLUNK_INDEX"),
+		sourcetype: os.Getenv("SPLUNK_SOURCETYPE"),
+		ack:        len(os.Getenv("SPLUNK_ACK")) > 0,
+		retryDelay: splunkRetryDelay,
+		ackPoll:    splunkAckPoll,
+		ackTimeout: splunkAckTimeout,
+		pending:    make(chan struct{}, maxPendingFiles),
+	}
+	if len(o.url) < 1 || len(o.token) < 1 {
+		return nil, fmt.Errorf("Must set SPLUNK_HEC_URL and SPLUNK_HEC_TOKEN for the splunk output.")
+	}
+	if len(o.sourcetype) < 1 {
+		o.sourcetype = "aws:cloudtrail"
+	}
+	o.host, _ = os.Hostname()
+	tlsConfig := &tls.Config{}
+	if len(os.Getenv("SPLUNK_CA_FILE")) > 0 {
+		pem, err := ioutil.ReadFile(os.Getenv("SPLUNK_CA_FILE"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading SPLUNK_CA_FILE: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in SPLUNK_CA_FILE %s", os.Getenv("SPLUNK_CA_FILE"))
+		}
+	}
+	o.client = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	if o.ack {
+		o.channel = newUUID()
+	}
+	return o, nil
+}
+
+func (o *splunkOutput) send(events []*outputEvent, done func(error)) {
+	o.pending <- struct{}{}
+	go func() {
+		err := o.sendAll(events)
+		<-o.pending
+		if err != nil {
+			err = fmt.Errorf("Error sending to Splunk: %s", err.Error())
+		}
+		done(err)
+	}()
+}
+
+// sendAll posts the events of one file in batches of up to splunkMaxBatchBytes
+func (o *splunkOutput) sendAll(events []*outputEvent) error {
+	var batch bytes.Buffer
+	for _, e := range events {
+		b, err := json.Marshal(o.envelope(e))
+		if err != nil {
+			return err
+		}
+		if batch.Len() > 0 && batch.Len()+len(b) > splunkMaxBatchBytes {
+			if err := o.post(batch.Bytes()); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		batch.Write(b)
+		batch.WriteString("\n")
+	}
+	if batch.Len() > 0 {
+		return o.post(batch.Bytes())
+	}
+	return nil
+}
+
+// envelope wraps a document with its event time, source and sourcetype
+func (o *splunkOutput) envelope(e *outputEvent) splunkEvent {
+	t := e.Record.eventTime()
+	s := splunkEvent{
+		Time:       float64(t.UnixNano()/int64(time.Millisecond)) / 1000,
+		Host:       o.host,
+		Sourcetype: o.sourcetype,
+		Index:      o.index,
+		Event:      e.Doc,
+	}
+	if p := e.Record.Provenance; p != nil && len(p.S3Bucket) > 0 {
+		s.Source = "s3://" + p.S3Bucket + "/" + p.S3ObjectKey
+	}
+	return s
+}
+
+// post sends one batch, retrying with backoff while HEC is unreachable or busy, and waits for
+// the indexers to acknowledge it if SPLUNK_ACK is set
+func (o *splunkOutput) post(batch []byte) error {
+	delay := o.retryDelay
+	for attempt := 1; ; attempt++ {
+		ackID, retry, err := o.postOnce(batch)
+		if err == nil && ackID != nil {
+			retry, err = o.waitForAck(*ackID)
+		}
+		if err == nil {
+			return nil
+		} else if !retry || attempt >= splunkAttempts {
+			return err
+		}
+		log.Printf("Splunk HEC request failed, retrying in %s: %s", delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postOnce sends a batch and returns the acknowledgement ID, if any, and whether a failure
+// is worth retrying
+func (o *splunkOutput) postOnce(batch []byte) (*int64, bool, error) {
+	var r splunkResponse
+	status, err := o.request("/services/collector/event", batch, &r)
+	if err != nil {
+		return nil, true, err
+	} else if status != 200 || r.Code != 0 {
+		// 503 and code 9 mean the server is busy; bad tokens and malformed events won't get better
+		return nil, status >= 500 || status == 429 || r.Code == 9, fmt.Errorf("HEC error %d: %s (code %d)", status, r.Text, r.Code)
+	}
+	if o.ack && r.AckID == nil {
+		return nil, false, fmt.Errorf("HEC did not return an ackId.  Enable indexer acknowledgement on the token or unset SPLUNK_ACK.")
+	}
+	return r.AckID, false, nil
+}
+
+// waitForAck polls until the indexers have committed a batch.  A batch which is never
+// acknowledged is sent again.
+func (o *splunkOutput) waitForAck(id int64) (bool, error) {
+	body, _ := json.Marshal(map[string][]int64{"acks": []int64{id}})
+	deadline := time.Now().Add(o.ackTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(o.ackPoll)
+		var r splunkResponse
+		status, err := o.request("/services/collector/ack", body, &r)
+		if err != nil {
+			continue
+		} else if status != 200 {
+			return true, fmt.Errorf("HEC acknowledgement error %d: %s (code %d)", status, r.Text, r.Code)
+		}
+		if r.Acks[fmt.Sprintf("%d", id)] {
+			return false, nil
+		}
+	}
+	return true, fmt.Errorf("HEC did not acknowledge batch %d within %s", id, o.ackTimeout)
+}
+
+// request posts to a HEC endpoint with the token and decodes the response
+func (o *splunkOutput) request(path string, body []byte, v *splunkResponse) (int, error) {
+	req, err := http.NewRequest("POST", o.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Splunk "+o.token)
+	req.Header.Set("Content-Type", "application/json")
+	if o.ack {
+		req.Header.Set("X-Splunk-Request-Channel", o.channel)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, v); err != nil {
+		v.Text = strings.TrimSpace(string(b))
+	}
+	return resp.StatusCode, nil
+}
+
+// newUUID makes a random (version 4) UUID, as HEC requires for channels
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
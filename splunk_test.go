@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hecResponse is a canned HTTP Event Collector answer
+type hecResponse struct {
+	status int
+	body   string
+}
+
+// hecStandIn is an HTTP Event Collector which checks the token, keeps every batch it accepts
+// and answers with the queued responses before succeeding.  With acks set it behaves like a
+// token with indexer acknowledgement enabled, acknowledging each batch after ackAfter polls
+// (or never, if ackAfter is negative).
+type hecStandIn struct {
+	token     string
+	lock      sync.Mutex
+	responses []hecResponse
+	requests  int
+	batches   [][]byte
+	acks      bool
+	ackAfter  int
+	channels  map[string]bool // channels of the requests
+	polls     map[int64]int   // acknowledgement polls of each batch
+}
+
+func (h *hecStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.requests++
+	if r.URL.Path != "/services/collector/event" && r.URL.Path != "/services/collector/ack" {
+		http.NotFound(w, r)
+		return
+	} else if r.Header.Get("Authorization") != "Splunk "+h.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"text":"Invalid authorization","code":3}`))
+		return
+	}
+	channel := r.Header.Get("X-Splunk-Request-Channel")
+	if h.acks && len(channel) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"Data channel is missing","code":10}`))
+		return
+	} else if len(channel) > 0 {
+		if h.channels == nil {
+			h.channels = map[string]bool{}
+		}
+		h.channels[channel] = true
+	}
+	if r.URL.Path == "/services/collector/ack" {
+		h.ack(w, r)
+		return
+	}
+	if len(h.responses) > 0 {
+		resp := h.responses[0]
+		h.responses = h.responses[1:]
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	h.batches = append(h.batches, body)
+	if h.acks {
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, len(h.batches)-1)
+		return
+	}
+	w.Write([]byte(`{"text":"Success","code":0}`))
+}
+
+// ack answers an acknowledgement poll, counting the polls of each batch
+func (h *hecStandIn) ack(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Acks []int64 `json:"acks"`
+	}
+	if !h.acks {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"ACK is disabled","code":14}`))
+		return
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"Invalid data format","code":6}`))
+		return
+	}
+	if h.polls == nil {
+		h.polls = map[int64]int{}
+	}
+	acks := map[string]bool{}
+	for _, id := range req.Acks {
+		h.polls[id]++
+		acks[fmt.Sprintf("%d", id)] = h.ackAfter >= 0 && h.polls[id] > h.ackAfter
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+}
+
+func testSplunkOutput(url, token string) *splunkOutput {
+	return &splunkOutput{
+		url:        url,
+		token:      token,
+		index:      "cloudtrail",
+		sourcetype: "aws:cloudtrail",
+		host:       "traildash-1",
+		client:     &http.Client{},
+		retryDelay: 10 * time.Millisecond,
+		ackPoll:    10 * time.Millisecond,
+		ackTimeout: 200 * time.Millisecond,
+		pending:    make(chan struct{}, 1),
+	}
+}
+
+func testSplunkEvent(n int, doc string) *outputEvent {
+	return &outputEvent{
+		Record: &cloudtrailRecord{
+			EventTime:  "2024-01-02T03:04:05Z",
+			Provenance: &provenance{S3Bucket: "trail-bucket", S3ObjectKey: fmt.Sprintf("AWSLogs/%d.json.gz", n)},
+		},
+		Doc: json.RawMessage(doc),
+	}
+}
+
+// decodeBatch splits a batch into its event envelopes
+func decodeBatch(t *testing.T, batch []byte) []map[string]interface{} {
+	events := []map[string]interface{}{}
+	s := bufio.NewScanner(bytes.NewReader(batch))
+	s.Buffer(nil, 2*splunkMaxBatchBytes)
+	for s.Scan() {
+		e := map[string]interface{}{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("invalid event line %q: %s", s.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestSplunkEnvelope(t *testing.T) {
+	hec := &hecStandIn{token: "secret-token"}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	o := testSplunkOutput(server.URL, "secret-token")
+	if err := o.sendAll([]*outputEvent{testSplunkEvent(1, `{"EventName":"ConsoleLogin"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hec.batches) != 1 {
+		t.Fatalf("%d batches, want 1", len(hec.batches))
+	}
+	events := decodeBatch(t, hec.batches[0])
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	e := events[0]
+	want := map[string]interface{}{
+		"time":       float64(1704164645),
+		"sourcetype": "aws:cloudtrail",
+		"index":      "cloudtrail",
+		"host":       "traildash-1",
+		"source":     "s3://trail-bucket/AWSLogs/1.json.gz",
+	}
+	for k, v := range want {
+		if e[k] != v {
+			t.Errorf("%s is %v, want %v", k, e[k], v)
+		}
+	}
+	if doc, _ := e["event"].(map[string]interface{}); doc["EventName"] != "ConsoleLogin" {
+		t.Errorf("event is %v", e["event"])
+	}
+
+	if err := testSplunkOutput(server.URL, "wrong-token").sendAll([]*outputEvent{testSplunkEvent(1, `{}`)}); err == nil {
+		t.Errorf("wrong token accepted")
+	}
+}
+
+func TestSplunkBatching(t *testing.T) {
+	hec := &hecStandIn{token: "secret-token"}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	events := []*outputEvent{}
+	for n := 0; n < 5; n++ {
+		events = append(events, testSplunkEvent(n, fmt.Sprintf(`{"n":%d,"pad":"%s"}`, n, strings.Repeat("x", 300*1024))))
+	}
+	if err := testSplunkOutput(server.URL, "secret-token").sendAll(events); err != nil {
+		t.Fatal(err)
+	}
+	if len(hec.batches) < 2 {
+		t.Errorf("%d batches, want the events split into batches of up to %d bytes", len(hec.batches), splunkMaxBatchBytes)
+	}
+	n := 0
+	for _, batch := range hec.batches {
+		if len(batch) > splunkMaxBatchBytes {
+			t.Errorf("batch of %d bytes", len(batch))
+		}
+		for _, e := range decodeBatch(t, batch) {
+			if doc, _ := e["event"].(map[string]interface{}); doc["n"] != float64(n) {
+				t.Errorf("event %v out of order, want %d", doc["n"], n)
+			}
+			n++
+		}
+	}
+	if n != len(events) {
+		t.Errorf("%d events received, want %d", n, len(events))
+	}
+}
+
+func TestSplunkRetry(t *testing.T) {
+	hec := &hecStandIn{token: "secret-token", responses: []hecResponse{
+		{503, "<html>Service Unavailable</html>"},
+		{200, `{"text":"Server is busy","code":9}`},
+	}}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	if err := testSplunkOutput(server.URL, "secret-token").sendAll([]*outputEvent{testSplunkEvent(1, `{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if hec.requests != 3 || len(hec.batches) != 1 {
+		t.Errorf("%d requests and %d batches accepted, want a success after two retries", hec.requests, len(hec.batches))
+	}
+
+	// malformed events won't get better, so aren't retried
+	hec.requests, hec.batches = 0, nil
+	hec.responses = []hecResponse{{400, `{"text":"Invalid data format","code":6}`}}
+	if err := testSplunkOutput(server.URL, "secret-token").sendAll([]*outputEvent{testSplunkEvent(1, `{}`)}); err == nil {
+		t.Errorf("rejected batch reported as sent")
+	}
+	if hec.requests != 1 {
+		t.Errorf("%d requests, want a rejected batch sent once", hec.requests)
+	}
+}
+
+func TestSplunkAck(t *testing.T) {
+	hec := &hecStandIn{token: "secret-token", acks: true, ackAfter: 2}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	// the batch is only sent once, and is done once the third poll acknowledges it
+	o := testSplunkOutput(server.URL, "secret-token")
+	o.ack, o.channel = true, newUUID()
+	if err := o.sendAll([]*outputEvent{testSplunkEvent(1, `{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hec.batches) != 1 || hec.polls[0] != 3 {
+		t.Errorf("%d batches and %d acknowledgement polls, want 1 and 3", len(hec.batches), hec.polls[0])
+	}
+	if len(hec.channels) != 1 || !hec.channels[o.channel] {
+		t.Errorf("requests on channels %v, want only %s", hec.channels, o.channel)
+	}
+	if len(o.channel) != 36 || o.channel[14] != '4' {
+		t.Errorf("channel %s isn't a version 4 UUID", o.channel)
+	}
+
+	// a token without indexer acknowledgement returns no ackId, which won't get better
+	plain := &hecStandIn{token: "secret-token"}
+	plainServer := httptest.NewServer(plain)
+	defer plainServer.Close()
+	o = testSplunkOutput(plainServer.URL, "secret-token")
+	o.ack, o.channel = true, newUUID()
+	if err := o.sendAll([]*outputEvent{testSplunkEvent(1, `{}`)}); err == nil || !strings.Contains(err.Error(), "ackId") {
+		t.Errorf("batch without an ackId: error %v", err)
+	}
+	if plain.requests != 1 {
+		t.Errorf("%d requests, want a batch without an ackId sent once", plain.requests)
+	}
+}
+
+func TestSplunkAckTimeout(t *testing.T) {
+	hec := &hecStandIn{token: "secret-token", acks: true, ackAfter: -1}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	// a batch which is never acknowledged is sent again, then the file fails
+	o := testSplunkOutput(server.URL, "secret-token")
+	o.ack, o.channel = true, newUUID()
+	result := make(chan error, 1)
+	o.send([]*outputEvent{testSplunkEvent(1, `{}`)}, func(err error) { result <- err })
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "did not acknowledge") {
+			t.Errorf("unacknowledged batch: error %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("send never finished")
+	}
+	hec.lock.Lock()
+	defer hec.lock.Unlock()
+	if len(hec.batches) != splunkAttempts {
+		t.Errorf("batch sent %d times, want %d", len(hec.batches), splunkAttempts)
+	}
+	for id := int64(0); id < splunkAttempts; id++ {
+		if hec.polls[id] < 2 {
+			t.Errorf("batch %d polled %d times before it was sent again", id, hec.polls[id])
+		}
+	}
+}
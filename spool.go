@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxBytes = 1024 * 1024 * 1024
+	spoolScanInterval    = 1 * time.Second
+	spoolRetryDelay      = 30 * time.Second
+	spoolMaxDocBytes     = 64 * 1024 * 1024
+)
+
+// spool stores downloaded documents on disk so their SQS messages can be deleted before
+// ElasticSearch has them.  Each CloudTrail file becomes a segment file, written to a temporary
+// name and renamed once synced so a crash never leaves a partial segment.  Every document in a
+// segment is framed with its length and a CRC32 so corruption is detected on replay.
+type spool struct {
+	c        *config
+	dir      string
+	maxBytes int64
+	lock     sync.Mutex
+	size     int64
+	seq      uint64
+	inFlight map[string]bool
+}
+
+func newSpool(c *config, dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &spool{c: c, dir: dir, maxBytes: maxBytes, inFlight: map[string]bool{}}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".tmp") { // left by a crash while writing
+			os.Remove(filepath.Join(dir, f.Name()))
+		} else if strings.HasSuffix(f.Name(), ".seg") {
+			s.size += f.Size()
+		}
+	}
+	if s.size > 0 {
+		log.Printf("Spool %s holds %d bytes of documents waiting for ElasticSearch", dir, s.size)
+	}
+	return s, nil
+}
+
+// write stores the documents of one file as a new segment, failing if the spool is full
+func (s *spool) write(items []*bulkItem) error {
+	var seg bytes.Buffer
+	for _, i := range items {
+		b, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(b)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(b))
+		seg.Write(header[:])
+		seg.Write(b)
+	}
+
+	s.lock.Lock()
+	if s.size+int64(seg.Len()) > s.maxBytes {
+		s.lock.Unlock()
+		return fmt.Errorf("Spool %s is full (%d bytes).  Leaving the file on the queue until ElasticSearch catches up.", s.dir, s.size)
+	}
+	s.size += int64(seg.Len())
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.seg", time.Now().UnixNano(), s.seq%1000000)
+	s.lock.Unlock()
+
+	if err := s.writeSegment(name, seg.Bytes()); err != nil {
+		s.lock.Lock()
+		s.size -= int64(seg.Len())
+		s.lock.Unlock()
+		return fmt.Errorf("Error writing spool segment: %s", err.Error())
+	}
+	s.c.debug("Spooled %d documents to %s", len(items), name)
+	return nil
+}
+
+// writeSegment syncs a segment under a temporary name, then renames it into place
+func (s *spool) writeSegment(name string, data []byte) error {
+	tmp := filepath.Join(s.dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(s.dir)
+	return nil
+}
+
+// readSegment decodes the documents of a segment, checking every frame
+func (s *spool) readSegment(name string) ([]*bulkItem, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	items := []*bulkItem{}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("truncated frame header after %d documents", len(items))
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > spoolMaxDocBytes {
+			return nil, fmt.Errorf("impossible document length %d after %d documents", size, len(items))
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("truncated document after %d documents", len(items))
+		} else if crc32.ChecksumIEEE(b) != binary.BigEndian.Uint32(header[4:8]) {
+			return nil, fmt.Errorf("checksum mismatch in document %d", len(items)+1)
+		}
+		i := &bulkItem{}
+		if err := json.Unmarshal(b, i); err != nil {
+			return nil, fmt.Errorf("invalid document %d: %s", len(items)+1, err.Error())
+		}
+		items = append(items, i)
+	}
+}
+
+// replay drains segments into ElasticSearch, oldest first, until the program exits
+func (s *spool) replay() {
+	for {
+		files, err := ioutil.ReadDir(s.dir)
+		if err != nil {
+			log.Printf("Error reading spool %s: %s", s.dir, err.Error())
+		}
+		names := []string{}
+		sizes := map[string]int64{}
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".seg") {
+				names = append(names, f.Name())
+				sizes[f.Name()] = f.Size()
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			s.lock.Lock()
+			busy := s.inFlight[name]
+			s.inFlight[name] = true
+			s.lock.Unlock()
+			if busy {
+				continue
+			}
+
+			items, err := s.readSegment(name)
+			if os.IsNotExist(err) { // replayed since the directory was listed
+				s.retry(name)
+				continue
+			} else if err != nil {
+				log.Printf("Corrupt spool segment %s: %s.  Moved aside as %s.corrupt", name, err.Error(), name)
+				os.Rename(filepath.Join(s.dir, name), filepath.Join(s.dir, name+".corrupt"))
+				s.done(name, sizes[name])
+				continue
+			}
+			name, size := name, sizes[name]
+			s.c.indexer.submit(items, func(err error) {
+				if err != nil {
+					log.Printf("Error replaying spool segment %s to ElasticSearch, retrying in %s: %s", name, spoolRetryDelay, err.Error())
+					time.AfterFunc(spoolRetryDelay, func() { s.retry(name) })
+					return
+				}
+				if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+					log.Printf("Error removing spool segment %s: %s", name, err.Error())
+				}
+				s.c.debug("Replayed %d documents from spool segment %s", len(items), name)
+				s.done(name, size)
+			})
+		}
+		time.Sleep(spoolScanInterval)
+	}
+}
+
+// done forgets a segment which has left the spool
+func (s *spool) done(name string, size int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.inFlight, name)
+	s.size -= size
+}
+
+// retry lets the replayer pick up a segment again, or forgets one which was removed
+func (s *spool) retry(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.inFlight, name)
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storeDateFormat    = "2006-01-02"
+	storeDefaultLimit  = 100
+	storeMaxLimit      = 1000
+	storeMinTokenChars = 2
+)
+
+// eventStore is an embedded event store for running without ElasticSearch.  Records are
+// appended to one NDJSON file per event day and indexed in memory by principal, event name,
+// event source, IP, account, resource and the words of those values.  The index is rebuilt
+// from the files at startup.
+type eventStore struct {
+	c         *config
+	dir       string
+	lock      sync.RWMutex
+	files     []*storeFile
+	fileByDay map[string]int
+	events    []storeEntry
+	postings  map[string][]int // term to ordinals in events, ascending
+	ids       map[string]bool  // event IDs already stored, as SQS messages can be redelivered
+}
+
+// storeFile is the file of one day
+type storeFile struct {
+	day     string
+	f       *os.File
+	size    int64
+	deleted bool
+}
+
+// storeEntry locates one record
+type storeEntry struct {
+	id     string
+	time   int64 // unix seconds
+	file   int
+	offset int64
+	length int
+}
+
+// storeQuery is a search of the store.  Every field set must match.
+type storeQuery struct {
+	Terms map[string]string // field name to value, "*" suffix for a prefix match
+	Text  string
+	From  time.Time
+	To    time.Time
+	Limit int
+	Scope *dataScope // nil for every event
+}
+
+// storeFields are the query API parameters which match indexed terms
+var storeFields = []string{"principal", "event", "source", "ip", "account", "resource"}
+
+func newEventStore(c *config, dir string) (*eventStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &eventStore{
+		c:         c,
+		dir:       dir,
+		fileByDay: map[string]int{},
+		postings:  map[string][]int{},
+		ids:       map[string]bool{},
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "events-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), "events-"), ".ndjson")
+		if _, err := time.Parse(storeDateFormat, day); err != nil {
+			continue
+		}
+		if err := s.loadFile(day); err != nil {
+			return nil, fmt.Errorf("Error loading %s: %s", name, err.Error())
+		}
+	}
+	log.Printf("Event store %s holds %d events", dir, len(s.events))
+	return s, nil
+}
+
+// loadFile indexes the records of a day's file, cutting off a partial record left by a crash
+func (s *eventStore) loadFile(day string) error {
+	n, err := s.openFile(day)
+	if err != nil {
+		return err
+	}
+	file := s.files[n]
+	r := bufio.NewReader(io.NewSectionReader(file.f, 0, 1<<62))
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		var rec cloudtrailRecord
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil || json.Unmarshal(line, &rec) != nil {
+			log.Printf("Truncating %s at a damaged record (offset %d)", file.f.Name(), offset)
+			if err := file.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		s.index(&rec, n, offset, len(line))
+		offset += int64(len(line))
+	}
+	file.size = offset
+	return nil
+}
+
+// openFile opens the file of a day for appending
+func (s *eventStore) openFile(day string) (int, error) {
+	if n, ok := s.fileByDay[day]; ok {
+		return n, nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "events-"+day+".ndjson"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	s.files = append(s.files, &storeFile{day: day, f: f})
+	s.fileByDay[day] = len(s.files) - 1
+	return len(s.files) - 1, nil
+}
+
+// index adds a record's terms to the postings
+func (s *eventStore) index(r *cloudtrailRecord, file int, offset int64, length int) {
+	if s.ids[r.EventID] {
+		return
+	}
+	s.ids[r.EventID] = true
+	n := len(s.events)
+	s.events = append(s.events, storeEntry{id: r.EventID, time: r.eventTime().Unix(), file: file, offset: offset, length: length})
+	seen := map[string]bool{}
+	for _, t := range storeTerms(r) {
+		if !seen[t] {
+			seen[t] = true
+			s.postings[t] = append(s.postings[t], n)
+		}
+	}
+}
+
+func (s *eventStore) send(events []*outputEvent, done func(error)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	touched := map[int]bool{}
+	for _, e := range events {
+		if s.ids[e.Record.EventID] {
+			continue
+		}
+		line, err := json.Marshal(e.Record)
+		if err != nil {
+			done(err)
+			return
+		}
+		line = append(line, '\n')
+		n, err := s.openFile(e.Record.eventTime().Format(storeDateFormat))
+		if err != nil {
+			done(fmt.Errorf("Error writing to event store: %s", err.Error()))
+			return
+		}
+		file := s.files[n]
+		if _, err := file.f.Write(line); err != nil {
+			file.f.Truncate(file.size)
+			done(fmt.Errorf("Error writing to event store: %s", err.Error()))
+			return
+		}
+		s.index(e.Record, n, file.size, len(line))
+		file.size += int64(len(line))
+		touched[n] = true
+	}
+	for n := range touched {
+		if err := s.files[n].f.Sync(); err != nil {
+			done(fmt.Errorf("Error syncing event store: %s", err.Error()))
+			return
+		}
+	}
+	done(nil)
+}
+
+// storeTerms lists the "field:value" terms a record can be found by, plus "text:" words
+func storeTerms(r *cloudtrailRecord) []string {
+	fields := map[string][]string{
+		"event":     {r.EventName},
+		"source":    {r.EventSource},
+		"ip":        {r.SourceIPAddress},
+		"account":   {r.RecipientAccountId},
+		"recipient": {r.RecipientAccountId}, // only the account the event was delivered to, for scopes
+	}
+	fields["principal"] = append(fields["principal"], principalName(r.UserIdentity))
+	for _, k := range []string{"arn", "userName", "principalId", "accessKeyId"} {
+		if v, ok := r.UserIdentity[k].(string); ok {
+			fields["principal"] = append(fields["principal"], v)
+		}
+	}
+	if v, ok := r.UserIdentity["accountId"].(string); ok {
+		fields["account"] = append(fields["account"], v)
+	}
+	fields["resource"] = storeResources(r.RequestParameters, nil)
+
+	terms := []string{}
+	for field, values := range fields {
+		for _, v := range values {
+			if len(v) < 1 {
+				continue
+			}
+			v = strings.ToLower(v)
+			terms = append(terms, field+":"+v)
+			for _, w := range storeTokens(v) {
+				terms = append(terms, "text:"+w)
+			}
+		}
+	}
+	for _, v := range []string{r.ErrorCode, r.UserAgent} {
+		for _, w := range storeTokens(strings.ToLower(v)) {
+			terms = append(terms, "text:"+w)
+		}
+	}
+	return terms
+}
+
+// storeResources collects request parameters which name a resource, such as ARNs, bucketName
+// or instanceId
+func storeResources(v interface{}, found []string) []string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if s, ok := child.(string); ok {
+				k = strings.ToLower(k)
+				if strings.HasPrefix(s, "arn:") || strings.HasSuffix(k, "name") || strings.HasSuffix(k, "id") || strings.HasSuffix(k, "arn") {
+					found = append(found, s)
+				}
+				continue
+			}
+			found = storeResources(child, found)
+		}
+	case []interface{}:
+		for _, child := range v {
+			if s, ok := child.(string); ok && strings.HasPrefix(s, "arn:") {
+				found = append(found, s)
+				continue
+			}
+			found = storeResources(child, found)
+		}
+	}
+	return found
+}
+
+// storeTokens splits text into lower case words for full-text search
+func storeTokens(s string) []string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	tokens := []string{}
+	for _, w := range words {
+		if len(w) >= storeMinTokenChars {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+// search finds the newest matching records, returning them and the number of matches
+func (s *eventStore) search(q storeQuery) ([]json.RawMessage, int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var matches []int
+	all := true
+	for field, value := range q.Terms {
+		matches, all = s.intersect(matches, all, s.lookup(field+":"+strings.ToLower(value)))
+	}
+	for _, w := range storeTokens(strings.ToLower(q.Text)) {
+		matches, all = s.intersect(matches, all, s.postings["text:"+w])
+	}
+	if q.Scope != nil {
+		matches, all = s.intersect(matches, all, s.scoped(q.Scope))
+	}
+	if all {
+		matches = make([]int, len(s.events))
+		for n := range matches {
+			matches[n] = n
+		}
+	}
+
+	found := []int{}
+	for _, n := range matches {
+		e := s.events[n]
+		if s.files[e.file].deleted || (!q.From.IsZero() && e.time < q.From.Unix()) || (!q.To.IsZero() && e.time > q.To.Unix()) {
+			continue
+		}
+		found = append(found, n)
+	}
+	sort.Slice(found, func(i, j int) bool { return s.events[found[i]].time > s.events[found[j]].time })
+	total := len(found)
+	if len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+
+	records := []json.RawMessage{}
+	for _, n := range found {
+		e := s.events[n]
+		b := make([]byte, e.length)
+		if _, err := s.files[e.file].f.ReadAt(b, e.offset); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, json.RawMessage(strings.TrimSpace(string(b))))
+	}
+	return records, total, nil
+}
+
+// lookup finds the postings of a term, or the union of every term with a prefix ending in "*"
+func (s *eventStore) lookup(term string) []int {
+	if !strings.HasSuffix(term, "*") {
+		return s.postings[term]
+	}
+	prefix := strings.TrimSuffix(term, "*")
+	union := map[int]bool{}
+	for t, postings := range s.postings {
+		if strings.HasPrefix(t, prefix) {
+			for _, n := range postings {
+				union[n] = true
+			}
+		}
+	}
+	return storeSorted(union)
+}
+
+// scoped lists the events granted by any role of a scope
+func (s *eventStore) scoped(scope *dataScope) []int {
+	union := map[int]bool{}
+	for _, r := range scope.roles {
+		var matches []int
+		all := true
+		if !r.allAccounts() {
+			matches, all = s.intersect(matches, all, s.anyTerm("recipient", r.Accounts))
+		}
+		if len(r.EventSources) > 0 {
+			matches, all = s.intersect(matches, all, s.anyTerm("source", r.EventSources))
+		}
+		for _, n := range matches {
+			union[n] = true
+		}
+	}
+	return storeSorted(union)
+}
+
+// anyTerm is the union of the postings of a field's values
+func (s *eventStore) anyTerm(field string, values []string) []int {
+	union := map[int]bool{}
+	for _, v := range values {
+		for _, n := range s.postings[field+":"+strings.ToLower(v)] {
+			union[n] = true
+		}
+	}
+	return storeSorted(union)
+}
+
+// storeSorted turns a set of events into an ascending posting list
+func storeSorted(set map[int]bool) []int {
+	list := make([]int, 0, len(set))
+	for n := range set {
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	return list
+}
+
+// intersect narrows the matches so far (all of them, at first) to an ascending posting list
+func (s *eventStore) intersect(matches []int, all bool, postings []int) ([]int, bool) {
+	if all {
+		return postings, false
+	}
+	both := []int{}
+	i, j := 0, 0
+	for i < len(matches) && j < len(postings) {
+		if matches[i] == postings[j] {
+			both = append(both, matches[i])
+			i++
+			j++
+		} else if matches[i] < postings[j] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return both, false
+}
+
+// expire deletes the files of days before the cutoff, following the retention policy
+func (s *eventStore) expire(cutoff time.Time, dryRun bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deleted := false
+	for _, file := range s.files {
+		day, _ := time.Parse(storeDateFormat, file.day)
+		if file.deleted || !day.Before(cutoff) {
+			continue
+		} else if dryRun {
+			log.Printf("Retention dry run: would delete event store file %s", file.f.Name())
+			continue
+		}
+		file.f.Close()
+		if err := os.Remove(file.f.Name()); err != nil {
+			log.Printf("Error deleting event store file %s: %s", file.f.Name(), err.Error())
+			continue
+		}
+		file.deleted = true
+		deleted = true
+		delete(s.fileByDay, file.day)
+		log.Printf("Retention: deleted event store file %s", file.f.Name())
+	}
+	if deleted {
+		s.prune()
+	}
+}
+
+// prune drops the events of deleted files from the index, renumbering the rest
+func (s *eventStore) prune() {
+	ordinals := make([]int, len(s.events)) // old ordinal to new, or -1 if deleted
+	events := []storeEntry{}
+	for n, e := range s.events {
+		if s.files[e.file].deleted {
+			ordinals[n] = -1
+			delete(s.ids, e.id)
+			continue
+		}
+		ordinals[n] = len(events)
+		events = append(events, e)
+	}
+	s.events = events
+	for term, postings := range s.postings {
+		kept := postings[:0]
+		for _, n := range postings {
+			if ordinals[n] >= 0 {
+				kept = append(kept, ordinals[n])
+			}
+		}
+		if len(kept) < 1 {
+			delete(s.postings, term)
+		} else {
+			s.postings[term] = kept
+		}
+	}
+}
+
+// searchHandler serves the query API: GET /api/events?principal=&event=&source=&ip=&account=
+// &resource=&q=&from=&to=&limit=
+func (s *eventStore) searchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := storeQuery{Terms: map[string]string{}, Text: params.Get("q"), Limit: storeDefaultLimit, Scope: requestScope(r)}
+	for _, field := range storeFields {
+		if v := params.Get(field); len(v) > 0 {
+			q.Terms[field] = v
+		}
+	}
+	var err error
+	if v := params.Get("from"); len(v) > 0 {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid from time, must be RFC 3339", 400)
+			return
+		}
+	}
+	if v := params.Get("to"); len(v) > 0 {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid to time, must be RFC 3339", 400)
+			return
+		}
+	}
+	if v := params.Get("limit"); len(v) > 0 {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > storeMaxLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, must be 1 to %d", storeMaxLimit), 400)
+			return
+		}
+	}
+
+	records, total, err := s.search(q)
+	if err != nil {
+		log.Printf("Event store search error: %s", err.Error())
+		http.Error(w, "Search failed", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "events": records})
+}
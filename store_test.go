@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func storeEvent(id, eventTime, name string) *outputEvent {
+	return &outputEvent{Record: &cloudtrailRecord{
+		EventID:            id,
+		EventTime:          eventTime,
+		EventName:          name,
+		EventSource:        "ec2.amazonaws.com",
+		RecipientAccountId: "123456789012",
+	}}
+}
+
+func TestStoreExpirePrunesIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traildash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newEventStore(&config{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sendErr error
+	s.send([]*outputEvent{
+		storeEvent("old-1", "2024-01-01T10:00:00Z", "TerminateInstances"),
+		storeEvent("old-2", "2024-01-01T11:00:00Z", "RunInstances"),
+		storeEvent("new-1", "2024-01-03T10:00:00Z", "RunInstances"),
+	}, func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+
+	s.expire(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), false)
+	if len(s.events) != 1 || len(s.ids) != 1 || !s.ids["new-1"] {
+		t.Errorf("%d events and ids %v left, want only new-1", len(s.events), s.ids)
+	}
+	if _, ok := s.postings["event:terminateinstances"]; ok {
+		t.Errorf("postings of expired events left behind")
+	}
+	for term, postings := range s.postings {
+		for _, n := range postings {
+			if n >= len(s.events) {
+				t.Errorf("%s points at event %d of %d", term, n, len(s.events))
+			}
+		}
+	}
+
+	records, total, err := s.search(storeQuery{Terms: map[string]string{"event": "RunInstances"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Fatalf("%d RunInstances events found, want 1", total)
+	}
+	var rec cloudtrailRecord
+	if err := json.Unmarshal(records[0], &rec); err != nil || rec.EventID != "new-1" {
+		t.Errorf("found %s, want new-1", records[0])
+	}
+
+	// an expired event which is delivered again is stored again
+	s.send([]*outputEvent{storeEvent("old-1", "2024-01-01T10:00:00Z", "TerminateInstances")}, func(err error) { sendErr = err })
+	if sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	if _, total, _ := s.search(storeQuery{Terms: map[string]string{"event": "TerminateInstances"}, Limit: 10}); total != 1 {
+		t.Errorf("%d redelivered events found, want 1", total)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogFacility    = 16 // local0
+	syslogSevInfo     = 6
+	syslogSevWarning  = 4
+	syslogDialTimeout = 10 * time.Second
+)
+
+// syslogOutput forwards events to a SIEM as RFC 5424 syslog messages over UDP, TCP or TLS.
+// Stream transports use octet-counting framing (RFC 6587/5425).
+type syslogOutput struct {
+	c        *config
+	addr     string
+	protocol string // "udp", "tcp" or "tls"
+	format   string // "cef", "leef" or "json"
+	tls      *tls.Config
+	hostname string
+	lock     sync.Mutex
+	conn     net.Conn
+	pending  chan struct{} // limits files being sent at once
+}
+
+// syslogFormatMap maps SYSLOG_FORMAT to a function rendering the message of an event
+var syslogFormatMap = map[string]func(*outputEvent) string{
+	"cef":  cefMessage,
+	"leef": leefMessage,
+	"json": func(e *outputEvent) string { return string(e.Doc) },
+}
+
+func newSyslogOutputFromEnv(c *config) (*syslogOutput, error) {
+	o := &syslogOutput{
+		c:        c,
+		addr:     os.Getenv("SYSLOG_ADDR"),
+		protocol: os.Getenv("SYSLOG_PROTOCOL"),
+		format:   os.Getenv("SYSLOG_FORMAT"),
+		pending:  make(chan struct{}, maxPendingFiles),
+	}
+	if len(o.addr) < 1 {
+		return nil, fmt.Errorf("Must set SYSLOG_ADDR for the syslog output.")
+	}
+	if len(o.protocol) < 1 {
+		o.protocol = "udp"
+	}
+	if len(o.format) < 1 {
+		o.format = "cef"
+	}
+	switch o.protocol {
+	case "udp", "tcp":
+	case "tls":
+		o.tls = &tls.Config{}
+		if len(os.Getenv("SYSLOG_CA_FILE")) > 0 {
+			pem, err := ioutil.ReadFile(os.Getenv("SYSLOG_CA_FILE"))
+			if err != nil {
+				return nil, fmt.Errorf("Error reading SYSLOG_CA_FILE: %s", err.Error())
+			}
+			o.tls.RootCAs = x509.NewCertPool()
+			if !o.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("No certificates found in SYSLOG_CA_FILE %s", os.Getenv("SYSLOG_CA_FILE"))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid SYSLOG_PROTOCOL.  Must be one of 'udp', 'tcp' or 'tls'.")
+	}
+	if _, ok := syslogFormatMap[o.format]; !ok {
+		return nil, fmt.Errorf("Invalid SYSLOG_FORMAT.  Must be one of 'cef', 'leef' or 'json'.")
+	}
+	o.hostname, _ = os.Hostname()
+	if len(o.hostname) < 1 {
+		o.hostname = "-"
+	}
+	return o, nil
+}
+
+func (o *syslogOutput) send(events []*outputEvent, done func(error)) {
+	o.pending <- struct{}{}
+	go func() {
+		err := o.sendAll(events)
+		<-o.pending
+		if err != nil {
+			err = fmt.Errorf("Error sending to syslog %s: %s", o.addr, err.Error())
+		}
+		done(err)
+	}()
+}
+
+// sendAll writes the messages of one file, reconnecting once if the connection has dropped
+func (o *syslogOutput) sendAll(events []*outputEvent) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, e := range events {
+		msg := o.message(e)
+		if err := o.write(msg); err != nil {
+			log.Printf("Syslog connection to %s failed, reconnecting: %s", o.addr, err.Error())
+			o.close()
+			if err := o.write(msg); err != nil {
+				o.close()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// message renders an RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (o *syslogOutput) message(e *outputEvent) []byte {
+	severity := syslogSevInfo
+	if e.Record.failed() {
+		severity = syslogSevWarning
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s traildash - cloudtrail - %s",
+		syslogFacility*8+severity,
+		e.Record.eventTime().Format("2006-01-02T15:04:05.000Z07:00"),
+		o.hostname,
+		syslogFormatMap[o.format](e))
+	if o.protocol == "udp" {
+		return []byte(msg)
+	}
+	return []byte(strconv.Itoa(len(msg)) + " " + msg)
+}
+
+// write sends one message, connecting first if needed
+func (o *syslogOutput) write(msg []byte) error {
+	if o.conn == nil {
+		var err error
+		dialer := &net.Dialer{Timeout: syslogDialTimeout}
+		if o.protocol == "tls" {
+			o.conn, err = tls.DialWithDialer(dialer, "tcp", o.addr, o.tls)
+		} else {
+			o.conn, err = dialer.Dial(o.protocol, o.addr)
+		}
+		if err != nil {
+			o.conn = nil
+			return err
+		}
+	}
+	o.conn.SetWriteDeadline(time.Now().Add(syslogDialTimeout))
+	_, err := o.conn.Write(msg)
+	return err
+}
+
+func (o *syslogOutput) close() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+}
+
+// syslogName is the event name, or the event type for events without one such as Insights
+func syslogName(r *cloudtrailRecord) string {
+	if len(r.EventName) > 0 {
+		return r.EventName
+	}
+	return r.EventType
+}
+
+// syslogOutcome is "success" or "failure"
+func syslogOutcome(r *cloudtrailRecord) string {
+	if r.failed() {
+		return "failure"
+	}
+	return "success"
+}
+
+// cefMessage maps a record to ArcSight Common Event Format
+func cefMessage(e *outputEvent) string {
+	r := e.Record
+	severity := "3"
+	if r.failed() {
+		severity = "6"
+	}
+	ext := [][2]string{
+		{"rt", strconv.FormatInt(r.eventTime().UnixNano()/int64(time.Millisecond), 10)},
+		{"act", syslogName(r)},
+		{"outcome", syslogOutcome(r)},
+		{"suser", principalName(r.UserIdentity)},
+		{"externalId", r.EventID},
+		{"requestClientApplication", r.UserAgent},
+	}
+	for n, cs := range [][2]string{{"eventSource", r.EventSource}, {"awsRegion", r.AwsRegion}, {"recipientAccountId", r.RecipientAccountId}} {
+		if len(cs[1]) > 0 {
+			ext = append(ext, [2]string{fmt.Sprintf("cs%d", n+1), cs[1]}, [2]string{fmt.Sprintf("cs%dLabel", n+1), cs[0]})
+		}
+	}
+	if net.ParseIP(r.SourceIPAddress) != nil {
+		ext = append(ext, [2]string{"src", r.SourceIPAddress})
+	} else if len(r.SourceIPAddress) > 0 { // an AWS service such as "ec2.amazonaws.com"
+		ext = append(ext, [2]string{"shost", r.SourceIPAddress})
+	}
+	if len(r.ErrorCode) > 0 || len(r.ErrorMessage) > 0 {
+		ext = append(ext, [2]string{"reason", strings.TrimSpace(r.ErrorCode + " " + r.ErrorMessage)})
+	}
+
+	fields := []string{}
+	for _, kv := range ext {
+		if len(kv[1]) > 0 {
+			fields = append(fields, kv[0]+"="+cefEscapeValue(kv[1]))
+		}
+	}
+	return strings.Join([]string{
+		"CEF:0", "Amazon", "CloudTrail", cefEscapeHeader(r.EventVersion),
+		cefEscapeHeader(syslogName(r)), cefEscapeHeader(syslogName(r)), severity,
+		strings.Join(fields, " "),
+	}, "|")
+}
+
+func cefEscapeHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+func cefEscapeValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// leefMessage maps a record to IBM QRadar Log Event Extended Format 1.0
+func leefMessage(e *outputEvent) string {
+	r := e.Record
+	severity := "3"
+	if r.failed() {
+		severity = "6"
+	}
+	attrs := [][2]string{
+		{"devTime", r.eventTime().Format("Jan 02 2006 15:04:05.000 MST")},
+		{"devTimeFormat", "MMM dd yyyy HH:mm:ss.SSS z"},
+		{"cat", r.EventSource},
+		{"sev", severity},
+		{"usrName", principalName(r.UserIdentity)},
+		{"action", syslogName(r)},
+		{"outcome", syslogOutcome(r)},
+		{"eventSource", r.EventSource},
+		{"awsRegion", r.AwsRegion},
+		{"recipientAccountId", r.RecipientAccountId},
+		{"eventId", r.EventID},
+		{"userAgent", r.UserAgent},
+		{"errorCode", r.ErrorCode},
+	}
+	if net.ParseIP(r.SourceIPAddress) != nil {
+		attrs = append(attrs, [2]string{"src", r.SourceIPAddress})
+	} else if len(r.SourceIPAddress) > 0 {
+		attrs = append(attrs, [2]string{"srcHost", r.SourceIPAddress})
+	}
+
+	fields := []string{}
+	for _, kv := range attrs {
+		if len(kv[1]) > 0 {
+			fields = append(fields, kv[0]+"="+leefEscape(kv[1]))
+		}
+	}
+	return strings.Join([]string{
+		"LEEF:1.0", "Amazon", "CloudTrail", leefEscapeHeader(r.EventVersion), leefEscapeHeader(syslogName(r)),
+		strings.Join(fields, "\t"),
+	}, "|")
+}
+
+// leefEscapeHeader escapes the "|" which separates header fields
+func leefEscapeHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// leefEscape keeps attribute values on one line without the tab which separates attributes
+func leefEscape(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
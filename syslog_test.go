@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCEFEscape(t *testing.T) {
+	tests := []struct {
+		in     string
+		header string
+		value  string
+	}{
+		{"plain", "plain", "plain"},
+		{`a\b`, `a\\b`, `a\\b`},
+		{"a|b", `a\|b`, "a|b"},
+		{"a=b", "a=b", `a\=b`},
+		{"a\nb\rc", "a b c", `a\nb\rc`},
+		{`\|=`, `\\\|=`, `\\|\=`},
+	}
+	for _, tt := range tests {
+		if got := cefEscapeHeader(tt.in); got != tt.header {
+			t.Errorf("cefEscapeHeader(%q) = %q, want %q", tt.in, got, tt.header)
+		}
+		if got := cefEscapeValue(tt.in); got != tt.value {
+			t.Errorf("cefEscapeValue(%q) = %q, want %q", tt.in, got, tt.value)
+		}
+	}
+}
+
+func TestLEEFEscape(t *testing.T) {
+	tests := []struct {
+		in     string
+		header string
+		value  string
+	}{
+		{"plain", "plain", "plain"},
+		{"a|b", `a\|b`, "a|b"},
+		{`a\b`, `a\\b`, `a\b`},
+		{"a=b", "a=b", "a=b"},
+		{"a\tb", "a b", "a b"},
+		{"a\nb\rc", "a b c", "a b c"},
+	}
+	for _, tt := range tests {
+		if got := leefEscapeHeader(tt.in); got != tt.header {
+			t.Errorf("leefEscapeHeader(%q) = %q, want %q", tt.in, got, tt.header)
+		}
+		if got := leefEscape(tt.in); got != tt.value {
+			t.Errorf("leefEscape(%q) = %q, want %q", tt.in, got, tt.value)
+		}
+	}
+}
+
+// syslogEvent is a failed call with a character in most fields which needs escaping
+func syslogEvent() *outputEvent {
+	return &outputEvent{Record: &cloudtrailRecord{
+		EventVersion:       "1.08|x",
+		EventName:          "Put|Object",
+		EventID:            "id=1",
+		EventSource:        "s3.amazonaws.com",
+		EventTime:          "2024-01-02T03:04:05Z",
+		AwsRegion:          "us-east-1",
+		RecipientAccountId: "123456789012",
+		SourceIPAddress:    "203.0.113.7",
+		UserAgent:          "agent\tv1 a=b\\c|d",
+		ErrorCode:          "AccessDenied",
+		ErrorMessage:       "Access\nDenied",
+		UserIdentity:       map[string]interface{}{"userName": "alice"},
+	}, Doc: []byte(`{"EventName":"PutObject"}`)}
+}
+
+func TestCEFMessage(t *testing.T) {
+	want := `CEF:0|Amazon|CloudTrail|1.08\|x|Put\|Object|Put\|Object|6|` +
+		`rt=1704164645000 act=Put|Object outcome=failure suser=alice externalId=id\=1 ` +
+		`requestClientApplication=agent` + "\t" + `v1 a\=b\\c|d ` +
+		`cs1=s3.amazonaws.com cs1Label=eventSource cs2=us-east-1 cs2Label=awsRegion ` +
+		`cs3=123456789012 cs3Label=recipientAccountId src=203.0.113.7 reason=AccessDenied Access\nDenied`
+	if got := cefMessage(syslogEvent()); got != want {
+		t.Errorf("CEF message\n%s\nwant\n%s", got, want)
+	}
+
+	// an AWS service calling is a host rather than an address
+	e := syslogEvent()
+	e.Record.SourceIPAddress = "ec2.amazonaws.com"
+	if got := cefMessage(e); !strings.Contains(got, " shost=ec2.amazonaws.com") || strings.Contains(got, " src=") {
+		t.Errorf("service call as %s", got)
+	}
+}
+
+func TestLEEFMessage(t *testing.T) {
+	want := `LEEF:1.0|Amazon|CloudTrail|1.08\|x|Put\|Object|` + strings.Join([]string{
+		"devTime=Jan 02 2024 03:04:05.000 UTC",
+		"devTimeFormat=MMM dd yyyy HH:mm:ss.SSS z",
+		"cat=s3.amazonaws.com",
+		"sev=6",
+		"usrName=alice",
+		"action=Put|Object",
+		"outcome=failure",
+		"eventSource=s3.amazonaws.com",
+		"awsRegion=us-east-1",
+		"recipientAccountId=123456789012",
+		"eventId=id=1",
+		`userAgent=agent v1 a=b\c|d`,
+		"errorCode=AccessDenied",
+		"src=203.0.113.7",
+	}, "\t")
+	if got := leefMessage(syslogEvent()); got != want {
+		t.Errorf("LEEF message\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSyslogFraming(t *testing.T) {
+	events := []*outputEvent{syslogEvent(), syslogEvent()}
+	events[1].Record.ErrorCode, events[1].Record.ErrorMessage = "", ""
+	header := []string{
+		"<132>1 2024-01-02T03:04:05.000Z traildash-1 traildash - cloudtrail - ",
+		"<134>1 2024-01-02T03:04:05.000Z traildash-1 traildash - cloudtrail - ",
+	}
+
+	// UDP sends each message in a datagram of its own
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	o := &syslogOutput{addr: pc.LocalAddr().String(), protocol: "udp", format: "json", hostname: "traildash-1"}
+	if err := o.sendAll(events); err != nil {
+		t.Fatal(err)
+	}
+	o.close()
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range events {
+		b := make([]byte, 64*1024)
+		n, _, err := pc.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := header[i] + `{"EventName":"PutObject"}`; string(b[:n]) != want {
+			t.Errorf("datagram %q, want %q", b[:n], want)
+		}
+	}
+
+	// TCP frames each message with its length in octets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		msgs := []string{}
+		for len(msgs) < len(events) {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil {
+				break
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+	o = &syslogOutput{addr: l.Addr().String(), protocol: "tcp", format: "cef", hostname: "traildash-1"}
+	if err := o.sendAll(events); err != nil {
+		t.Fatal(err)
+	}
+	o.close()
+	msgs := <-received
+	if len(msgs) != len(events) {
+		t.Fatalf("%d framed messages, want %d", len(msgs), len(events))
+	}
+	for i, msg := range msgs {
+		if want := header[i] + cefMessage(events[i]); msg != want {
+			t.Errorf("message %q, want %q", msg, want)
+		}
+	}
+}
@@ -12,9 +12,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -39,16 +39,100 @@ AWS credentials are sourced by (in order): Environment Variables, ~/.aws/credent
 
 Optional Environment Variables:
 	AWS_REGION		AWS Region (SQS and S3 regions must match. default: us-east-1).
-	ES_URL			ElasticSearch URL (default: http://localhost:9200).
+	OUTPUTS			Comma separated outputs: "elasticsearch", "file", "splunk", "syslog", "webhook", "kafka", "parquet", "store" (default: elasticsearch).
+	FILE_OUTPUT_DIR		Directory to write gzipped NDJSON files to, for the file output.
+	FILE_MAX_BYTES		Size at which an output file is finished (default: 134217728).
+	FILE_MAX_AGE		Longest time an output file is written to (default: 1h).
+	SPLUNK_HEC_URL		Splunk HTTP Event Collector URL, such as https://splunk:8088, for the splunk output.
+	SPLUNK_HEC_TOKEN	Splunk HEC token.
+	SPLUNK_INDEX		Splunk index (default: the token's default index).
+	SPLUNK_SOURCETYPE	Splunk sourcetype (default: aws:cloudtrail).
+	SPLUNK_ACK		Wait for Splunk indexer acknowledgement before deleting SQS messages.
+	SPLUNK_CA_FILE		PEM file of CA certificates trusted for HTTPS connections to Splunk.
+	SYSLOG_ADDR		host:port of the syslog server, for the syslog output.
+	SYSLOG_PROTOCOL		"udp" (default), "tcp" or "tls".
+	SYSLOG_FORMAT		Message format: "cef" (default), "leef" or "json" (the OUTPUT_FORMAT document).
+	SYSLOG_CA_FILE		PEM file of CA certificates trusted for TLS connections to the syslog server.
+	WEBHOOK_URL		URL to POST events to, for the webhook output.
+	WEBHOOK_HEADERS		JSON object of headers to add to webhook requests, such as {"Authorization": "Bearer abc123"}.
+	WEBHOOK_BATCH_SIZE	Most events in one webhook request (default: 100).
+	WEBHOOK_TEMPLATE	Go template of the request body (default: a JSON array of the events).
+	WEBHOOK_TEMPLATE_FILE	File containing WEBHOOK_TEMPLATE.
+	WEBHOOK_CONTENT_TYPE	Content-Type of webhook requests (default: application/json).
+	WEBHOOK_HMAC_SECRET	Sign webhook requests with HMAC-SHA256 using this secret.
+	WEBHOOK_CA_FILE		PEM file of CA certificates trusted for HTTPS webhook requests.
+	KAFKA_BROKERS		Comma separated host:port of Kafka brokers to bootstrap from, for the kafka output.
+	KAFKA_TOPIC		Topic, or a template of one such as "cloudtrail.{{.RecipientAccountId}}" (default: cloudtrail).
+	KAFKA_COMPRESSION	"none" (default) or "gzip".
+	KAFKA_BATCH_BYTES	Largest record batch sent to a partition at once (default: 1000000).
+	KAFKA_TLS		Connect to the brokers with TLS.
+	KAFKA_CA_FILE		PEM file of CA certificates trusted for TLS connections to Kafka.
+	KAFKA_CLIENT_CERT	PEM client certificate for TLS connections to Kafka.
+	KAFKA_CLIENT_KEY	PEM key for KAFKA_CLIENT_CERT.
+	KAFKA_SASL_MECHANISM	"PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512" to authenticate with SASL.
+	KAFKA_SASL_USERNAME	SASL username.
+	KAFKA_SASL_PASSWORD	SASL password.
+	PARQUET_DIR		Directory of Parquet files, for the parquet output.
+	PARQUET_S3_PREFIX	S3 location of Parquet files instead of PARQUET_DIR (s3://bucket/prefix).
+	PARQUET_MAX_ROWS	Most rows buffered for a partition before it is written out (default: 100000).
+	PARQUET_MAX_AGE		Longest time rows are buffered before they are written out (default: 5m).
+	STORE_DIR		Directory of the embedded event store, for the store output.
+	ES_URL			ElasticSearch URL, or comma separated URLs of several nodes (default: http://localhost:9200).
+	ES_USERNAME		ElasticSearch basic auth username.
+	ES_PASSWORD		ElasticSearch basic auth password.
+	ES_API_KEY		ElasticSearch API key (base64 "id:api_key"), instead of a username and password.
+	ES_CA_FILE		PEM file of CA certificates trusted for HTTPS connections to ElasticSearch.
+	ES_CLIENT_CERT		PEM client certificate for HTTPS connections to ElasticSearch.
+	ES_CLIENT_KEY		PEM key for ES_CLIENT_CERT.
+	ES_AUTH			"basic": use ES_USERNAME/ES_PASSWORD or ES_API_KEY if set (default)
+				"sigv4": sign requests with AWS credentials, for Amazon OpenSearch Service
+	ES_AWS_REGION		AWS Region of the OpenSearch Service domain (default: AWS_REGION).
+	ES_AWS_SERVICE		Service name to sign requests for: "es" or "aoss" for serverless (default: es).
+	ES_VERSION		Cluster version, such as "7.17" or "opensearch-2.11" (default: ask the cluster).
 	WEB_LISTEN		Listen IP and port for HTTP/HTTPS interface (default: 0.0.0.0:7000).
+	WEB_AUTH_USER		User name to log in to the web interface with.
+	WEB_AUTH_PASSWORD	Password of WEB_AUTH_USER.
+	WEB_AUTH_FILE		htpasswd file of users allowed to log in, with bcrypt hashes ("htpasswd -B").
+	WEB_AUTH_MAX_FAILURES	Failed logins in a row before a user or address is locked out (default: 5).
+	WEB_AUTH_LOCKOUT	How long a lockout lasts (default: 15m).
+	OIDC_ISSUER		OpenID Connect provider to log in to the web interface with, such as "https://accounts.google.com".
+	OIDC_CLIENT_ID		Client ID registered with the OIDC provider.
+	OIDC_CLIENT_SECRET	Client secret registered with the OIDC provider.
+	OIDC_REDIRECT_URL	URL of traildash's /auth/callback, as registered with the provider.
+	OIDC_SCOPES		Scopes to request (default: "openid email profile").
+	OIDC_USER_CLAIM		ID token claim to name users by (default: email, falling back to sub).
+	OIDC_GROUPS_CLAIM	ID token or userinfo claim listing the user's groups (default: groups).
+	OIDC_ALLOWED_GROUPS	Comma separated groups allowed to log in (default: anyone the provider accepts).
+	OIDC_SESSION_SECRET	Secret to sign session cookies with (default: random, so sessions end on restart).
+	OIDC_SESSION_TTL	How long a login lasts (default: 8h).
+	OIDC_POST_LOGOUT_URL	Where the provider sends people after logging out of it.
+	WEB_ROLES_FILE		Path to a JSON file of roles limiting which AWS accounts' events each user can see.
 	SSL_MODE		"off": disable HTTPS and use HTTP (default)
 				"custom": use custom key/cert stored stored in ".tdssl/key.pem" and ".tdssl/cert.pem"
 				"selfSigned": use key/cert in ".tdssl", generate an self-signed cert if empty
+	OUTPUT_FORMAT		"cloudtrail": store records with CloudTrail field names (default)
+				"ecs": map records into Elastic Common Schema fields
+				"ocsf": map records into OCSF API Activity and Authentication events
+	FILTER_RULES		Path to a JSON file of include/exclude rules applied before indexing.
+	BULK_MAX_BYTES		Largest ElasticSearch bulk request in bytes (default: 5242880).
+	BULK_MAX_DOCS		Most events in an ElasticSearch bulk request (default: 1000).
+	BULK_FLUSH_INTERVAL	Longest time events wait to be sent to ElasticSearch (default: 5s).
+	DEAD_LETTER_FILE	File to record events ElasticSearch refused to index (default: traildash-dead-letter.json).
+	SPOOL_DIR		Directory to spool downloaded events in until ElasticSearch has them (default: no spool).
+	SPOOL_MAX_BYTES		Largest size of the spool in bytes (default: 1073741824).
+	RETENTION_DAYS		Remove daily indices and store files with events older than this many days (default: keep forever).
+	RETENTION_ACTION	"delete": delete expired indices (default)
+				"close": close expired indices, which can be reopened later
+	RETENTION_DRY_RUN	Only log which indices the retention policy would remove.
 	SQS_PERSIST		Set to prevent deleting of finished SQS messages - for debugging.
 	DEBUG			Enable debugging output.
 `
 
-const esPath = "cloudtrail/event"
+const (
+	esIndex        = "cloudtrail"         // alias of the daily indices
+	esInsightIndex = "cloudtrail_insight" // alias of the daily Insights indices
+	esType         = "event"
+)
 
 type sslModeOption int
 
@@ -68,18 +152,34 @@ var sslModeOptionMap = map[string]sslModeOption{
 }
 
 type config struct {
-	awsKeyId   string
-	awsSecret  string
-	awsConfig  aws.Config
-	region     string
-	queueURL   string
-	esURL      string
-	listen     string
-	authUser   string
-	authPw     string
-	sslMode    sslModeOption
-	debugOn    bool
-	sqsPersist bool
+	awsKeyId       string
+	awsSecret      string
+	awsConfig      aws.Config
+	region         string
+	queueURL       string
+	esURL          string
+	es             *esClient
+	listen         string
+	authUser       string
+	authPw         string
+	auth           *webAuth
+	oidc           *oidcAuth
+	roles          *webRoles
+	sslMode        sslModeOption
+	format         documentFormatter
+	formatName     string
+	filters        *filterRules
+	legacyIdx      bool
+	esVersion      esVersion
+	retention      *retentionPolicy
+	spool          *spool
+	outputs        []output
+	esEnabled      bool
+	store          *eventStore
+	deadLetterFile string
+	indexer        *indexer
+	debugOn        bool
+	sqsPersist     bool
 }
 
 type sqsNotification struct {
@@ -117,19 +217,52 @@ type cloudtrailRecord struct {
 	AwsRegion          string
 	RequestID          string
 	RecipientAccountId string
+	ErrorCode          string `json:",omitempty"`
+	ErrorMessage       string `json:",omitempty"`
+	ReadOnly           *bool  `json:",omitempty"`
+	EventCategory      string `json:",omitempty"`
+	SharedEventID      string `json:",omitempty"`
 	UserIdentity       map[string]interface{}
 	RequestParameters  map[string]interface{}
+	InsightDetails     *insightDetails `json:",omitempty"`
 	//ResponseElements   string
+	Provenance *provenance `json:",omitempty"`
+}
+
+// provenance records where an indexed event came from, for chain of custody
+type provenance struct {
+	S3Bucket         string
+	S3ObjectKey      string
+	S3ETag           string
+	S3VersionID      string `json:",omitempty"`
+	SQSMessageID     string
+	IngestTime       string
+	TraildashVersion string
 }
 
 func main() {
 	c, err := parseArgs()
 	if err != nil {
 		fmt.Printf("Error parsing arguments: %s\n\n", err.Error())
-		fmt.Println(usage)
+		fmt.Print(usage)
 		os.Exit(1)
 	}
 
+	if c.esEnabled {
+		go c.es.healthLoop()
+		if c.spool != nil { // events are spooled until ElasticSearch is ready
+			go c.startES()
+		} else {
+			c.startES()
+		}
+	}
+
+	if c.retention != nil {
+		go c.retentionLoop()
+	}
+	if c.filters != nil {
+		go c.filters.reportLoop()
+	}
 	go c.workLogs()
 	go c.serveKibana()
 
@@ -145,12 +278,39 @@ func main() {
 	log.Print("Exiting!")
 }
 
+// startES sets up the ElasticSearch indices, waiting for ElasticSearch if need be, then starts
+// indexing
+func (c *config) startES() {
+	for {
+		if err := c.setupIndices(); err != nil {
+			kerblowie("Error setting up ElasticSearch indices: %s", err.Error())
+			continue
+		}
+		break
+	}
+	go c.indexer.run()
+	if c.spool != nil {
+		go c.spool.replay()
+	}
+}
+
 // serveKibana runs a webserver for 1. kibana and 2. elasticsearch proxy
 func (c *config) serveKibana() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		webStaticHandler(w, r)
-	})
-	http.HandleFunc("/es/", c.proxyHandler)
+	protect := c.auth.wrap
+	if c.oidc != nil {
+		protect = c.oidc.wrap
+		http.HandleFunc("/auth/login", c.oidc.loginHandler)
+		http.HandleFunc("/auth/callback", c.oidc.callbackHandler)
+		http.HandleFunc("/auth/logout", c.oidc.logoutHandler)
+	} else if c.auth == nil {
+		log.Printf("WARNING: the web interface on %s has no authentication.  Set WEB_AUTH_USER, WEB_AUTH_FILE or OIDC_ISSUER.", c.listen)
+	}
+	http.HandleFunc("/", protect(webStaticHandler))
+	http.HandleFunc("/es/", protect(c.roles.wrap(c.proxyHandler)))
+	http.HandleFunc("/status", protect(c.statusHandler))
+	if c.store != nil {
+		http.HandleFunc("/api/events", protect(c.roles.wrap(c.store.searchHandler)))
+	}
 	if c.sslMode == SSLoff {
 		http.ListenAndServe(c.listen, nil)
 	} else {
@@ -184,13 +344,6 @@ func webStaticHandler(w http.ResponseWriter, r *http.Request) {
 
 // proxyHandler securely proxies requests to the ElasticSearch instance
 func (c *config) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	u, err := url.Parse(c.esURL)
-	if err != nil {
-		log.Printf("URL err: %s", err.Error())
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
 	if firewallES(r) {
 		c.debug("Permitting ES %s request: %s", r.Method, r.RequestURI)
 	} else {
@@ -199,21 +352,44 @@ func (c *config) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := &http.Client{}
-	req := r
-	req.RequestURI = ""
-	req.Host = u.Host
-	req.URL.Host = req.Host
-	req.URL.Scheme = u.Scheme
-	req.URL.Path = strings.TrimPrefix(req.URL.Path, "/es")
+	// searches by people with a scoped role only see the events of their accounts
+	var body io.Reader = r.Body
+	if scope := requestScope(r); scope != nil {
+		var err error
+		if body, err = c.roles.scopeESRequest(r, scope, c.esVersion); err != nil {
+			logScopeRefusal(r, err)
+			http.Error(w, "Permission denied: "+err.Error(), 403)
+			return
+		}
+	}
 
-	resp, err := client.Do(req)
+	path := c.legacyIndexPath(strings.TrimPrefix(r.URL.Path, "/es"))
+	if len(r.URL.RawQuery) > 0 {
+		path += "?" + r.URL.RawQuery
+	}
+	req, err := c.es.newRequest(r.Method, path, body)
+	if err != nil {
+		log.Printf("URL err: %s", err.Error())
+		http.Error(w, "Bad request", 400)
+		return
+	}
+	for _, h := range []string{"Content-Type", "Accept", "Accept-Encoding"} {
+		if v := r.Header.Get(h); len(v) > 0 {
+			req.Header.Set(h, v)
+		}
+	}
+
+	// the browser's own Authorization and Cookie headers are never forwarded, and
+	// ElasticSearch's credentials are added by c.es
+	resp, err := c.es.do(req)
 	if err != nil {
-		c.debug("Proxy err: %s", err.Error())
-		http.Error(w, err.Error(), 500)
+		log.Printf("Proxy err: %s", err.Error())
+		http.Error(w, "ElasticSearch unavailable", 502)
 		return
 	}
 	copyHeaders(w.Header(), resp.Header)
+	w.Header().Del("Www-Authenticate") // don't prompt browsers for ElasticSearch credentials
+	w.Header().Del("Set-Cookie")
 	w.WriteHeader(resp.StatusCode)
 	_, err = io.Copy(w, resp.Body)
 	if err := resp.Body.Close(); err != nil {
@@ -222,6 +398,21 @@ func (c *config) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	//c.debug("Copied %v bytes to client error=%v", nr, err)
 }
 
+// statusHandler reports the state of each ElasticSearch node as JSON, if ElasticSearch is an output
+func (c *config) statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{}
+	if c.esEnabled {
+		status["elasticsearch"] = map[string]interface{}{
+			"version": c.esVersion.String(),
+			"nodes":   c.es.status(),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		c.debug("Error writing status: %s", err.Error())
+	}
+}
+
 // firewallES provides a basic "firewall" for ElasticSearch
 func firewallES(r *http.Request) bool {
 	switch r.Method {
@@ -229,7 +420,7 @@ func firewallES(r *http.Request) bool {
 		return true
 	case "POST":
 		parts := strings.SplitN(r.RequestURI, "?", 2)
-		if strings.HasSuffix(parts[0], "_search") {
+		if strings.HasSuffix(parts[0], "_search") || strings.HasSuffix(parts[0], "_msearch") {
 			return true
 		}
 	case "PUT":
@@ -267,25 +458,42 @@ func (c *config) workLogs() {
 		}
 		c.debug("Downloaded %d records from sqs://%s [s3://%s/%s]", len(*records), m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
 
-		// load into elasticsearch
-		if err = c.load(records); err != nil {
-			kerblowie("Error uploading to ElasticSearch: %s", err.Error())
+		// drop records excluded by filter rules
+		if c.filters != nil {
+			total := len(*records)
+			records = c.filters.filter(records)
+			c.debug("Filtered out %d of %d records from sqs://%s", total-len(*records), total, m.MessageID)
+		}
+
+		// load into the outputs, finishing the message once every record is confirmed
+		n := len(*records)
+		if err = c.load(records, func(err error) { c.finish(m, n, err) }); err != nil {
+			kerblowie("Error loading CloudTrail file: %s", err.Error())
 			continue
 		}
-		c.debug("Uploaded sqs://%s [s3://%s/%s] to es://%s", m.MessageID, m.S3Bucket, m.S3ObjectKey[0], esPath)
+	}
+}
 
-		// delete message from sqs
-		if c.sqsPersist {
-			c.debug("NOT DELETING sqs://%s [s3://%s/%s]", m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
-		} else {
-			if err = c.deleteSQS(m); err != nil {
-				kerblowie("Error deleting from SQS queue: %s", err.Error())
-				continue
-			}
-			c.debug("Deleted sqs://%s [s3://%s/%s]", m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
+// finish deletes a message from SQS once its records are loaded.  Messages which failed
+// reappear on the queue after the visibility timeout and are retried.
+func (c *config) finish(m *cloudtrailNotification, n int, err error) {
+	if err != nil {
+		log.Printf("Error loading CloudTrail file: %s", err.Error())
+		return
+	}
+	c.debug("Stored sqs://%s [s3://%s/%s]", m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
+
+	// delete message from sqs
+	if c.sqsPersist {
+		c.debug("NOT DELETING sqs://%s [s3://%s/%s]", m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
+	} else {
+		if err = c.deleteSQS(m); err != nil {
+			log.Printf("Error deleting from SQS queue: %s", err.Error())
+			return
 		}
-		log.Printf("Loaded CloudTrail file with %d records.", len(*records))
+		c.debug("Deleted sqs://%s [s3://%s/%s]", m.MessageID, m.S3Bucket, m.S3ObjectKey[0])
 	}
+	log.Printf("Loaded CloudTrail file with %d records.", n)
 }
 
 // dequeue fetches an item from SQS
@@ -355,34 +563,38 @@ func (c *config) download(m *cloudtrailNotification) (*[]cloudtrailRecord, error
 		return nil, fmt.Errorf("Error unmarshaling cloutrail JSON: %s", err.Error())
 	}
 
+	p := &provenance{
+		S3Bucket:         m.S3Bucket,
+		S3ObjectKey:      m.S3ObjectKey[0],
+		SQSMessageID:     m.MessageID,
+		IngestTime:       time.Now().UTC().Format(time.RFC3339),
+		TraildashVersion: version,
+	}
+	if o.ETag != nil {
+		p.S3ETag = strings.Trim(*o.ETag, `"`)
+	}
+	if o.VersionID != nil {
+		p.S3VersionID = *o.VersionID
+	}
+	for i := range logfile.Records {
+		logfile.Records[i].Provenance = p
+	}
+
 	return &logfile.Records, nil
 }
 
-// load stores a group of cloudtrail records into ElasticSearch
-func (c *config) load(records *[]cloudtrailRecord) error {
-	bulk := ""
-	for _, r := range *records { // build file for bulk upload to ES
-		j, err := json.Marshal(r)
+// load queues a group of cloudtrail records for the outputs, calling done when they are stored
+func (c *config) load(records *[]cloudtrailRecord, done func(error)) error {
+	events := make([]*outputEvent, 0, len(*records))
+	for n := range *records {
+		r := &(*records)[n]
+		j, err := json.Marshal(c.format(r))
 		if err != nil {
 			return err
 		}
-		bulk += fmt.Sprintf(`{ "index": { "_id" : "%s" }}`+"\n", r.EventID)
-		bulk += string(j) + "\n"
-	}
-	url := fmt.Sprintf("%s/%s/_bulk", c.esURL, esPath)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(bulk)))
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.Status != "200 OK" {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Error response from Elasticsearch: %s %s", resp.Status, string(body))
+		events = append(events, &outputEvent{Record: r, Doc: j})
 	}
+	c.send(events, done)
 	return nil
 }
 
@@ -416,7 +628,7 @@ func parseArgs() (*config, error) {
 		fmt.Println(version)
 		os.Exit(0)
 	} else if helpPtr {
-		fmt.Println(usage)
+		fmt.Print(usage)
 		os.Exit(0)
 	}
 
@@ -435,14 +647,129 @@ func parseArgs() (*config, error) {
 	if len(c.esURL) < 1 {
 		c.esURL = "http://127.0.0.1:9200"
 	}
+	esOptions := esClientOptions{
+		URL:        c.esURL,
+		Username:   os.Getenv("ES_USERNAME"),
+		Password:   os.Getenv("ES_PASSWORD"),
+		APIKey:     os.Getenv("ES_API_KEY"),
+		CAFile:     os.Getenv("ES_CA_FILE"),
+		ClientCert: os.Getenv("ES_CLIENT_CERT"),
+		ClientKey:  os.Getenv("ES_CLIENT_KEY"),
+	}
+	switch os.Getenv("ES_AUTH") {
+	case "", "basic":
+	case "sigv4":
+		esRegion := os.Getenv("ES_AWS_REGION")
+		if len(esRegion) < 1 {
+			esRegion = c.region
+		}
+		esService := os.Getenv("ES_AWS_SERVICE")
+		if len(esService) < 1 {
+			esService = "es"
+		}
+		esOptions.Signer = newSigV4Signer(&c.awsConfig, esRegion, esService)
+	default:
+		return nil, fmt.Errorf("Invalid ES_AUTH.  Must be one of 'basic' or 'sigv4'.")
+	}
+	es, err := newESClient(esOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.es = es
+	if len(os.Getenv("ES_VERSION")) > 0 {
+		if c.esVersion, err = parseESVersion(os.Getenv("ES_VERSION")); err != nil {
+			return nil, fmt.Errorf("Invalid ES_VERSION.  Must be a version such as '7.17' or 'opensearch-2.11'.")
+		}
+	}
 
 	c.listen = os.Getenv("WEB_LISTEN")
 	if len(c.listen) < 1 {
 		c.listen = "0.0.0.0:7000"
 	}
+	c.authUser = os.Getenv("WEB_AUTH_USER")
+	c.authPw = os.Getenv("WEB_AUTH_PASSWORD")
+	if c.auth, err = newWebAuthFromEnv(&c); err != nil {
+		return nil, err
+	}
+	if c.oidc, err = newOIDCAuthFromEnv(&c); err != nil {
+		return nil, err
+	}
 	if len(os.Getenv("DEBUG")) > 0 {
 		c.debugOn = true
 	}
+	c.formatName = "cloudtrail"
+	if len(os.Getenv("OUTPUT_FORMAT")) > 0 {
+		c.formatName = os.Getenv("OUTPUT_FORMAT")
+	}
+	var ok bool
+	if c.format, ok = outputFormatMap[c.formatName]; !ok {
+		return nil, fmt.Errorf("Invalid OUTPUT_FORMAT.  Must be one of 'cloudtrail', 'ecs' or 'ocsf'.")
+	}
+	c.deadLetterFile = os.Getenv("DEAD_LETTER_FILE")
+	if len(c.deadLetterFile) < 1 {
+		c.deadLetterFile = "traildash-dead-letter.json"
+	}
+	maxBytes, maxDocs, flushInterval := defaultBulkMaxBytes, defaultBulkMaxDocs, defaultBulkFlushInterval
+	if len(os.Getenv("BULK_MAX_BYTES")) > 0 {
+		if maxBytes, err = strconv.Atoi(os.Getenv("BULK_MAX_BYTES")); err != nil || maxBytes < 1 {
+			return nil, fmt.Errorf("Invalid BULK_MAX_BYTES.  Must be a positive number of bytes.")
+		}
+	}
+	if len(os.Getenv("BULK_MAX_DOCS")) > 0 {
+		if maxDocs, err = strconv.Atoi(os.Getenv("BULK_MAX_DOCS")); err != nil || maxDocs < 1 {
+			return nil, fmt.Errorf("Invalid BULK_MAX_DOCS.  Must be a positive number of events.")
+		}
+	}
+	if len(os.Getenv("BULK_FLUSH_INTERVAL")) > 0 {
+		if flushInterval, err = time.ParseDuration(os.Getenv("BULK_FLUSH_INTERVAL")); err != nil || flushInterval <= 0 {
+			return nil, fmt.Errorf("Invalid BULK_FLUSH_INTERVAL.  Must be a duration such as '5s'.")
+		}
+	}
+	c.indexer = newIndexer(&c, maxBytes, maxDocs, flushInterval)
+	if len(os.Getenv("SPOOL_DIR")) > 0 {
+		maxSpool := int64(defaultSpoolMaxBytes)
+		if len(os.Getenv("SPOOL_MAX_BYTES")) > 0 {
+			if maxSpool, err = strconv.ParseInt(os.Getenv("SPOOL_MAX_BYTES"), 10, 64); err != nil || maxSpool < 1 {
+				return nil, fmt.Errorf("Invalid SPOOL_MAX_BYTES.  Must be a positive number of bytes.")
+			}
+		}
+		if c.spool, err = newSpool(&c, os.Getenv("SPOOL_DIR"), maxSpool); err != nil {
+			return nil, fmt.Errorf("Error opening SPOOL_DIR: %s", err.Error())
+		}
+	}
+	if len(os.Getenv("RETENTION_DAYS")) > 0 {
+		days, err := strconv.Atoi(os.Getenv("RETENTION_DAYS"))
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("Invalid RETENTION_DAYS.  Must be a positive number of days.")
+		}
+		c.retention = &retentionPolicy{days: days, action: "delete", dryRun: len(os.Getenv("RETENTION_DRY_RUN")) > 0}
+		switch os.Getenv("RETENTION_ACTION") {
+		case "", "delete":
+		case "close":
+			c.retention.action = "close"
+		default:
+			return nil, fmt.Errorf("Invalid RETENTION_ACTION.  Must be one of 'delete' or 'close'.")
+		}
+	}
+
+	if len(os.Getenv("FILTER_RULES")) > 0 {
+		f, err := loadFilterRules(os.Getenv("FILTER_RULES"))
+		if err != nil {
+			return nil, fmt.Errorf("Error loading FILTER_RULES: %s", err.Error())
+		}
+		c.filters = f
+	}
+	if len(os.Getenv("WEB_ROLES_FILE")) > 0 {
+		if c.auth == nil && c.oidc == nil {
+			return nil, fmt.Errorf("Must set WEB_AUTH_USER, WEB_AUTH_FILE or OIDC_ISSUER with WEB_ROLES_FILE, so users can be told apart.")
+		}
+		if c.roles, err = loadWebRoles(os.Getenv("WEB_ROLES_FILE"), &c); err != nil {
+			return nil, fmt.Errorf("Error loading WEB_ROLES_FILE: %s", err.Error())
+		}
+	}
+	if err := c.parseOutputs(); err != nil {
+		return nil, err
+	}
 	if len(os.Getenv("SQS_PERSIST")) > 0 {
 		c.sqsPersist = true
 	}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultWebhookBatchSize = 100
+	webhookAttempts         = 5
+	webhookRetryDelay       = 1 * time.Second
+)
+
+// webhookOutput POSTs events to an HTTP endpoint, such as a ticketing system or a Lambda
+// function URL, in batches rendered by a template
+type webhookOutput struct {
+	c           *config
+	url         string
+	headers     http.Header
+	batchSize   int
+	body        *template.Template // nil for a JSON array of the documents
+	contentType string
+	secret      []byte // HMAC key to sign bodies with, if any
+	client      *http.Client
+	retryDelay  time.Duration
+	pending     chan struct{} // limits files being sent at once
+}
+
+// webhookBatch is what WEBHOOK_TEMPLATE is rendered with
+type webhookBatch struct {
+	Events []*outputEvent
+}
+
+// webhookFuncs are available to WEBHOOK_TEMPLATE: {{json .Record.UserIdentity}} renders a value
+// as JSON, and {{js .Record.ErrorMessage}} escapes a string for a JSON or JavaScript string
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookOutputFromEnv(c *config) (*webhookOutput, error) {
+	o := &webhookOutput{
+		c:           c,
+		url:         os.Getenv("WEBHOOK_URL"),
+		headers:     http.Header{},
+		batchSize:   defaultWebhookBatchSize,
+		contentType: os.Getenv("WEBHOOK_CONTENT_TYPE"),
+		secret:      []byte(os.Getenv("WEBHOOK_HMAC_SECRET")),
+		retryDelay:  webhookRetryDelay,
+		pending:     make(chan struct{}, maxPendingFiles),
+	}
+	if len(o.url) < 1 {
+		return nil, fmt.Errorf("Must set WEBHOOK_URL for the webhook output.")
+	}
+	if len(o.contentType) < 1 {
+		o.contentType = "application/json"
+	}
+	if len(os.Getenv("WEBHOOK_HEADERS")) > 0 {
+		var err error
+		if o.headers, err = parseWebhookHeaders(os.Getenv("WEBHOOK_HEADERS")); err != nil {
+			return nil, err
+		}
+	}
+	if len(os.Getenv("WEBHOOK_BATCH_SIZE")) > 0 {
+		n, err := strconv.Atoi(os.Getenv("WEBHOOK_BATCH_SIZE"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid WEBHOOK_BATCH_SIZE.  Must be a positive number of events.")
+		}
+		o.batchSize = n
+	}
+
+	text := os.Getenv("WEBHOOK_TEMPLATE")
+	if file := os.Getenv("WEBHOOK_TEMPLATE_FILE"); len(file) > 0 {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading WEBHOOK_TEMPLATE_FILE: %s", err.Error())
+		}
+		text = string(b)
+	}
+	if len(text) > 0 {
+		var err error
+		if o.body, err = template.New("webhook").Funcs(webhookFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("Invalid WEBHOOK_TEMPLATE: %s", err.Error())
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(os.Getenv("WEBHOOK_CA_FILE")) > 0 {
+		pem, err := ioutil.ReadFile(os.Getenv("WEBHOOK_CA_FILE"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading WEBHOOK_CA_FILE: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in WEBHOOK_CA_FILE %s", os.Getenv("WEBHOOK_CA_FILE"))
+		}
+	}
+	o.client = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	return o, nil
+}
+
+// parseWebhookHeaders reads a JSON object of header names and values.  JSON, unlike a separator,
+// can't clash with the commas and semicolons header values are full of.
+func parseWebhookHeaders(s string) (http.Header, error) {
+	fields := map[string]string{}
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return nil, fmt.Errorf("Invalid WEBHOOK_HEADERS.  Must be a JSON object of header names and values, such as '{\"Authorization\": \"Bearer abc123\"}'.")
+	}
+	headers := http.Header{}
+	for name, value := range fields {
+		if len(name) < 1 || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("Invalid WEBHOOK_HEADERS header %q.  Names can't be empty or contain spaces or colons, and values can't contain line breaks.", name)
+		}
+		headers.Set(name, value)
+	}
+	return headers, nil
+}
+
+func (o *webhookOutput) send(events []*outputEvent, done func(error)) {
+	o.pending <- struct{}{}
+	go func() {
+		err := o.sendAll(events)
+		<-o.pending
+		if err != nil {
+			err = fmt.Errorf("Error sending to webhook: %s", err.Error())
+		}
+		done(err)
+	}()
+}
+
+// sendAll posts the events of one file in batches of up to WEBHOOK_BATCH_SIZE
+func (o *webhookOutput) sendAll(events []*outputEvent) error {
+	for start := 0; start < len(events); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		body, err := o.render(events[start:end])
+		if err != nil {
+			return err
+		}
+		if err := o.post(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render builds the body of a batch from WEBHOOK_TEMPLATE, or as a JSON array of documents
+func (o *webhookOutput) render(events []*outputEvent) ([]byte, error) {
+	var b bytes.Buffer
+	if o.body != nil {
+		if err := o.body.Execute(&b, &webhookBatch{Events: events}); err != nil {
+			return nil, fmt.Errorf("Error rendering WEBHOOK_TEMPLATE: %s", err.Error())
+		}
+		return b.Bytes(), nil
+	}
+	b.WriteString("[")
+	for n, e := range events {
+		if n > 0 {
+			b.WriteString(",")
+		}
+		b.Write(e.Doc)
+	}
+	b.WriteString("]")
+	return b.Bytes(), nil
+}
+
+// post sends one batch, retrying with backoff while the endpoint is unreachable or failing
+func (o *webhookOutput) post(body []byte) error {
+	delay := o.retryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := o.postOnce(body)
+		if err == nil {
+			return nil
+		} else if !retry || attempt >= webhookAttempts {
+			return err
+		}
+		log.Printf("Webhook request failed, retrying in %s: %s", delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postOnce sends a batch and returns whether a failure is worth retrying
+func (o *webhookOutput) postOnce(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range o.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", o.contentType)
+	if len(o.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Traildash-Timestamp", timestamp)
+		req.Header.Set("X-Traildash-Signature", "sha256="+webhookSignature(o.secret, timestamp, body))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// other 4xx errors mean the request is wrong, which retrying won't fix
+		retry := resp.StatusCode >= 500 || resp.StatusCode == 429 || resp.StatusCode == 408
+		return retry, fmt.Errorf("%s from %s: %s", resp.Status, o.url, strings.TrimSpace(string(b)))
+	}
+	return false, nil
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "<timestamp>.<body>".  Signing the timestamp too
+// lets receivers reject replayed requests.
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
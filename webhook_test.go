@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// webhookStandIn is a receiver which checks the signature of every request, keeps the bodies
+// it accepts and answers with the queued statuses before succeeding
+type webhookStandIn struct {
+	t        *testing.T
+	secret   string
+	lock     sync.Mutex
+	statuses []int
+	requests int
+	bodies   []string
+	headers  http.Header // of the last request
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	s.headers = r.Header
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(s.secret) > 0 {
+		timestamp := r.Header.Get("X-Traildash-Timestamp")
+		if ts, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+			s.t.Errorf("timestamp %q", timestamp)
+		}
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(r.Header.Get("X-Traildash-Signature")), []byte(want)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "bad signature %s", r.Header.Get("X-Traildash-Signature"))
+			return
+		}
+	}
+	if len(s.statuses) > 0 {
+		status := s.statuses[0]
+		s.statuses = s.statuses[1:]
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "status %d", status)
+		return
+	}
+	s.bodies = append(s.bodies, string(body))
+}
+
+func testWebhookOutput(url string) *webhookOutput {
+	return &webhookOutput{
+		url:         url,
+		headers:     http.Header{},
+		batchSize:   defaultWebhookBatchSize,
+		contentType: "application/json",
+		client:      &http.Client{},
+		retryDelay:  10 * time.Millisecond,
+		pending:     make(chan struct{}, 1),
+	}
+}
+
+func webhookEvents(n int) []*outputEvent {
+	events := []*outputEvent{}
+	for i := 0; i < n; i++ {
+		events = append(events, &outputEvent{
+			Record: &cloudtrailRecord{EventName: fmt.Sprintf("Event%d", i), ErrorMessage: `say "hi"`},
+			Doc:    json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)),
+		})
+	}
+	return events
+}
+
+func TestParseWebhookHeaders(t *testing.T) {
+	h, err := parseWebhookHeaders(`{"Authorization": "Digest username=\"a\", realm=\"b\", nonce=\"c\"", "accept": "application/json, text/plain;q=0.5"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Get("Authorization"); got != `Digest username="a", realm="b", nonce="c"` {
+		t.Errorf("Authorization %q", got)
+	}
+	if got := h["Accept"]; len(got) != 1 || got[0] != "application/json, text/plain;q=0.5" {
+		t.Errorf("Accept %q", got)
+	}
+
+	for _, invalid := range []string{
+		`Authorization: Bearer abc123`,
+		`["Authorization"]`,
+		`{"Authorization": 1}`,
+		`{"": "empty"}`,
+		`{"X Source": "traildash"}`,
+		`{"X-Source:": "traildash"}`,
+		`{"X-Source": "traildash\r\nX-Injected: 1"}`,
+	} {
+		if _, err := parseWebhookHeaders(invalid); err == nil || !strings.HasPrefix(err.Error(), "Invalid WEBHOOK_HEADERS") {
+			t.Errorf("%s: error %v", invalid, err)
+		}
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	receiver := &webhookStandIn{t: t, secret: "webhook-secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	o := testWebhookOutput(server.URL)
+	o.secret = []byte("webhook-secret")
+	o.batchSize = 2
+	o.headers.Set("Accept", "application/json, text/plain")
+	result := make(chan error, 1)
+	o.send(webhookEvents(3), func(err error) { result <- err })
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if receiver.requests != 2 || strings.Join(receiver.bodies, " ") != `[{"n":0},{"n":1}] [{"n":2}]` {
+		t.Errorf("%d requests with bodies %q, want two batches", receiver.requests, receiver.bodies)
+	}
+	if got := receiver.headers.Get("Accept"); got != "application/json, text/plain" {
+		t.Errorf("Accept %q", got)
+	}
+	if got := receiver.headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+
+	// a wrong secret makes a signature the receiver refuses, which isn't retried
+	receiver.requests, receiver.bodies = 0, nil
+	o.secret = []byte("wrong-secret")
+	if err := o.sendAll(webhookEvents(1)); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrongly signed request: error %v", err)
+	}
+	if receiver.requests != 1 {
+		t.Errorf("%d requests, want a refused request sent once", receiver.requests)
+	}
+
+	// without a secret there is no signature
+	o.secret = nil
+	receiver.secret = ""
+	if err := o.sendAll(webhookEvents(1)); err != nil {
+		t.Fatal(err)
+	}
+	if sig := receiver.headers.Get("X-Traildash-Signature"); len(sig) > 0 {
+		t.Errorf("unsigned request has signature %s", sig)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	receiver := &webhookStandIn{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	o := testWebhookOutput(server.URL)
+	o.batchSize = 1
+	o.body = template.Must(template.New("webhook").Funcs(webhookFuncs).Parse(
+		`{{range .Events}}{"title": {{json .Record.EventName}}, "message": "{{js .Record.ErrorMessage}}", "event": {{printf "%s" .Doc}}}{{end}}`))
+	if err := o.sendAll(webhookEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(receiver.bodies) != 2 {
+		t.Fatalf("%d bodies, want one for each event", len(receiver.bodies))
+	}
+	for n, body := range receiver.bodies {
+		var ticket struct {
+			Title   string
+			Message string
+			Event   map[string]int
+		}
+		if err := json.Unmarshal([]byte(body), &ticket); err != nil {
+			t.Fatalf("invalid JSON %q: %s", body, err)
+		}
+		if ticket.Title != fmt.Sprintf("Event%d", n) || ticket.Message != `say "hi"` || ticket.Event["n"] != n {
+			t.Errorf("body %s", body)
+		}
+	}
+
+	// a template which fails to render fails the file without sending anything
+	o.body = template.Must(template.New("webhook").Parse(`{{.Missing}}`))
+	receiver.requests = 0
+	if err := o.sendAll(webhookEvents(1)); err == nil || receiver.requests != 0 {
+		t.Errorf("failed template: error %v after %d requests", err, receiver.requests)
+	}
+}
+
+func TestWebhookStatus(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		requests int
+		ok       bool
+	}{
+		{[]int{503, 502}, 3, true},
+		{[]int{429}, 2, true},
+		{[]int{408}, 2, true},
+		{[]int{400}, 1, false},
+		{[]int{404}, 1, false},
+		{[]int{500, 500, 500, 500, 500}, webhookAttempts, false},
+	}
+	for _, tt := range tests {
+		receiver := &webhookStandIn{t: t, statuses: tt.statuses}
+		server := httptest.NewServer(receiver)
+		err := testWebhookOutput(server.URL).sendAll(webhookEvents(1))
+		server.Close()
+		if (err == nil) != tt.ok || receiver.requests != tt.requests {
+			t.Errorf("%v: %d requests and error %v, want %d requests", tt.statuses, receiver.requests, err, tt.requests)
+		}
+	}
+}